@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"DoubaoProxy/internal/config"
+	"DoubaoProxy/internal/service/doubao"
+	"DoubaoProxy/internal/session"
+	"DoubaoProxy/internal/store"
+)
+
+// runDoctor 实现 doctor 子命令：逐个体检 Session 并输出报告。
+// 全部 Session 通过时返回 0，否则返回 1，便于在脚本或 CI 中使用。
+func runDoctor(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "以 JSON 输出报告")
+	timeout := fs.Duration("timeout", 30*time.Second, "整体体检超时时间")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// 报告写到 stdout，日志只保留告警并写到 stderr。
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	slog.SetDefault(logger)
+
+	pool, err := loadToolPool(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	service, err := doubao.NewService(pool, nil, cfg, logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "create doubao service:", err)
+		return 1
+	}
+	defer service.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	results := service.ProbeAll(ctx)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(results)
+	} else {
+		printDoctorReport(os.Stdout, results)
+	}
+
+	if len(results) == 0 {
+		return 1
+	}
+	for _, r := range results {
+		if r.Status != doubao.ProbeOK {
+			return 1
+		}
+	}
+	return 0
+}
+
+// loadToolPool 为 doctor、import 等子命令加载与服务相同的 Session 池：配置之上叠加状态存储中
+// 管理接口的修改与轮换后的 Cookie。状态存储读入内存副本后即释放，子命令不会改动它。
+func loadToolPool(cfg config.Config) (*session.Pool, error) {
+	key, err := sessionKey(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("session key: %w", err)
+	}
+	st, err := store.OpenSnapshot(cfg.StateStore, cfg.StatePath)
+	if err != nil {
+		return nil, fmt.Errorf("read state store: %w", err)
+	}
+	pool, err := session.NewPool(cfg.SessionConfigPath, session.Options{ConfigDir: cfg.SessionConfigDir, Key: key, Store: st})
+	if err != nil {
+		return nil, fmt.Errorf("load session pool: %w", err)
+	}
+	return pool, nil
+}
+
+func printDoctorReport(w io.Writer, results []doubao.ProbeResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SESSION\tPROVIDER\tKIND\tSTATUS\tLATENCY\tDETAIL")
+	for _, r := range results {
+		kind := "account"
+		if r.Guest {
+			kind = "guest"
+		}
+		detail := r.Error
+		if r.Mismatch != "" {
+			if detail != "" {
+				detail += "; "
+			}
+			detail += r.Mismatch
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.SessionID, r.Provider, kind, r.Status, r.Latency.Round(time.Millisecond), detail)
+	}
+	tw.Flush()
+	if len(results) == 0 {
+		fmt.Fprintln(w, "no sessions configured")
+	}
+}
+
+// probeOnStartup 在开始接收流量前体检全部 Session，失效的 Session 会被标记为不可用。
+func probeOnStartup(service *doubao.Service, timeout time.Duration, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, r := range service.ProbeAll(ctx) {
+		attrs := []any{"session", r.SessionID, "provider", r.Provider, "guest", r.Guest, "status", r.Status, "latency", r.Latency.String()}
+		if r.Mismatch != "" {
+			attrs = append(attrs, "mismatch", r.Mismatch)
+		}
+		if r.Error != "" {
+			attrs = append(attrs, "error", r.Error)
+		}
+		if r.Status == doubao.ProbeOK {
+			logger.Info("session probe passed", attrs...)
+		} else {
+			logger.Warn("session probe failed", attrs...)
+		}
+	}
+}
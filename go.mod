@@ -6,6 +6,9 @@ require (
 	github.com/aws/aws-sdk-go-v2 v1.39.2
 	github.com/gin-gonic/gin v1.10.0
 	github.com/google/uuid v1.6.0
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/crypto v0.23.0
+	golang.org/x/term v0.20.0
 )
 
 require (
@@ -30,7 +33,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.org/x/arch v0.8.0 // indirect
-	golang.org/x/crypto v0.23.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/text v0.15.0 // indirect
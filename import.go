@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"DoubaoProxy/internal/config"
+	"DoubaoProxy/internal/importer"
+)
+
+// runImport 实现 import 子命令：从 HAR、cURL 命令或 Cookie 文件中提取 Session。
+// 默认把结果以 JSON 输出到 stdout；指定 -write 时校验、去重后写入 Session 配置文件。
+func runImport(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "输入格式：har、curl 或 jar，留空时自动识别")
+	rawURL := fs.String("url", "", "抓取到的请求 URL，导入 Cookie 文件时必填")
+	roomID := fs.String("room-id", "", "覆盖提取到的 room_id")
+	flowTrace := fs.String("x-flow-trace", "", "覆盖提取到的 x_flow_trace")
+	guest := fs.String("guest", "", "覆盖游客判断：true 或 false")
+	write := fs.Bool("write", false, "写入 SESSION_CONFIG 指向的配置文件")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: DoubaoProxy import [flags] <file|->")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		if err == nil {
+			fs.Usage()
+		}
+		return 2
+	}
+
+	var data []byte
+	var err error
+	if name := fs.Arg(0); name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "read input:", err)
+		return 1
+	}
+
+	opts := importer.Options{URL: *rawURL, RoomID: *roomID, XFlowTrace: *flowTrace}
+	switch *guest {
+	case "":
+	case "true", "false":
+		v := *guest == "true"
+		opts.Guest = &v
+	default:
+		fmt.Fprintln(os.Stderr, "-guest must be true or false")
+		return 2
+	}
+
+	entries, err := importer.Parse(importer.Format(*format), data, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import:", err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "    ")
+	if !*write {
+		_ = enc.Encode(entries)
+		return 0
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	// 与 doctor 相同，池中包含管理接口新增与修改的 Session，查重与写回都以它们为准。
+	pool, err := loadToolPool(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	report := importer.AddToPool(pool, entries)
+	if len(report.Added) > 0 {
+		if err := pool.SaveConfig(); err != nil {
+			fmt.Fprintln(os.Stderr, "write session config:", err)
+			return 1
+		}
+	}
+	_ = enc.Encode(report)
+	if len(report.Added) == 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,207 @@
+// Package cassette 实现录制/回放上游 HTTP 流量的 RoundTripper。
+// 录制模式下把真实请求与响应（包括流式 SSE 响应体）写入 JSON 卡带，
+// 并对 Cookie、签名与上传凭证脱敏；回放模式下按顺序返回卡带中的响应。
+package cassette
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Mode 表示卡带的工作模式。
+type Mode string
+
+const (
+	ModeOff    Mode = ""
+	ModeRecord Mode = "record"
+	ModeReplay Mode = "replay"
+)
+
+// Redacted 是脱敏后写入卡带的占位值。
+const Redacted = "REDACTED"
+
+// Cassette 是一份录制结果，按发生顺序保存全部交互。
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction 是一次请求与对应响应。
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request 描述录制下来的上游请求。
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+// Response 描述录制下来的上游响应。
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+// Body 在卡带中以文本保存，非 UTF-8 内容（如上传的图片）以 base64 保存。
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(b)})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*b = Body(text)
+		return nil
+	}
+	var encoded struct {
+		Base64 string `json:"base64"`
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("decode cassette body: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded.Base64)
+	if err != nil {
+		return fmt.Errorf("decode cassette body: %w", err)
+	}
+	*b = raw
+	return nil
+}
+
+// Load 从文件读取卡带。
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read cassette: %w", err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("decode cassette: %w", err)
+	}
+	return &c, nil
+}
+
+// Save 以原子替换的方式将卡带写入文件。
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode cassette: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create cassette dir: %w", err)
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write cassette: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace cassette: %w", err)
+	}
+	return nil
+}
+
+// Wrap 根据模式包装 next。ModeOff 时原样返回 next。
+func Wrap(mode Mode, path string, next http.RoundTripper, onError func(error)) (http.RoundTripper, error) {
+	switch mode {
+	case ModeOff:
+		return next, nil
+	case ModeRecord:
+		return NewRecorder(path, next, onError), nil
+	case ModeReplay:
+		return NewReplayer(path)
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q", mode)
+	}
+}
+
+var sensitiveHeaders = []string{
+	"Cookie",
+	"Authorization",
+	"X-Amz-Security-Token",
+	"X-Flow-Trace",
+}
+
+var sensitiveQuery = []string{
+	"X-Amz-Signature",
+	"X-Amz-Credential",
+	"X-Amz-Security-Token",
+}
+
+// sensitiveFields 匹配上传凭证等 JSON 字段，脱敏后值替换为 Redacted。
+var sensitiveFields = regexp.MustCompile(`"(access_key|secret_key|session_token|Auth)"(\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+// redactHeader 脱敏请求头与响应头。Set-Cookie 直接丢弃而不是替换为占位值，
+// 否则回放时占位值会被当作上游轮换的 Cookie 写入 Session。
+func redactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	out.Del("Set-Cookie")
+	for _, key := range sensitiveHeaders {
+		if values := out.Values(key); len(values) > 0 {
+			redacted := make([]string, len(values))
+			for i := range redacted {
+				redacted[i] = Redacted
+			}
+			out[http.CanonicalHeaderKey(key)] = redacted
+		}
+	}
+	return out
+}
+
+func redactURL(u *url.URL) string {
+	clone := *u
+	q := clone.Query()
+	changed := false
+	for _, key := range sensitiveQuery {
+		if q.Has(key) {
+			q.Set(key, Redacted)
+			changed = true
+		}
+	}
+	if changed {
+		clone.RawQuery = q.Encode()
+	}
+	return clone.String()
+}
+
+func redactBody(body []byte) []byte {
+	if !utf8.Valid(body) || !sensitiveFields.Match(body) {
+		return body
+	}
+	return sensitiveFields.ReplaceAll(body, []byte(`"$1"$2"`+Redacted+`"`))
+}
+
+// matchKey 决定回放时如何匹配请求：方法、路径以及 ImageX 的 Action 参数。
+// 主机与其余查询参数（设备号等）不参与匹配，使卡带可以在不同配置下复用。
+func matchKey(method string, u *url.URL) string {
+	key := method + " " + u.Path
+	if action := u.Query().Get("Action"); action != "" {
+		key += "?Action=" + action
+	}
+	return key
+}
+
+// ErrNoInteraction 表示回放时卡带中没有可匹配的交互。
+var ErrNoInteraction = errors.New("cassette: no matching interaction")
+
+func isEventStream(h http.Header) bool {
+	return strings.HasPrefix(h.Get("Content-Type"), "text/event-stream")
+}
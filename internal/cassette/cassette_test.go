@@ -0,0 +1,192 @@
+package cassette
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upstream 模拟一个会下发 Set-Cookie 与上传凭证的上游。
+func upstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "msToken", Value: "rotated-hunter2"})
+		switch r.URL.Path {
+		case "/samantha/chat/completion":
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: {\"event_type\":2001}\n\ndata: {\"event_type\":2003}\n\n")
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"data":{"upload_auth_token":{"access_key":"ak-hunter2","secret_key":"sk-hunter2","session_token":"st-hunter2"}}}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, rt http.RoundTripper, rawURL string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func record(t *testing.T) (string, string) {
+	t.Helper()
+	srv := upstream(t)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	rec := NewRecorder(path, nil, nil)
+
+	header := http.Header{"Cookie": {"sessionid=hunter2"}, "Authorization": {"AWS4-HMAC-SHA256 Credential=hunter2"}}
+	get(t, rec, srv.URL+"/alice/resource/prepare_upload?X-Amz-Signature=hunter2", header)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("cassette written before Flush: %v", err)
+	}
+	if err := rec.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	get(t, rec, srv.URL+"/samantha/chat/completion", header)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, srv.URL
+}
+
+func TestRecordRedacts(t *testing.T) {
+	path, _ := record(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Errorf("cassette leaks a secret:\n%s", data)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Interactions) != 2 {
+		t.Fatalf("interactions = %d, want 2", len(c.Interactions))
+	}
+	for _, it := range c.Interactions {
+		if got := it.Request.Header.Get("Cookie"); got != Redacted {
+			t.Errorf("request cookie = %q", got)
+		}
+		if values := it.Response.Header.Values("Set-Cookie"); len(values) != 0 {
+			t.Errorf("Set-Cookie recorded: %q", values)
+		}
+	}
+	if !strings.Contains(c.Interactions[0].Request.URL, "X-Amz-Signature="+Redacted) {
+		t.Errorf("url = %s", c.Interactions[0].Request.URL)
+	}
+	if !strings.Contains(string(c.Interactions[1].Response.Body), `"event_type":2003`) {
+		t.Errorf("streamed body not recorded in full: %s", c.Interactions[1].Response.Body)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	path, _ := record(t)
+	r, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 主机与其余查询参数不参与匹配；同一匹配键消费完后重复返回最后一次。
+	for i := 0; i < 2; i++ {
+		resp, body := get(t, r, "https://other.example/samantha/chat/completion?device_id=1", nil)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, `"event_type":2003`) {
+			t.Fatalf("replay %d: status = %d, body = %s", i, resp.StatusCode, body)
+		}
+		if values := resp.Header.Values("Set-Cookie"); len(values) != 0 {
+			t.Errorf("replayed Set-Cookie: %q", values)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://other.example/unknown", nil)
+	if _, err := r.RoundTrip(req); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("err = %v, want ErrNoInteraction", err)
+	}
+}
+
+func TestEarlyCloseRecordsRestOfStream(t *testing.T) {
+	srv := upstream(t)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	rec := NewRecorder(path, nil, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/samantha/chat/completion", nil)
+	resp, err := rec.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只读一个字节就关闭，剩余部分由后台读完。
+	if _, err := resp.Body.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Interactions) != 1 || !strings.Contains(string(c.Interactions[0].Response.Body), `"event_type":2003`) {
+		t.Errorf("interactions = %+v, want the full stream", c.Interactions)
+	}
+}
+
+func TestEarlyCloseDoesNotWaitForUpstream(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"event_type\":2001}\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	rec := NewRecorder(path, nil, nil)
+	rec.drainTimeout = 500 * time.Millisecond
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/samantha/chat/completion", nil)
+	resp, err := rec.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resp.Body.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Close blocked for %v", elapsed)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 上游未结束，不完整的录制被丢弃。
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("partial interaction saved: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package cassette
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultDrainTimeout 为提前关闭的响应体在后台读完剩余部分的时限。
+	defaultDrainTimeout = 2 * time.Second
+	// drainLimit 为提前关闭后最多继续读取的字节数。
+	drainLimit = 4 << 20
+)
+
+// Recorder 透传请求到真实上游，同时在内存中记下脱敏后的交互，Flush 或 Close 时写入卡带文件。
+// 响应体以边读边录的方式处理，调用方仍能流式消费 SSE。
+type Recorder struct {
+	path    string
+	next    http.RoundTripper
+	onError func(error)
+	// drainTimeout 见 defaultDrainTimeout。
+	drainTimeout time.Duration
+	// drains 跟踪后台读取中的响应体，Close 写文件前等待它们结束。
+	drains sync.WaitGroup
+
+	mu       sync.Mutex
+	cassette Cassette
+	// dirty 表示有尚未写入文件的交互。
+	dirty bool
+}
+
+// NewRecorder 创建录制器。next 为空时使用 http.DefaultTransport；
+// onError 用于报告卡带写入失败，可为空。录制结束时须调用 Close，否则交互不会写入文件。
+func NewRecorder(path string, next http.RoundTripper, onError func(error)) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{path: path, next: next, onError: onError, drainTimeout: defaultDrainTimeout}
+}
+
+// RoundTrip 实现 http.RoundTripper。
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cassette: read request body: %w", err)
+		}
+		reqBody = data
+		clone := req.Clone(req.Context())
+		clone.Body = io.NopCloser(bytes.NewReader(data))
+		req = clone
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    redactURL(req.URL),
+			Header: redactHeader(req.Header),
+			Body:   redactBody(reqBody),
+		},
+		Response: Response{
+			Status: resp.StatusCode,
+			Header: redactHeader(resp.Header),
+		},
+	}
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		recorder:   r,
+		finish: func(body []byte) {
+			interaction.Response.Body = redactBody(body)
+			r.append(interaction)
+		},
+	}
+	return resp, nil
+}
+
+func (r *Recorder) append(interaction Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.dirty = true
+}
+
+// Flush 把目前录下的全部交互写入卡带文件，没有新交互时不写。
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.dirty {
+		return nil
+	}
+	if err := r.cassette.Save(r.path); err != nil {
+		if r.onError != nil {
+			r.onError(err)
+		}
+		return err
+	}
+	r.dirty = false
+	return nil
+}
+
+// Close 等待提前关闭的响应体读完（至多 drainTimeout），再写入尚未保存的交互。
+func (r *Recorder) Close() error {
+	r.drains.Wait()
+	return r.Flush()
+}
+
+// recordingBody 在读取响应体的同时保留一份副本，读到 EOF 时提交录制。
+type recordingBody struct {
+	io.ReadCloser
+	recorder  *Recorder
+	buf       bytes.Buffer
+	once      sync.Once
+	closeOnce sync.Once
+	finish    func([]byte)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.commit()
+	}
+	return n, err
+}
+
+// Close 立即返回。调用方可能在读完前关闭（例如 SSE 收到结束事件后），剩余部分在后台读完后一并录下；
+// 上游迟迟不结束（例如客户端断开时仍在生成）或超过 drainLimit 时放弃这次不完整的录制，不阻塞调用方。
+func (b *recordingBody) Close() error {
+	b.closeOnce.Do(func() {
+		r := b.recorder
+		r.drains.Add(1)
+		go func() {
+			defer r.drains.Done()
+			timer := time.AfterFunc(r.drainTimeout, func() { b.ReadCloser.Close() })
+			n, err := io.Copy(&b.buf, io.LimitReader(b.ReadCloser, drainLimit))
+			if timer.Stop() && err == nil && n < drainLimit {
+				b.commit()
+			}
+			b.ReadCloser.Close()
+		}()
+	})
+	return nil
+}
+
+func (b *recordingBody) commit() {
+	b.once.Do(func() { b.finish(b.buf.Bytes()) })
+}
@@ -0,0 +1,93 @@
+package cassette
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Replayer 从卡带中按顺序返回响应，不会访问网络。
+// 同一匹配键的交互依次消费，消费完后重复返回最后一次，便于重试类场景。
+type Replayer struct {
+	mu     sync.Mutex
+	queues map[string][]Interaction
+	last   map[string]Interaction
+}
+
+// NewReplayer 从 path 加载卡带并创建回放器。
+func NewReplayer(path string) (*Replayer, error) {
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayerFrom(c)
+}
+
+// NewReplayerFrom 基于内存中的卡带创建回放器。
+func NewReplayerFrom(c *Cassette) (*Replayer, error) {
+	r := &Replayer{
+		queues: make(map[string][]Interaction),
+		last:   make(map[string]Interaction),
+	}
+	for _, it := range c.Interactions {
+		req, err := http.NewRequest(it.Request.Method, it.Request.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cassette: invalid recorded url %q: %w", it.Request.URL, err)
+		}
+		key := matchKey(req.Method, req.URL)
+		r.queues[key] = append(r.queues[key], it)
+	}
+	return r, nil
+}
+
+// RoundTrip 实现 http.RoundTripper。
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+
+	key := matchKey(req.Method, req.URL)
+	r.mu.Lock()
+	it, ok := r.next(key)
+	r.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoInteraction, key)
+	}
+
+	header := it.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	body := []byte(it.Response.Body)
+	if !isEventStream(header) {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", it.Response.Status, http.StatusText(it.Response.Status)),
+		StatusCode:    it.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (r *Replayer) next(key string) (Interaction, bool) {
+	queue := r.queues[key]
+	if len(queue) == 0 {
+		it, ok := r.last[key]
+		return it, ok
+	}
+	it := queue[0]
+	r.queues[key] = queue[1:]
+	r.last[key] = it
+	return it, true
+}
@@ -1,41 +1,247 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"DoubaoProxy/internal/fingerprint"
 )
 
 // Config 描述 HTTP 服务运行时所需的配置项。
 type Config struct {
 	Addr              string
 	SessionConfigPath string
+	SessionConfigDir  string
+	SessionStrategy   string
+	SessionAffinity   string
+	SessionFallback   string
+	SessionKey        string
+	SessionKeyFile    string
+	SessionPassphrase string
+	StateStore        string
+	StatePath         string
+	CooldownBase      time.Duration
+	CooldownMax       time.Duration
+	SuspectThreshold  int
+	FailoverAttempts  int
+	MaxConcurrent     int
+	QueueTimeout      time.Duration
+	PaceMinInterval   time.Duration
+	PaceJitter        time.Duration
+	PaceRatePerMinute float64
+	PaceBurst         int
+	UpstreamMaxRPS    float64
+	BindingTTL        time.Duration
+	BindingMaxEntries int
+	BindingSweep      time.Duration
+	SessionWatch      bool
+	WatchInterval     time.Duration
+	Probe             bool
+	ProbeTimeout      time.Duration
+	GuestMin          int
+	GuestProvider     string
+	GuestInterval     time.Duration
 	ShutdownTimeout   time.Duration
 	HTTPClientTimeout time.Duration
+	UpstreamProxy     string
 	ReadTimeout       time.Duration
 	WriteTimeout      time.Duration
 	AuthToken         string
+	APIKeys           map[string][]string
+	AdminToken        string
+	AdminWriteBack    bool
+	DoubaoBaseURL     string
+	ImageXBaseURL     string
+	TOSBaseURL        string
+	CiciBaseURL       string
+	CiciImageXBaseURL string
+	CiciTOSBaseURL    string
+	DoubaoRegisterURL string
+	CiciRegisterURL   string
+	CassetteMode      string
+	CassettePath      string
+	Fingerprint       fingerprint.Profile
 }
 
 // Load 从环境变量加载配置，并在缺省时应用合理的默认值。
 //
 //	HTTP_ADDR             - HTTP 服务监听地址（默认 :8000）
 //	SESSION_CONFIG        - Session 配置 JSON 的路径（默认 session.json）
+//	SESSION_CONFIG_DIR    - 额外的 Session 配置目录，其中每个 *.json 文件可以是条目数组或单个条目（默认空）
+//	SESSION_KEY           - 加密 Session 配置文件使用的 base64 原始密钥（32 字节），可用 keygen 子命令生成
+//	SESSION_KEY_FILE      - 保存 base64 原始密钥的文件路径，SESSION_KEY 为空时使用
+//	SESSION_PASSPHRASE    - 加密 Session 配置文件使用的口令，以上均为空且文件已加密时在终端提示输入
+//	SESSION_STRATEGY      - 新会话的 Session 选择策略：random、round-robin、least-in-flight、weighted、latency（默认 random）
+//	SESSION_AFFINITY      - 新会话的 Session 亲和性键来源，逗号分隔按序取第一个非空值：user、api-key、header:<Name>（默认空，关闭）
+//	SESSION_FALLBACK      - 新会话在请求的账号类别没有可用 Session 时的回退策略：strict、prefer-auth-fallback-guest、prefer-guest-fallback-auth（默认 strict）
+//	STATE_STORE           - 会话绑定等状态的存储方式：memory 或 bolt（默认 memory，重启后丢失）
+//	STATE_PATH            - bolt 存储的数据库文件路径（默认 data/state.db）
+//	SESSION_COOLDOWN_BASE_S - Session 首次冷却时长，之后指数翻倍，单位秒（默认 60）
+//	SESSION_COOLDOWN_MAX_S  - Session 单次冷却上限，单位秒（默认 3600）
+//	SESSION_SUSPECT_THRESHOLD - 连续瞬时错误达到多少次后进入冷却（默认 3）
+//	SESSION_FAILOVER_ATTEMPTS - 新会话在 Session 故障时最多尝试的 Session 数，1 表示不转移（默认 3）
+//	SESSION_MAX_CONCURRENT - 每个 Session 默认的并发请求上限，可被 session.json 中的 max_concurrent 覆盖（默认 0，不限）
+//	SESSION_QUEUE_TIMEOUT_S - Session 并发已满时请求排队等待的最长时间，单位秒（默认 30）
+//	PACE_MIN_INTERVAL_MS  - 同一 Session 两条消息之间的最短间隔，单位毫秒，可被 session.json 中的 pacing 覆盖（默认 0）
+//	PACE_JITTER_MS        - 在最短间隔之上附加的随机延迟上限，单位毫秒（默认 0）
+//	PACE_RATE_PER_MIN     - 每个 Session 令牌桶每分钟补充的令牌数（默认 0，不限）
+//	PACE_BURST            - 每个 Session 令牌桶的容量（默认 1）
+//	UPSTREAM_MAX_RPS      - 全部 Session 合计每秒发往上游的请求上限（默认 0，不限）
+//	SESSION_BINDING_TTL_S - 会话绑定的最长空闲时间，超时后该会话返回 410，单位秒（默认 604800，即 7 天）
+//	SESSION_BINDING_MAX   - 会话绑定数量上限，超出时淘汰最久未使用的绑定（默认 100000）
+//	SESSION_BINDING_SWEEP_S - 后台清理过期绑定的间隔，单位秒（默认 60）
+//	SESSION_WATCH         - 是否监听 Session 配置文件变化并热加载（默认 true，SIGHUP 始终触发重载）
+//	SESSION_WATCH_INTERVAL_S - 检查配置文件变化的间隔，单位秒（默认 5）
+//	SESSION_PROBE         - 启动时是否先体检全部 Session，失效者在接收流量前即被标记（默认 false）
+//	SESSION_PROBE_TIMEOUT_S - 启动体检的整体超时时间，单位秒（默认 30）
+//	GUEST_MIN             - 自动申请游客 Session，使可用游客 Session 保持在该数量以上（默认 0，关闭）
+//	GUEST_PROVIDER        - 自动申请游客 Session 使用的后端（默认 doubao）
+//	GUEST_INTERVAL_S      - 检查并补充游客 Session 的间隔，单位秒（默认 60）
 //	SHUTDOWN_TIMEOUT_SEC  - 优雅关机等待时间，单位秒（默认 10）
 //	HTTP_CLIENT_TIMEOUT_S - 上游 HTTP 请求超时时间，单位秒（默认 300）
+//	UPSTREAM_PROXY        - 访问上游的默认出站代理（http、https 或 socks5 URL），可被 Session 的 proxy 覆盖（默认空，沿用 HTTPS_PROXY 等环境变量）
 //	HTTP_READ_TIMEOUT_S   - 服务器读取超时时间，单位秒（默认 30）
 //	HTTP_WRITE_TIMEOUT_S  - 服务器写入超时时间，单位秒（默认 30）
 //	AUTH_TOKEN            - 接口认证令牌，留空则关闭认证
-func Load() Config {
-	return Config{
+//	API_KEYS              - 额外的接口令牌及其绑定的 Session 标签，JSON 对象，例如 {"sk-team-a":["team-a"]}，设置后同样开启认证，格式错误时 Load 返回错误
+//	ADMIN_TOKEN           - 管理接口 /admin 的独立令牌，留空则不注册管理接口
+//	ADMIN_WRITE_BACK      - 管理接口的修改是否原子写回 Session 配置文件（默认 false）
+//	DOUBAO_BASE_URL       - 豆包网页接口地址（默认 https://www.doubao.com）
+//	IMAGEX_BASE_URL       - ImageX 上传签名接口地址（默认 https://imagex.bytedanceapi.com）
+//	TOS_BASE_URL          - TOS 文件存储地址（默认 https://tos-d-x-hl.snssdk.com）
+//	CICI_BASE_URL         - Cici（豆包国际版）网页接口地址（默认 https://www.cici.com）
+//	CICI_IMAGEX_BASE_URL  - Cici ImageX 接口地址（默认 https://imagex-ap-singapore-1.bytevcloudapi.com）
+//	CICI_TOS_BASE_URL     - Cici TOS 存储地址（默认 https://tos-alisg-i-ag.ibytedtos.com）
+//	DOUBAO_REGISTER_URL   - 豆包游客设备注册（web_id 申请）地址（默认 https://mcs.snssdk.com/v1/user/webid）
+//	CICI_REGISTER_URL     - Cici 游客设备注册地址（默认 https://mcs-sg.byteoversea.com/v1/user/webid）
+//	UPSTREAM_CASSETTE_MODE - 上游流量录制/回放模式：record 或 replay，留空关闭
+//	UPSTREAM_CASSETTE     - 录制/回放使用的卡带文件路径（默认 testdata/upstream.cassette.json）
+//	DOUBAO_USER_AGENT     - 全局默认 User-Agent
+//	DOUBAO_PC_VERSION     - 全局默认 pc_version
+//	DOUBAO_VERSION_CODE   - 全局默认 version_code
+//	DOUBAO_AID            - 全局默认 aid
+//	DOUBAO_REGION         - 全局默认 region/sys_region
+//	DOUBAO_LANGUAGE       - 全局默认 language
+//	DOUBAO_EXTRA_HEADERS  - 全局额外请求头，JSON 对象，例如 {"Sec-Ch-Ua-Platform":"\"Windows\""}，格式错误时 Load 返回错误
+//
+// 格式错误的 JSON 配置返回错误：服务应拒绝启动，而不是忽略它们后以更宽松的配置运行。
+func Load() (Config, error) {
+	cfg := Config{
 		Addr:              getenv("HTTP_ADDR", ":8000"),
 		SessionConfigPath: getenv("SESSION_CONFIG", "session.json"),
+		SessionConfigDir:  getenv("SESSION_CONFIG_DIR", ""),
+		SessionStrategy:   getenv("SESSION_STRATEGY", "random"),
+		SessionAffinity:   getenv("SESSION_AFFINITY", ""),
+		SessionFallback:   getenv("SESSION_FALLBACK", "strict"),
+		SessionKey:        getenv("SESSION_KEY", ""),
+		SessionKeyFile:    getenv("SESSION_KEY_FILE", ""),
+		SessionPassphrase: getenv("SESSION_PASSPHRASE", ""),
+		StateStore:        strings.ToLower(getenv("STATE_STORE", "memory")),
+		StatePath:         getenv("STATE_PATH", "data/state.db"),
+		CooldownBase:      parseDurationSeconds("SESSION_COOLDOWN_BASE_S", 60),
+		CooldownMax:       parseDurationSeconds("SESSION_COOLDOWN_MAX_S", 3600),
+		SuspectThreshold:  parseInt("SESSION_SUSPECT_THRESHOLD", 3),
+		FailoverAttempts:  parseInt("SESSION_FAILOVER_ATTEMPTS", 3),
+		MaxConcurrent:     parseInt("SESSION_MAX_CONCURRENT", 0),
+		QueueTimeout:      parseDurationSeconds("SESSION_QUEUE_TIMEOUT_S", 30),
+		PaceMinInterval:   parseDurationMillis("PACE_MIN_INTERVAL_MS", 0),
+		PaceJitter:        parseDurationMillis("PACE_JITTER_MS", 0),
+		PaceRatePerMinute: parseFloat("PACE_RATE_PER_MIN", 0),
+		PaceBurst:         parseInt("PACE_BURST", 1),
+		UpstreamMaxRPS:    parseFloat("UPSTREAM_MAX_RPS", 0),
+		BindingTTL:        parseDurationSeconds("SESSION_BINDING_TTL_S", 7*24*3600),
+		BindingMaxEntries: parseInt("SESSION_BINDING_MAX", 100000),
+		BindingSweep:      parseDurationSeconds("SESSION_BINDING_SWEEP_S", 60),
+		SessionWatch:      parseBool("SESSION_WATCH", true),
+		WatchInterval:     parseDurationSeconds("SESSION_WATCH_INTERVAL_S", 5),
+		Probe:             parseBool("SESSION_PROBE", false),
+		ProbeTimeout:      parseDurationSeconds("SESSION_PROBE_TIMEOUT_S", 30),
+		GuestMin:          parseInt("GUEST_MIN", 0),
+		GuestProvider:     getenv("GUEST_PROVIDER", "doubao"),
+		GuestInterval:     parseDurationSeconds("GUEST_INTERVAL_S", 60),
 		ShutdownTimeout:   parseDurationSeconds("SHUTDOWN_TIMEOUT_SEC", 10),
 		HTTPClientTimeout: parseDurationSeconds("HTTP_CLIENT_TIMEOUT_S", 300),
+		UpstreamProxy:     getenv("UPSTREAM_PROXY", ""),
 		ReadTimeout:       parseDurationSeconds("HTTP_READ_TIMEOUT_S", 30),
 		WriteTimeout:      parseDurationSeconds("HTTP_WRITE_TIMEOUT_S", 30),
 		AuthToken:         getenv("AUTH_TOKEN", ""),
+		AdminToken:        getenv("ADMIN_TOKEN", ""),
+		AdminWriteBack:    parseBool("ADMIN_WRITE_BACK", false),
+		DoubaoBaseURL:     trimBaseURL(getenv("DOUBAO_BASE_URL", DefaultDoubaoBaseURL)),
+		ImageXBaseURL:     trimBaseURL(getenv("IMAGEX_BASE_URL", DefaultImageXBaseURL)),
+		TOSBaseURL:        trimBaseURL(getenv("TOS_BASE_URL", DefaultTOSBaseURL)),
+		CiciBaseURL:       trimBaseURL(getenv("CICI_BASE_URL", DefaultCiciBaseURL)),
+		CiciImageXBaseURL: trimBaseURL(getenv("CICI_IMAGEX_BASE_URL", DefaultCiciImageXBaseURL)),
+		CiciTOSBaseURL:    trimBaseURL(getenv("CICI_TOS_BASE_URL", DefaultCiciTOSBaseURL)),
+		DoubaoRegisterURL: getenv("DOUBAO_REGISTER_URL", DefaultDoubaoRegisterURL),
+		CiciRegisterURL:   getenv("CICI_REGISTER_URL", DefaultCiciRegisterURL),
+		CassetteMode:      strings.ToLower(getenv("UPSTREAM_CASSETTE_MODE", "")),
+		CassettePath:      getenv("UPSTREAM_CASSETTE", "testdata/upstream.cassette.json"),
+	}
+
+	var err error
+	if cfg.APIKeys, err = loadAPIKeys(); err != nil {
+		return Config{}, err
+	}
+	if cfg.Fingerprint, err = loadFingerprint(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// 上游接口的默认地址。
+const (
+	DefaultDoubaoBaseURL = "https://www.doubao.com"
+	DefaultImageXBaseURL = "https://imagex.bytedanceapi.com"
+	DefaultTOSBaseURL    = "https://tos-d-x-hl.snssdk.com"
+
+	DefaultCiciBaseURL       = "https://www.cici.com"
+	DefaultCiciImageXBaseURL = "https://imagex-ap-singapore-1.bytevcloudapi.com"
+	DefaultCiciTOSBaseURL    = "https://tos-alisg-i-ag.ibytedtos.com"
+
+	DefaultDoubaoRegisterURL = "https://mcs.snssdk.com/v1/user/webid"
+	DefaultCiciRegisterURL   = "https://mcs-sg.byteoversea.com/v1/user/webid"
+)
+
+// loadFingerprint 读取环境变量中的全局指纹覆盖项，未设置的字段沿用各后端的默认指纹。
+func loadFingerprint() (fingerprint.Profile, error) {
+	override := fingerprint.Profile{
+		UserAgent:   os.Getenv("DOUBAO_USER_AGENT"),
+		PCVersion:   os.Getenv("DOUBAO_PC_VERSION"),
+		VersionCode: os.Getenv("DOUBAO_VERSION_CODE"),
+		AID:         os.Getenv("DOUBAO_AID"),
+		Region:      os.Getenv("DOUBAO_REGION"),
+		Language:    os.Getenv("DOUBAO_LANGUAGE"),
+	}
+	if raw := os.Getenv("DOUBAO_EXTRA_HEADERS"); raw != "" {
+		var headers map[string]string
+		if err := json.Unmarshal([]byte(raw), &headers); err != nil {
+			return fingerprint.Profile{}, fmt.Errorf("DOUBAO_EXTRA_HEADERS: invalid JSON object: %w", err)
+		}
+		override.Headers = headers
 	}
+	return override, nil
+}
+
+// loadAPIKeys 读取 API_KEYS 中的令牌与标签映射。
+func loadAPIKeys() (map[string][]string, error) {
+	raw := os.Getenv("API_KEYS")
+	if raw == "" {
+		return nil, nil
+	}
+	var keys map[string][]string
+	if err := json.Unmarshal([]byte(raw), &keys); err != nil {
+		return nil, fmt.Errorf("API_KEYS: invalid JSON object: %w", err)
+	}
+	return keys, nil
+}
+
+func trimBaseURL(raw string) string {
+	return strings.TrimRight(strings.TrimSpace(raw), "/")
 }
 
 func getenv(key, fallback string) string {
@@ -45,6 +251,54 @@ func getenv(key, fallback string) string {
 	return fallback
 }
 
+func parseBool(key string, fallback bool) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func parseInt(key string, fallback int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
+
+func parseFloat(key string, fallback float64) float64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
+
+func parseDurationMillis(key string, fallback int) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return time.Duration(fallback) * time.Millisecond
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v <= 0 {
+		return time.Duration(fallback) * time.Millisecond
+	}
+	return time.Duration(v) * time.Millisecond
+}
+
 func parseDurationSeconds(key string, fallback int) time.Duration {
 	raw := os.Getenv(key)
 	if raw == "" {
@@ -0,0 +1,39 @@
+package config
+
+import "testing"
+
+func TestLoadAPIKeys(t *testing.T) {
+	t.Setenv("API_KEYS", `{"sk-a":["team-a"],"sk-b":[]}`)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if tags := cfg.APIKeys["sk-a"]; len(tags) != 1 || tags[0] != "team-a" {
+		t.Errorf("APIKeys = %v", cfg.APIKeys)
+	}
+}
+
+func TestLoadRejectsMalformedAPIKeys(t *testing.T) {
+	for _, raw := range []string{`{"sk-a":`, `["sk-a"]`, `{"sk-a":"team-a"}`} {
+		t.Setenv("API_KEYS", raw)
+		if _, err := Load(); err == nil {
+			t.Errorf("API_KEYS=%s: Load succeeded", raw)
+		}
+	}
+}
+
+func TestLoadExtraHeaders(t *testing.T) {
+	t.Setenv("DOUBAO_EXTRA_HEADERS", `{"Sec-Ch-Ua-Platform":"\"Windows\""}`)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := cfg.Fingerprint.Headers["Sec-Ch-Ua-Platform"]; got != `"Windows"` {
+		t.Errorf("header = %q", got)
+	}
+
+	t.Setenv("DOUBAO_EXTRA_HEADERS", `{"Sec-Ch-Ua-Platform":`)
+	if _, err := Load(); err == nil {
+		t.Error("malformed DOUBAO_EXTRA_HEADERS: Load succeeded")
+	}
+}
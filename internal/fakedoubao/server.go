@@ -0,0 +1,492 @@
+// Package fakedoubao 提供一个进程内的豆包上游替身，
+// 模拟聊天 SSE、删除会话、四步上传流程以及游客设备注册，便于在无网络环境下做端到端测试。
+package fakedoubao
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"DoubaoProxy/internal/config"
+)
+
+// 替身签发的固定上传凭证，调用方可据此断言签名流程。
+const (
+	ServiceID    = "fake-service"
+	AccessKey    = "fake-access-key"
+	SecretKey    = "fake-secret-key"
+	SessionToken = "fake-session-token"
+	StoreAuth    = "fake-store-auth"
+)
+
+// Behavior 控制聊天接口的返回内容。零值表示正常返回 "hello from fake doubao"。
+type Behavior struct {
+	// Text 为回复文本，会被拆成多个 2001 事件下发。
+	Text string
+	// Images 为图片生成结果（content_type 2074）。
+	Images []string
+	// TouristLimited 为 true 时模拟游客额度耗尽。
+	TouristLimited bool
+	// LimitMessage 为额度耗尽时下发的提示，留空为豆包的 "tourist conversation reach limited"。
+	LimitMessage string
+	// GatewayError 非空时返回 gateway-error 事件。
+	GatewayError string
+	// Status 非 0 时直接以该状态码返回，不写 SSE。
+	Status int
+}
+
+// Request 记录替身收到的一次请求。
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   []byte
+}
+
+// Server 是基于 httptest.Server 的豆包替身，所有上游域名都指向同一个地址。
+type Server struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	behavior       Behavior
+	deviceBehavior map[string]Behavior
+	requests       []Request
+	conversations  map[string]bool
+	uploads        map[string][]byte
+	sessions       map[string]string
+	seq            atomic.Int64
+}
+
+// New 启动替身服务。调用方负责在结束时 Close。
+func New() *Server {
+	s := &Server{
+		conversations:  make(map[string]bool),
+		deviceBehavior: make(map[string]Behavior),
+		uploads:        make(map[string][]byte),
+		sessions:       make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/samantha/chat/completion", s.handleChat)
+	mux.HandleFunc("/samantha/thread/delete", s.handleDelete)
+	mux.HandleFunc("/alice/resource/prepare_upload", s.handlePrepare)
+	mux.HandleFunc("/upload/v1/", s.handleStore)
+	mux.HandleFunc("/v1/user/webid", s.handleRegister)
+	mux.HandleFunc("/chat/", s.handleChatPage)
+	mux.HandleFunc("/", s.handleImageX)
+
+	s.Server = httptest.NewServer(s.record(mux))
+	return s
+}
+
+// Apply 返回将全部上游地址（包括 Cici 后端）指向替身后的配置副本。
+// 替身对两个后端一视同仁，调用方可通过 Requests 中的 aid 等参数区分来源。
+func (s *Server) Apply(cfg config.Config) config.Config {
+	cfg.DoubaoBaseURL = s.URL
+	cfg.ImageXBaseURL = s.URL
+	cfg.TOSBaseURL = s.URL
+	cfg.CiciBaseURL = s.URL
+	cfg.CiciImageXBaseURL = s.URL
+	cfg.CiciTOSBaseURL = s.URL
+	cfg.DoubaoRegisterURL = s.URL + "/v1/user/webid"
+	cfg.CiciRegisterURL = s.URL + "/v1/user/webid"
+	return cfg
+}
+
+// ApplyCici 返回只将 Cici 后端的上游地址指向替身的配置副本，
+// 与另一个替身的 Apply 搭配使用时，两个后端的请求落在不同的替身上。
+func (s *Server) ApplyCici(cfg config.Config) config.Config {
+	cfg.CiciBaseURL = s.URL
+	cfg.CiciImageXBaseURL = s.URL
+	cfg.CiciTOSBaseURL = s.URL
+	cfg.CiciRegisterURL = s.URL + "/v1/user/webid"
+	return cfg
+}
+
+// SetBehavior 设置后续聊天请求的返回行为。
+func (s *Server) SetBehavior(b Behavior) {
+	s.mu.Lock()
+	s.behavior = b
+	s.mu.Unlock()
+}
+
+// SetDeviceBehavior 为指定 device_id 的聊天请求单独设置返回行为，优先于 SetBehavior。
+// 其中 Status 同样作用于该设备的 prepare_upload。
+func (s *Server) SetDeviceBehavior(deviceID string, b Behavior) {
+	s.mu.Lock()
+	s.deviceBehavior[deviceID] = b
+	s.mu.Unlock()
+}
+
+// Requests 返回目前为止收到的全部请求副本。
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Request, len(s.requests))
+	copy(out, s.requests)
+	return out
+}
+
+// HasConversation 报告会话是否存在（已创建且未删除）。
+func (s *Server) HasConversation(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conversations[id]
+}
+
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.RawQuery,
+			Header: r.Header.Clone(),
+			Body:   body,
+		})
+		s.mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Cookie") == "" {
+		http.Error(w, `{"code":710012001,"msg":"not login"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		ConversationID string `json:"conversation_id"`
+		SectionID      string `json:"section_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	b, ok := s.deviceBehavior[r.URL.Query().Get("device_id")]
+	if !ok {
+		b = s.behavior
+	}
+	conversationID := payload.ConversationID
+	isNew := conversationID == "" || conversationID == "0"
+	known := s.conversations[conversationID]
+	s.mu.Unlock()
+
+	if b.Status != 0 {
+		http.Error(w, http.StatusText(b.Status), b.Status)
+		return
+	}
+	if !isNew && !known {
+		http.Error(w, `{"code":710022002,"msg":"conversation not found"}`, http.StatusNotFound)
+		return
+	}
+
+	s.rotateToken(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	emit := func(event, data string) {
+		if event != "" {
+			fmt.Fprintf(w, "event: %s\n", event)
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	// 失败的请求不会在上游留下会话，因此新会话在通过全部检查后才创建。
+	switch {
+	case b.TouristLimited:
+		msg := b.LimitMessage
+		if msg == "" {
+			msg = "tourist conversation reach limited"
+		}
+		data, _ := json.Marshal(map[string]any{"code": 710022004, "message": msg})
+		emit("", string(data))
+		return
+	case b.GatewayError != "":
+		emit("gateway-error", b.GatewayError)
+		return
+	}
+	if isNew {
+		conversationID = strconv.FormatInt(7_000_000_000+s.seq.Add(1), 10)
+		s.mu.Lock()
+		s.conversations[conversationID] = true
+		s.mu.Unlock()
+	}
+
+	messageID := fmt.Sprintf("m-%d", s.seq.Add(1))
+	sectionID := payload.SectionID
+	if sectionID == "" {
+		sectionID = fmt.Sprintf("s-%d", s.seq.Add(1))
+	}
+	ids := map[string]any{
+		"conversation_id": conversationID,
+		"message_id":      messageID,
+		"section_id":      sectionID,
+	}
+
+	fmt.Fprint(w, ": keep-alive\n\n")
+	emit("", eventJSON(2002, ids))
+
+	text := b.Text
+	if text == "" && len(b.Images) == 0 {
+		text = "hello from fake doubao"
+	}
+	for _, chunk := range splitChunks(text, 4) {
+		emit("", eventJSON(2001, map[string]any{
+			"message": map[string]any{
+				"content_type": 2001,
+				"content":      mustJSON(map[string]string{"text": chunk}),
+			},
+		}))
+	}
+	if len(b.Images) > 0 {
+		creations := make([]map[string]any, 0, len(b.Images))
+		for _, u := range b.Images {
+			creations = append(creations, map[string]any{
+				"image": map[string]any{"status": 2, "image_raw": map[string]string{"url": u}},
+			})
+		}
+		emit("", eventJSON(2001, map[string]any{
+			"message": map[string]any{
+				"content_type": 2074,
+				"content":      mustJSON(map[string]any{"creations": creations}),
+			},
+		}))
+	}
+	emit("", eventJSON(2003, ids))
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ConversationID string `json:"conversation_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	ok := s.conversations[payload.ConversationID]
+	delete(s.conversations, payload.ConversationID)
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, `{"code":710022002,"msg":"conversation not found"}`, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]any{"code": 0, "msg": ""})
+}
+
+func (s *Server) handlePrepare(w http.ResponseWriter, r *http.Request) {
+	// 与上游一致，只有带登录态的 Cookie 才能申请上传凭证，游客 Cookie 一律视为未登录。
+	if cookie := r.Header.Get("Cookie"); !strings.Contains(cookie, "sessionid=") && !strings.Contains(cookie, "sid_tt=") {
+		http.Error(w, `{"code":710012001,"msg":"not login"}`, http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	b := s.deviceBehavior[r.URL.Query().Get("device_id")]
+	s.mu.Unlock()
+	if b.Status != 0 {
+		http.Error(w, http.StatusText(b.Status), b.Status)
+		return
+	}
+	s.rotateToken(w)
+	writeJSON(w, map[string]any{
+		"code": 0,
+		"data": map[string]any{
+			"service_id": ServiceID,
+			"upload_auth_token": map[string]string{
+				"access_key":    AccessKey,
+				"secret_key":    SecretKey,
+				"session_token": SessionToken,
+			},
+		},
+	})
+}
+
+// rotateToken 像真实网页接口一样通过 Set-Cookie 轮换 msToken。
+func (s *Server) rotateToken(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: "msToken", Value: "tok-" + strconv.FormatInt(s.seq.Add(1), 10), Path: "/"})
+}
+
+// handleRegister 模拟设备注册接口，为每次请求签发新的 web_id。
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		AppID int `json:"app_id"`
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.AppID == 0 {
+		writeJSON(w, map[string]any{"e": 1, "message": "invalid app_id"})
+		return
+	}
+	n := 7_400_000_000_000_000_000 + s.seq.Add(1)
+	writeJSON(w, map[string]any{
+		"e":         0,
+		"web_id":    strconv.FormatInt(n, 10),
+		"device_id": strconv.FormatInt(n+100_000_000, 10),
+		"tea_uuid":  strconv.FormatInt(n+200_000_000, 10),
+	})
+}
+
+// handleChatPage 模拟聊天页面：新对话页签发游客 Cookie 并重定向到新的会话页。
+func (s *Server) handleChatPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/chat/" {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html></html>")
+		return
+	}
+	n := s.seq.Add(1)
+	http.SetCookie(w, &http.Cookie{Name: "ttwid", Value: fmt.Sprintf("fake-ttwid-%d", n), Path: "/"})
+	http.SetCookie(w, &http.Cookie{Name: "s_v_web_id", Value: fmt.Sprintf("verify_fake_%d", n), Path: "/"})
+	http.Redirect(w, r, fmt.Sprintf("/chat/%d", 9_000_000+n), http.StatusFound)
+}
+
+// handleImageX 模拟 ImageX 的 ApplyImageUpload 与 CommitImageUpload。
+func (s *Server) handleImageX(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if !strings.Contains(r.Header.Get("Authorization"), "Credential="+AccessKey+"/") {
+		http.Error(w, "missing or invalid signature", http.StatusForbidden)
+		return
+	}
+	if r.Header.Get("X-Amz-Security-Token") != SessionToken {
+		http.Error(w, "missing security token", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("ServiceId") != ServiceID {
+		http.Error(w, "unknown service", http.StatusBadRequest)
+		return
+	}
+
+	switch q.Get("Action") {
+	case "ApplyImageUpload":
+		storeURI := fmt.Sprintf("tos-fake/%d%s", s.seq.Add(1), q.Get("FileExtension"))
+		sessionKey := fmt.Sprintf("session-%d", s.seq.Add(1))
+		s.mu.Lock()
+		s.sessions[sessionKey] = storeURI
+		s.mu.Unlock()
+		writeJSON(w, map[string]any{
+			"Result": map[string]any{
+				"UploadAddress": map[string]any{
+					"StoreInfos": []map[string]string{{"StoreUri": storeURI, "Auth": StoreAuth}},
+					"SessionKey": sessionKey,
+				},
+			},
+		})
+	case "CommitImageUpload":
+		var payload struct {
+			SessionKey string `json:"SessionKey"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		storeURI, ok := s.sessions[payload.SessionKey]
+		data := s.uploads[storeURI]
+		s.mu.Unlock()
+		if !ok || data == nil {
+			http.Error(w, "unknown session key", http.StatusBadRequest)
+			return
+		}
+		sum := md5.Sum(data)
+		writeJSON(w, map[string]any{
+			"Result": map[string]any{
+				"PluginResult": []map[string]any{{
+					"ImageUri":    storeURI,
+					"ImageMd5":    hex.EncodeToString(sum[:]),
+					"ImageSize":   len(data),
+					"ImageWidth":  1,
+					"ImageHeight": 1,
+				}},
+			},
+		})
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+	}
+}
+
+func (s *Server) handleStore(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != StoreAuth {
+		http.Error(w, `{"message":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if want := fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)); r.Header.Get("Content-Crc32") != want {
+		http.Error(w, `{"message":"crc mismatch"}`, http.StatusBadRequest)
+		return
+	}
+
+	storeURI := strings.TrimPrefix(r.URL.Path, "/upload/v1/")
+	s.mu.Lock()
+	s.uploads[storeURI] = data
+	s.mu.Unlock()
+	writeJSON(w, map[string]string{"message": "Success"})
+}
+
+// eventJSON 按上游格式编码事件：event_data 是再次编码成字符串的 JSON。
+func eventJSON(eventType int, data any) string {
+	return mustJSON(map[string]any{
+		"event_type": eventType,
+		"event_data": mustJSON(data),
+	})
+}
+
+func splitChunks(text string, size int) []string {
+	runes := []rune(text)
+	chunks := make([]string, 0, len(runes)/size+1)
+	for len(runes) > 0 {
+		n := min(size, len(runes))
+		chunks = append(chunks, string(runes[:n]))
+		runes = runes[n:]
+	}
+	return chunks
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func mustJSON(v any) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "{}"
+	}
+	return string(buf)
+}
@@ -0,0 +1,62 @@
+package fakedoubao
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func postChat(t *testing.T, s *Server, body string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, s.URL+"/samantha/chat/completion?device_id=d1", strings.NewReader(body))
+	req.Header.Set("Cookie", "sessionid=x")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func TestFailedChatCreatesNoConversation(t *testing.T) {
+	tests := []struct {
+		name     string
+		behavior Behavior
+	}{
+		{"status", Behavior{Status: http.StatusTooManyRequests}},
+		{"tourist limited", Behavior{TouristLimited: true}},
+		{"gateway error", Behavior{GatewayError: "down"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New()
+			defer s.Close()
+			s.SetBehavior(tt.behavior)
+
+			postChat(t, s, `{"conversation_id":"0"}`)
+			if n := len(s.conversations); n != 0 {
+				t.Fatalf("%d conversations created by a failed chat", n)
+			}
+		})
+	}
+}
+
+func TestChatConversationLifecycle(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	resp, body := postChat(t, s, `{"conversation_id":"0"}`)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "event_type") {
+		t.Fatalf("status = %d, body = %s", resp.StatusCode, body)
+	}
+	if len(s.conversations) != 1 {
+		t.Fatalf("conversations = %v", s.conversations)
+	}
+
+	resp, _ = postChat(t, s, `{"conversation_id":"missing"}`)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown conversation status = %d", resp.StatusCode)
+	}
+}
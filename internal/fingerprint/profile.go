@@ -0,0 +1,86 @@
+// Package fingerprint 定义上游请求所使用的客户端指纹（UA、版本号、地区等），
+// 保证同一 Session 的全部请求看起来来自同一个浏览器。
+package fingerprint
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Profile 描述一组客户端指纹参数。空字段表示沿用上一级（全局或默认）配置。
+type Profile struct {
+	UserAgent   string            `json:"user_agent,omitempty"`
+	PCVersion   string            `json:"pc_version,omitempty"`
+	VersionCode string            `json:"version_code,omitempty"`
+	AID         string            `json:"aid,omitempty"`
+	Region      string            `json:"region,omitempty"`
+	Language    string            `json:"language,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// Default 返回与当前豆包网页版一致的默认指纹。
+func Default() Profile {
+	return Profile{
+		UserAgent:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Safari/537.36 Edg/137.0.0.0",
+		PCVersion:   "2.23.2",
+		VersionCode: "20800",
+		AID:         "497858",
+		Region:      "CN",
+		Language:    "zh",
+	}
+}
+
+// Merge 返回以 p 为基础、由 override 中非空字段覆盖后的新指纹。
+// Headers 按键合并，override 中的同名请求头优先。
+func (p Profile) Merge(override Profile) Profile {
+	out := p
+	if override.UserAgent != "" {
+		out.UserAgent = override.UserAgent
+	}
+	if override.PCVersion != "" {
+		out.PCVersion = override.PCVersion
+	}
+	if override.VersionCode != "" {
+		out.VersionCode = override.VersionCode
+	}
+	if override.AID != "" {
+		out.AID = override.AID
+	}
+	if override.Region != "" {
+		out.Region = override.Region
+	}
+	if override.Language != "" {
+		out.Language = override.Language
+	}
+	if len(p.Headers) > 0 || len(override.Headers) > 0 {
+		out.Headers = make(map[string]string, len(p.Headers)+len(override.Headers))
+		for k, v := range p.Headers {
+			out.Headers[k] = v
+		}
+		for k, v := range override.Headers {
+			out.Headers[k] = v
+		}
+	}
+	return out
+}
+
+// SetQuery 写入豆包网页接口公共的指纹查询参数。
+func (p Profile) SetQuery(values url.Values) {
+	values.Set("aid", p.AID)
+	values.Set("real_aid", p.AID)
+	values.Set("language", p.Language)
+	values.Set("pc_version", p.PCVersion)
+	values.Set("region", p.Region)
+	values.Set("sys_region", p.Region)
+	values.Set("version_code", p.VersionCode)
+}
+
+// SetHeaders 写入 User-Agent 与额外请求头。
+func (p Profile) SetHeaders(h http.Header) {
+	if p.UserAgent != "" {
+		h.Set("User-Agent", p.UserAgent)
+	}
+	for k, v := range p.Headers {
+		h.Set(k, v)
+	}
+}
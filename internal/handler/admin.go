@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"DoubaoProxy/internal/importer"
+	"DoubaoProxy/internal/session"
+)
+
+// RegisterAdmin 挂载 /admin 下的 Session 管理接口，使用独立于业务接口的令牌。
+// writeBack 为 true 时，每次修改成功后都会把 Session 池原子写回配置文件。
+func RegisterAdmin(router *gin.Engine, pool *session.Pool, token string, writeBack bool) {
+	h := &adminHandler{pool: pool, writeBack: writeBack}
+
+	admin := router.Group("/admin", adminAuthMiddleware(token))
+	{
+		admin.GET("/sessions", h.list)
+		admin.GET("/status", h.status)
+		admin.POST("/sessions", h.create)
+		admin.POST("/sessions/import", h.importSessions)
+		admin.PATCH("/sessions/:id", h.patch)
+		admin.DELETE("/sessions/:id", h.delete)
+		admin.POST("/sessions/:id/disable", h.disable)
+		admin.POST("/sessions/:id/enable", h.enable)
+		admin.POST("/sessions/:id/drain", h.drain)
+		// 运行指标包含 Session ID 与用量，只对管理员开放。
+		admin.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	}
+}
+
+type adminHandler struct {
+	pool      *session.Pool
+	writeBack bool
+}
+
+func (h *adminHandler) list(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"sessions": h.pool.Sessions()})
+}
+
+// status 返回全部 Session 的健康状态与并发情况，不含凭证。
+func (h *adminHandler) status(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"sessions": h.pool.Statuses()})
+}
+
+func (h *adminHandler) create(c *gin.Context) {
+	var entry session.Session
+	if err := c.ShouldBindJSON(&entry); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	s, err := h.pool.CreateSession(entry)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	h.respond(c, http.StatusCreated, s.ID)
+}
+
+// importSessions 从请求体中的 HAR、cURL 命令或 Cookie 文件提取 Session 并加入池，
+// 格式与补充字段通过查询参数传入。
+func (h *adminHandler) importSessions(c *gin.Context) {
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	opts := importer.Options{
+		URL:        c.Query("url"),
+		RoomID:     c.Query("room_id"),
+		XFlowTrace: c.Query("x_flow_trace"),
+	}
+	if raw := c.Query("guest"); raw != "" {
+		guest, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "guest must be true or false"})
+			return
+		}
+		opts.Guest = &guest
+	}
+
+	entries, err := importer.Parse(importer.Format(c.Query("format")), data, opts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	report := importer.AddToPool(h.pool, entries)
+	if len(report.Added) > 0 && !h.save(c) {
+		return
+	}
+	status := http.StatusOK
+	if len(report.Added) > 0 {
+		status = http.StatusCreated
+	}
+	c.JSON(status, report)
+}
+
+func (h *adminHandler) patch(c *gin.Context) {
+	var patch session.Patch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	s, err := h.pool.PatchSession(c.Param("id"), patch)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	h.respond(c, http.StatusOK, s.ID)
+}
+
+func (h *adminHandler) disable(c *gin.Context) {
+	h.setEnabled(c, false)
+}
+
+func (h *adminHandler) enable(c *gin.Context) {
+	h.setEnabled(c, true)
+}
+
+func (h *adminHandler) setEnabled(c *gin.Context, enabled bool) {
+	s, err := h.pool.PatchSession(c.Param("id"), session.Patch{Enabled: &enabled})
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	h.respond(c, http.StatusOK, s.ID)
+}
+
+func (h *adminHandler) drain(c *gin.Context) {
+	s, err := h.pool.DrainSession(c.Param("id"))
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	// 排空状态只存在于运行时，无需写回。
+	c.JSON(http.StatusOK, h.info(s.ID))
+}
+
+func (h *adminHandler) delete(c *gin.Context) {
+	if err := h.pool.DeleteSession(c.Param("id")); err != nil {
+		renderError(c, err)
+		return
+	}
+	if !h.save(c) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// respond 在需要时写回配置文件，然后返回该 Session 的最新视图。
+func (h *adminHandler) respond(c *gin.Context, status int, id string) {
+	if !h.save(c) {
+		return
+	}
+	c.JSON(status, h.info(id))
+}
+
+func (h *adminHandler) save(c *gin.Context) bool {
+	if !h.writeBack {
+		return true
+	}
+	if err := h.pool.SaveConfig(); err != nil {
+		slog.Error("write back session config failed", "error", err)
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: "change applied but write back failed: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+func (h *adminHandler) info(id string) *session.Info {
+	for _, info := range h.pool.Sessions() {
+		if info.ID == id {
+			return &info
+		}
+	}
+	return nil
+}
+
+func adminAuthMiddleware(token string) gin.HandlerFunc {
+	secret := []byte(strings.TrimSpace(token))
+
+	return func(c *gin.Context) {
+		provided := extractToken(c)
+		if subtle.ConstantTimeCompare([]byte(provided), secret) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
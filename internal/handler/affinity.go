@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"DoubaoProxy/internal/model"
+)
+
+// Affinity 从请求中提取用于 Session 亲和性的客户端标识，返回空串表示不做亲和。
+type Affinity func(c *gin.Context, req *model.CompletionRequest) string
+
+// ParseAffinity 解析以逗号分隔的标识来源列表，按顺序取第一个非空值：
+//
+//	user          - 请求体中的 user 字段
+//	api-key       - Authorization 或 X-API-Key 中的令牌
+//	header:<Name> - 指定请求头
+//
+// 空字符串或 none 表示关闭亲和性，返回 nil。
+func ParseAffinity(spec string) (Affinity, error) {
+	var sources []func(c *gin.Context, req *model.CompletionRequest) string
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		switch name, arg, _ := strings.Cut(part, ":"); strings.ToLower(name) {
+		case "", "none":
+		case "user":
+			sources = append(sources, func(_ *gin.Context, req *model.CompletionRequest) string {
+				return prefixed("user", req.User)
+			})
+		case "api-key":
+			sources = append(sources, func(c *gin.Context, _ *model.CompletionRequest) string {
+				return prefixed("api-key", extractToken(c))
+			})
+		case "header":
+			header := http.CanonicalHeaderKey(strings.TrimSpace(arg))
+			if header == "" {
+				return nil, fmt.Errorf("session affinity %q: header name is required", part)
+			}
+			sources = append(sources, func(c *gin.Context, _ *model.CompletionRequest) string {
+				return prefixed("header", c.GetHeader(header))
+			})
+		default:
+			return nil, fmt.Errorf("unknown session affinity source %q (want user, api-key or header:<Name>)", part)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	return func(c *gin.Context, req *model.CompletionRequest) string {
+		for _, source := range sources {
+			if key := source(c, req); key != "" {
+				return key
+			}
+		}
+		return ""
+	}, nil
+}
+
+// prefixed 给标识加上来源前缀并取摘要，避免不同来源的同名标识落到一起，也不在内存中保留原始令牌。
+func prefixed(source, value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(source + ":" + value))
+	return hex.EncodeToString(sum[:16])
+}
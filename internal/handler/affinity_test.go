@@ -0,0 +1,64 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"DoubaoProxy/internal/handler"
+	"DoubaoProxy/internal/model"
+)
+
+func TestParseAffinity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key := func(t *testing.T, spec string, header http.Header, user string) string {
+		t.Helper()
+		affinity, err := handler.ParseAffinity(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		c.Request.Header = header
+		return affinity(c, &model.CompletionRequest{User: user})
+	}
+	bearer := http.Header{"Authorization": {"Bearer sk-one"}}
+
+	userKey := key(t, "user,api-key", bearer, "alice")
+	tokenKey := key(t, "user,api-key", bearer, "")
+	switch {
+	case userKey == "" || tokenKey == "":
+		t.Fatalf("keys = %q, %q", userKey, tokenKey)
+	case userKey == tokenKey:
+		t.Error("user and api-key sources produced the same key")
+	case strings.Contains(tokenKey, "sk-one"):
+		t.Error("raw token kept in affinity key")
+	}
+	if got := key(t, "api-key", http.Header{"X-Api-Key": {"sk-one"}}, ""); got != tokenKey {
+		t.Errorf("X-API-Key key = %q, want same as bearer %q", got, tokenKey)
+	}
+	// 同名标识来自不同来源时不会落到一起。
+	if key(t, "user", nil, "sk-one") == tokenKey {
+		t.Error("user and api-key with the same value share a key")
+	}
+	if got := key(t, "header:x-client-id", http.Header{"X-Client-Id": {"c1"}}, "alice"); got == "" || got == userKey {
+		t.Errorf("header key = %q", got)
+	}
+	if got := key(t, "user", bearer, ""); got != "" {
+		t.Errorf("missing user gave key %q, want none", got)
+	}
+
+	for _, spec := range []string{"", "none", " none "} {
+		if affinity, err := handler.ParseAffinity(spec); err != nil || affinity != nil {
+			t.Errorf("ParseAffinity(%q) = %v, %v; want disabled", spec, affinity != nil, err)
+		}
+	}
+	for _, spec := range []string{"cookie", "header:", "user,ip"} {
+		if _, err := handler.ParseAffinity(spec); err == nil {
+			t.Errorf("ParseAffinity(%q) accepted", spec)
+		}
+	}
+}
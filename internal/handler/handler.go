@@ -3,7 +3,9 @@ package handler
 import (
 	"crypto/subtle"
 	"errors"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -13,13 +15,25 @@ import (
 	"DoubaoProxy/internal/service/doubao"
 )
 
+// Options 描述业务路由的认证与 Session 路由配置。
+type Options struct {
+	// AuthToken 为接口认证令牌，与 APIKeys 均为空时关闭认证。
+	AuthToken string
+	// APIKeys 为额外的接口令牌及其绑定的 Session 标签，使用该令牌的请求只会分配到带有这些标签的 Session。
+	APIKeys map[string][]string
+	// AdminToken 为管理令牌，请求同时携带它时才允许通过 X-Session-Pin 指定 Session。
+	AdminToken string
+	// Affinity 非空时，新会话按其提取的客户端标识固定分配 Session。
+	Affinity Affinity
+}
+
 // Register 将业务路由挂载到 gin 引擎上。
-func Register(router *gin.Engine, service *doubao.Service, authToken string) {
-	if strings.TrimSpace(authToken) != "" {
-		router.Use(authMiddleware(authToken))
+func Register(router *gin.Engine, service *doubao.Service, opts Options) {
+	if strings.TrimSpace(opts.AuthToken) != "" || len(opts.APIKeys) > 0 {
+		router.Use(authMiddleware(opts.AuthToken, opts.APIKeys))
 	}
 
-	h := &handler{service: service}
+	h := &handler{service: service, affinity: opts.Affinity, adminToken: opts.AdminToken}
 
 	router.GET("/healthz", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"ok": true})
@@ -40,7 +54,9 @@ func Register(router *gin.Engine, service *doubao.Service, authToken string) {
 }
 
 type handler struct {
-	service *doubao.Service
+	service    *doubao.Service
+	affinity   Affinity
+	adminToken string
 }
 
 type errorStatus interface {
@@ -57,9 +73,18 @@ func (h *handler) completions(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
 		return
 	}
+	if h.affinity != nil {
+		req.AffinityKey = h.affinity(c, &req)
+	}
+	route, err := h.route(c, req.SessionTags)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
 
-	ctx := c.Request.Context()
+	ctx, trace := doubao.WithTrace(doubao.WithRoute(c.Request.Context(), route))
 	resp, err := h.service.ChatCompletion(ctx, req)
+	writeTrace(c, trace)
 	if err != nil {
 		renderError(c, err)
 		return
@@ -104,8 +129,18 @@ func (h *handler) upload(c *gin.Context) {
 		return
 	}
 
-	ctx := c.Request.Context()
-	resp, err := h.service.UploadFile(ctx, fileType, fileName, body)
+	var tags []string
+	if raw := c.Query("session_tags"); raw != "" {
+		tags = strings.Split(raw, ",")
+	}
+	route, err := h.route(c, tags)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+
+	ctx := doubao.WithRoute(c.Request.Context(), route)
+	resp, err := h.service.UploadFile(ctx, c.Query("provider"), fileType, fileName, body)
 	if err != nil {
 		renderError(c, err)
 		return
@@ -113,11 +148,51 @@ func (h *handler) upload(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// route 汇总请求自带的标签、API 令牌绑定的标签以及管理员指定的 Session。
+func (h *handler) route(c *gin.Context, tags []string) (doubao.Route, error) {
+	var route doubao.Route
+	for _, tag := range slices.Concat(tags, c.GetStringSlice(keyTagsContextKey)) {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(route.Tags, tag) {
+			route.Tags = append(route.Tags, tag)
+		}
+	}
+
+	pin := strings.TrimSpace(c.GetHeader("X-Session-Pin"))
+	if pin == "" {
+		return route, nil
+	}
+	provided := strings.TrimSpace(c.GetHeader("X-Admin-Token"))
+	if h.adminToken == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(h.adminToken)) != 1 {
+		return route, model.NewHTTPError(http.StatusForbidden, "X-Session-Pin requires a valid X-Admin-Token")
+	}
+	route.Pin = pin
+	return route, nil
+}
+
+// writeTrace 通过响应头告知客户端本次请求尝试过的 Session 及结果，以及最终服务的账号类别。
+func writeTrace(c *gin.Context, trace *doubao.Trace) {
+	if header := trace.Header(); header != "" {
+		c.Header("X-Session-Attempts", header)
+	}
+	if served := trace.Served(); served != "" {
+		c.Header("X-Session-ID", served)
+	}
+	if class, fallback := trace.ServedClass(); class != "" {
+		c.Header("X-Session-Class", class)
+		if fallback {
+			c.Header("X-Session-Fallback", "true")
+		}
+	}
+}
+
 func renderError(c *gin.Context, err error) {
 	var httpErr *model.HTTPError
 	status := http.StatusInternalServerError
 	if errors.As(err, &httpErr) {
 		status = httpErr.StatusCode()
+		if httpErr.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(httpErr.RetryAfter.Seconds()))))
+		}
 	} else if es, ok := err.(errorStatus); ok {
 		status = es.StatusCode()
 	}
@@ -127,23 +202,32 @@ func renderError(c *gin.Context, err error) {
 	c.JSON(status, errorResponse{Error: err.Error()})
 }
 
-func authMiddleware(token string) gin.HandlerFunc {
+// keyTagsContextKey 是认证中间件保存 API 令牌绑定标签的 gin 上下文键。
+const keyTagsContextKey = "doubaoproxy.key_tags"
+
+func authMiddleware(token string, keys map[string][]string) gin.HandlerFunc {
 	secret := []byte(strings.TrimSpace(token))
 
 	return func(c *gin.Context) {
-		// 健康检查保持开放，便于外部探活。
-		if c.Request.URL.Path == "/healthz" {
+		// 健康检查保持开放，便于外部探活；管理接口使用独立的令牌。
+		if c.Request.URL.Path == "/healthz" || strings.HasPrefix(c.Request.URL.Path, "/admin/") {
 			c.Next()
 			return
 		}
 
 		provided := extractToken(c)
-		if subtle.ConstantTimeCompare([]byte(provided), secret) != 1 {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+		if len(secret) > 0 && subtle.ConstantTimeCompare([]byte(provided), secret) == 1 {
+			c.Next()
 			return
 		}
-
-		c.Next()
+		for key, tags := range keys {
+			if key != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(key)) == 1 {
+				c.Set(keyTagsContextKey, tags)
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
 	}
 }
 
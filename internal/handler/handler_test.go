@@ -0,0 +1,355 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"DoubaoProxy/internal/config"
+	"DoubaoProxy/internal/fakedoubao"
+	"DoubaoProxy/internal/handler"
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/service/doubao"
+	"DoubaoProxy/internal/session"
+)
+
+// testProxy 是接在豆包替身上的完整代理：Session 池、doubao.Service 与 gin 路由。
+type testProxy struct {
+	fake *fakedoubao.Server
+	pool *session.Pool
+	srv  *httptest.Server
+}
+
+func newTestProxy(t *testing.T, sessions []session.Session, opts handler.Options) *testProxy {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	fake := fakedoubao.New()
+	t.Cleanup(fake.Close)
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := session.NewPool(path, session.Options{})
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	cfg = fake.Apply(cfg)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	service, err := doubao.NewService(pool, nil, cfg, logger)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	router := gin.New()
+	handler.Register(router, service, opts)
+	if opts.AdminToken != "" {
+		handler.RegisterAdmin(router, pool, opts.AdminToken, false)
+	}
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return &testProxy{fake: fake, pool: pool, srv: srv}
+}
+
+func testSession(id string, guest bool) session.Session {
+	return session.Session{
+		ID:         id,
+		Cookie:     "sessionid=" + id,
+		DeviceID:   "device-" + id,
+		TeaUUID:    "tea-" + id,
+		WebID:      "web-" + id,
+		RoomID:     "room-" + id,
+		XFlowTrace: "trace-" + id,
+		Guest:      guest,
+	}
+}
+
+func (p *testProxy) do(t *testing.T, method, path string, body []byte, header http.Header) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, p.srv.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func (p *testProxy) chat(t *testing.T, req model.CompletionRequest) (*http.Response, model.CompletionResponse) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	resp := p.do(t, http.MethodPost, "/api/chat/completions", body, nil)
+	var out model.CompletionResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("decode completion: %v", err)
+		}
+	}
+	return resp, out
+}
+
+func TestChatCompletion(t *testing.T) {
+	p := newTestProxy(t, []session.Session{testSession("a", false)}, handler.Options{})
+	p.fake.SetBehavior(fakedoubao.Behavior{Text: "streamed reply", Images: []string{"https://img/1"}})
+
+	resp, out := p.chat(t, model.CompletionRequest{Prompt: "hi"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if out.Text != "streamed reply" {
+		t.Errorf("text = %q", out.Text)
+	}
+	if len(out.ImgURLs) != 1 || out.ImgURLs[0] != "https://img/1" {
+		t.Errorf("images = %q", out.ImgURLs)
+	}
+	if !p.fake.HasConversation(out.ConversationID) {
+		t.Fatalf("conversation %q not created upstream", out.ConversationID)
+	}
+	if got := resp.Header.Get("X-Session-ID"); got != "a" {
+		t.Errorf("X-Session-ID = %q", got)
+	}
+
+	// 续聊沿用同一会话与 Session，并带上上游轮换后的 Cookie。
+	resp, next := p.chat(t, model.CompletionRequest{Prompt: "again", ConversationID: out.ConversationID, SectionID: out.SectionID})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("follow-up status = %d", resp.StatusCode)
+	}
+	if next.ConversationID != out.ConversationID {
+		t.Errorf("follow-up conversation = %q, want %q", next.ConversationID, out.ConversationID)
+	}
+	reqs := p.fake.Requests()
+	last := reqs[len(reqs)-1]
+	if !strings.Contains(last.Header.Get("Cookie"), "msToken=tok-") {
+		t.Errorf("rotated cookie not sent: %q", last.Header.Get("Cookie"))
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	p := newTestProxy(t, []session.Session{testSession("a", false)}, handler.Options{})
+
+	resp := p.do(t, http.MethodPost, "/api/file/upload?file_type=2&file_name=pic.png", []byte("png-bytes"), nil)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	var out model.UploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.Key, "tos-fake/") || out.Name != "pic.png" {
+		t.Errorf("upload = %+v", out)
+	}
+
+	var steps []string
+	for _, r := range p.fake.Requests() {
+		switch {
+		case r.Path == "/alice/resource/prepare_upload":
+			steps = append(steps, "prepare")
+		case strings.Contains(r.Query, "Action=ApplyImageUpload"):
+			steps = append(steps, "apply")
+		case strings.HasPrefix(r.Path, "/upload/v1/"):
+			steps = append(steps, "store")
+		case strings.Contains(r.Query, "Action=CommitImageUpload"):
+			steps = append(steps, "commit")
+		}
+	}
+	if got := strings.Join(steps, ","); got != "prepare,apply,store,commit" {
+		t.Errorf("upload steps = %s", got)
+	}
+}
+
+func TestUploadPacesEveryStep(t *testing.T) {
+	// 每 100ms 补充一个令牌、容量为 1：四步上传中后三步各需等待一个令牌。
+	sess := testSession("a", false)
+	sess.Pacing = &session.Pacing{RatePerMinute: 600, Burst: 1}
+	p := newTestProxy(t, []session.Session{sess}, handler.Options{})
+
+	start := time.Now()
+	resp := p.do(t, http.MethodPost, "/api/file/upload?file_type=2&file_name=pic.png", []byte("png-bytes"), nil)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("upload took %v, want every step paced", elapsed)
+	}
+}
+
+func TestDeleteConversation(t *testing.T) {
+	p := newTestProxy(t, []session.Session{testSession("a", false)}, handler.Options{})
+
+	_, out := p.chat(t, model.CompletionRequest{Prompt: "hi"})
+	resp := p.do(t, http.MethodPost, "/api/chat/delete?conversation_id="+out.ConversationID, nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if p.fake.HasConversation(out.ConversationID) {
+		t.Error("conversation still exists upstream")
+	}
+
+	// 上游已不存在该会话，再次删除以 ok=false 报告上游的错误。
+	resp = p.do(t, http.MethodPost, "/api/chat/delete?conversation_id="+out.ConversationID, nil, nil)
+	var res model.DeleteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.OK || !strings.Contains(res.Msg, "conversation not found") {
+		t.Errorf("second delete = %+v", res)
+	}
+}
+
+func TestChatErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		guest    bool
+		behavior fakedoubao.Behavior
+		status   int
+		outcome  string
+	}{
+		{"upstream status", false, fakedoubao.Behavior{Status: http.StatusInternalServerError}, http.StatusInternalServerError, "a=transient"},
+		{"gateway error", false, fakedoubao.Behavior{GatewayError: "upstream down"}, http.StatusBadGateway, "a=transient"},
+		{"tourist limit", true, fakedoubao.Behavior{TouristLimited: true}, http.StatusTooManyRequests, "a=rate_limited"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, []session.Session{testSession("a", tt.guest)}, handler.Options{})
+			p.fake.SetBehavior(tt.behavior)
+
+			resp, _ := p.chat(t, model.CompletionRequest{Prompt: "hi", Guest: tt.guest})
+			if resp.StatusCode != tt.status {
+				body, _ := io.ReadAll(resp.Body)
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+			if attempts := resp.Header.Get("X-Session-Attempts"); attempts != tt.outcome {
+				t.Errorf("X-Session-Attempts = %q, want %q", attempts, tt.outcome)
+			}
+		})
+	}
+}
+
+func TestChatFailover(t *testing.T) {
+	p := newTestProxy(t, []session.Session{testSession("a", false), testSession("b", false)}, handler.Options{})
+	p.fake.SetDeviceBehavior("device-a", fakedoubao.Behavior{Status: http.StatusUnauthorized})
+
+	// 随机策略下重复请求直到 a 被选中；a 认证失败后请求转移到 b。
+	for i := 0; i < 20; i++ {
+		resp, _ := p.chat(t, model.CompletionRequest{Prompt: "hi"})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d", resp.StatusCode)
+		}
+		if got := resp.Header.Get("X-Session-ID"); got != "b" {
+			t.Fatalf("served by %q, want b", got)
+		}
+		if attempts := resp.Header.Get("X-Session-Attempts"); attempts != "b=ok" {
+			if attempts != "a=auth_expired, b=ok" {
+				t.Fatalf("X-Session-Attempts = %q", attempts)
+			}
+			break
+		}
+	}
+	for _, st := range p.pool.Statuses() {
+		if st.ID == "a" && st.State != session.StateDead {
+			t.Errorf("session a state = %s, want dead", st.State)
+		}
+	}
+}
+
+func TestUnknownConversation(t *testing.T) {
+	p := newTestProxy(t, []session.Session{testSession("a", false)}, handler.Options{})
+
+	resp, _ := p.chat(t, model.CompletionRequest{Prompt: "hi", ConversationID: "does-not-exist"})
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	p := newTestProxy(t, []session.Session{testSession("a", false)}, handler.Options{AuthToken: "secret"})
+
+	body, _ := json.Marshal(model.CompletionRequest{Prompt: "hi"})
+	if resp := p.do(t, http.MethodPost, "/api/chat/completions", body, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d", resp.StatusCode)
+	}
+	header := http.Header{"Authorization": {"Bearer secret"}}
+	if resp := p.do(t, http.MethodPost, "/api/chat/completions", body, header); resp.StatusCode != http.StatusOK {
+		t.Errorf("with token: status = %d", resp.StatusCode)
+	}
+	if resp := p.do(t, http.MethodGet, "/healthz", nil, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("healthz: status = %d", resp.StatusCode)
+	}
+}
+
+func TestDebugVarsRequireAdminToken(t *testing.T) {
+	p := newTestProxy(t, []session.Session{testSession("a", false)}, handler.Options{AuthToken: "secret", AdminToken: "admin"})
+
+	user := http.Header{"Authorization": {"Bearer secret"}}
+	if resp := p.do(t, http.MethodGet, "/debug/vars", nil, user); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("public /debug/vars: status = %d", resp.StatusCode)
+	}
+	if resp := p.do(t, http.MethodGet, "/admin/debug/vars", nil, user); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("/admin/debug/vars with API token: status = %d", resp.StatusCode)
+	}
+	admin := http.Header{"Authorization": {"Bearer admin"}}
+	resp := p.do(t, http.MethodGet, "/admin/debug/vars", nil, admin)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/admin/debug/vars: status = %d", resp.StatusCode)
+	}
+	var vars map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vars["session_bindings"]; !ok {
+		t.Error("session_bindings missing from expvar output")
+	}
+}
+
+func TestSessionStatusRequiresAdminToken(t *testing.T) {
+	p := newTestProxy(t, []session.Session{testSession("a", false)}, handler.Options{AuthToken: "secret", AdminToken: "admin"})
+
+	user := http.Header{"Authorization": {"Bearer secret"}}
+	if resp := p.do(t, http.MethodGet, "/api/sessions", nil, user); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("public /api/sessions: status = %d", resp.StatusCode)
+	}
+	if resp := p.do(t, http.MethodGet, "/admin/status", nil, user); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("/admin/status with API token: status = %d", resp.StatusCode)
+	}
+	resp := p.do(t, http.MethodGet, "/admin/status", nil, http.Header{"Authorization": {"Bearer admin"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/admin/status: status = %d", resp.StatusCode)
+	}
+	var out struct {
+		Sessions []session.Status `json:"sessions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Sessions) != 1 || out.Sessions[0].ID != "a" || out.Sessions[0].State != session.StateHealthy {
+		t.Errorf("sessions = %+v", out.Sessions)
+	}
+}
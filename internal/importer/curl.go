@@ -0,0 +1,166 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"DoubaoProxy/internal/session"
+)
+
+// curlValueFlags 是需要跳过参数值的 curl 选项。
+var curlValueFlags = map[string]bool{
+	"-X": true, "--request": true,
+	"-d": true, "--data": true, "--data-raw": true, "--data-binary": true, "--data-urlencode": true,
+	"-o": true, "--output": true, "-u": true, "--user": true, "-x": true, "--proxy": true,
+	"--connect-timeout": true, "-m": true, "--max-time": true,
+}
+
+// parseCURL 解析浏览器 "Copy as cURL (bash)" 得到的命令。
+func parseCURL(command string) ([]session.Session, error) {
+	args, err := shellSplit(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 || args[0] != "curl" {
+		return nil, errors.New("curl command must start with curl")
+	}
+
+	var rawURL string
+	header := make(http.Header)
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		next := func() string {
+			if i+1 < len(args) {
+				i++
+				return args[i]
+			}
+			return ""
+		}
+		switch {
+		case arg == "-H" || arg == "--header":
+			name, value, ok := strings.Cut(next(), ":")
+			if ok {
+				header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+			}
+		case arg == "-b" || arg == "--cookie":
+			header.Set("Cookie", next())
+		case arg == "-e" || arg == "--referer":
+			header.Set("Referer", next())
+		case arg == "-A" || arg == "--user-agent":
+			header.Set("User-Agent", next())
+		case arg == "--url":
+			rawURL = next()
+		case curlValueFlags[arg]:
+			next()
+		case strings.HasPrefix(arg, "-"):
+			// 其余选项（--compressed 等）不带参数值。
+		default:
+			rawURL = arg
+		}
+	}
+	if rawURL == "" {
+		return nil, errors.New("curl command has no url")
+	}
+
+	s, ok := fromRequest(rawURL, header)
+	if !ok {
+		return nil, fmt.Errorf("url %s has no device_id query parameter", rawURL)
+	}
+	return []session.Session{s}, nil
+}
+
+// shellSplit 按 bash 规则切分命令行，支持单引号、双引号、$'...' 与反斜杠续行。
+func shellSplit(s string) ([]string, error) {
+	var (
+		args    []string
+		cur     strings.Builder
+		inToken bool
+	)
+	flush := func() {
+		if inToken {
+			args = append(args, cur.String())
+			cur.Reset()
+			inToken = false
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == '\n' || s[i+1] == '\r'):
+			// 续行：跳过反斜杠与换行。
+			i++
+			if s[i] == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+		case c == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+			inToken = true
+		case c == '$' && i+1 < len(s) && s[i+1] == '\'':
+			end, text, err := ansiCQuoted(s, i+2)
+			if err != nil {
+				return nil, err
+			}
+			cur.WriteString(text)
+			inToken = true
+			i = end
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote in curl command")
+			}
+			cur.WriteString(s[i+1 : i+1+end])
+			inToken = true
+			i += end + 1
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) && strings.IndexByte("\"\\$`", s[j+1]) >= 0 {
+					j++
+				}
+				cur.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, errors.New("unterminated double quote in curl command")
+			}
+			inToken = true
+			i = j
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+		default:
+			cur.WriteByte(c)
+			inToken = true
+		}
+	}
+	flush()
+	return args, nil
+}
+
+// ansiCQuoted 解析 $'...' 的内容，start 指向引号后的第一个字符，返回闭合引号的位置。
+func ansiCQuoted(s string, start int) (int, string, error) {
+	var b strings.Builder
+	for i := start; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			return i, b.String(), nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return 0, "", errors.New("unterminated $'...' quote in curl command")
+}
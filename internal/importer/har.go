@@ -0,0 +1,84 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"DoubaoProxy/internal/session"
+)
+
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				Cookies []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"cookies"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// parseHAR 遍历 HAR 中的全部请求，聊天接口请求优先，其余带 device_id 的请求用于补全字段。
+func parseHAR(data []byte) ([]session.Session, error) {
+	var har harFile
+	if err := json.Unmarshal(bytes.TrimPrefix(data, []byte("\uFEFF")), &har); err != nil {
+		return nil, fmt.Errorf("decode har: %w", err)
+	}
+
+	var chat, other []session.Session
+	room := ""
+	// cookies 按 HAR 的时间顺序记录每个设备最后出现的 Cookie，上游轮换后以最新的为准。
+	cookies := make(map[string]string)
+	for _, entry := range har.Log.Entries {
+		req := entry.Request
+		header := make(http.Header, len(req.Headers))
+		for _, h := range req.Headers {
+			// HTTP/2 的伪首部（:authority 等）不是真正的请求头。
+			if strings.HasPrefix(h.Name, ":") {
+				continue
+			}
+			header.Add(h.Name, h.Value)
+		}
+		if header.Get("Cookie") == "" && len(req.Cookies) > 0 {
+			parts := make([]string, 0, len(req.Cookies))
+			for _, c := range req.Cookies {
+				parts = append(parts, c.Name+"="+c.Value)
+			}
+			header.Set("Cookie", strings.Join(parts, "; "))
+		}
+
+		room = firstNonEmpty(room, roomFromURL(req.URL), roomFromURL(header.Get("Referer")))
+		s, ok := fromRequest(req.URL, header)
+		if !ok {
+			continue
+		}
+		if s.Cookie != "" {
+			cookies[deviceKey(&s)] = s.Cookie
+		}
+		if strings.Contains(req.URL, "/samantha/chat/completion") {
+			chat = append(chat, s)
+		} else {
+			other = append(other, s)
+		}
+	}
+
+	out := append(chat, other...)
+	// 聊天请求的 Referer 可能是新对话页面 /chat/，room_id 取 HAR 中出现过的任一会话页。
+	for i := range out {
+		out[i].RoomID = firstNonEmpty(out[i].RoomID, room)
+		if cookie := cookies[deviceKey(&out[i])]; cookie != "" {
+			out[i].Cookie, out[i].Guest = cookie, !session.HasLoginCookie(cookie)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,196 @@
+// Package importer 从浏览器导出的数据中提取 Session 字段，
+// 支持 HAR 文件、"Copy as cURL" 命令以及 Netscape 格式的 Cookie 文件加请求 URL。
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"DoubaoProxy/internal/provider"
+	"DoubaoProxy/internal/session"
+)
+
+// Format 表示导入数据的格式。
+type Format string
+
+const (
+	FormatAuto Format = ""
+	FormatHAR  Format = "har"
+	FormatCURL Format = "curl"
+	FormatJar  Format = "jar"
+)
+
+// Options 补充或覆盖导入数据中缺失的字段。
+type Options struct {
+	// URL 为抓取到的聊天请求地址，Cookie 文件不包含请求信息时必填。
+	URL string
+	// RoomID 与 XFlowTrace 非空时覆盖提取结果。
+	RoomID     string
+	XFlowTrace string
+	// Guest 非空时覆盖根据 Cookie 登录态推断的结果。
+	Guest *bool
+}
+
+// Parse 按格式解析 data 并返回提取出的 Session，结果已按设备去重但尚未校验。
+func Parse(format Format, data []byte, opts Options) ([]session.Session, error) {
+	if format == FormatAuto {
+		format = Detect(data)
+	}
+
+	var (
+		out []session.Session
+		err error
+	)
+	switch format {
+	case FormatHAR:
+		out, err = parseHAR(data)
+	case FormatCURL:
+		out, err = parseCURL(string(data))
+	case FormatJar:
+		out, err = parseJar(string(data), opts.URL)
+	default:
+		return nil, fmt.Errorf("unknown import format %q (want har, curl or jar)", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no doubao/cici request with device_id found in input")
+	}
+
+	for i := range out {
+		if opts.RoomID != "" {
+			out[i].RoomID = opts.RoomID
+		}
+		if opts.XFlowTrace != "" {
+			out[i].XFlowTrace = opts.XFlowTrace
+		}
+		if opts.Guest != nil {
+			out[i].Guest = *opts.Guest
+		}
+	}
+	return dedupe(out), nil
+}
+
+// Detect 根据内容猜测格式：JSON 为 HAR，以 curl 开头为 cURL 命令，其余按 Cookie 文件处理。
+func Detect(data []byte) Format {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\uFEFF")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return FormatHAR
+	case bytes.HasPrefix(trimmed, []byte("curl")):
+		return FormatCURL
+	default:
+		return FormatJar
+	}
+}
+
+// fromRequest 从一次请求的 URL 与请求头中提取 Session 字段。
+// 不是豆包/Cici 网页接口请求（缺少 device_id）时返回 false。
+func fromRequest(rawURL string, header http.Header) (session.Session, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return session.Session{}, false
+	}
+	q := u.Query()
+	if q.Get("device_id") == "" {
+		return session.Session{}, false
+	}
+
+	cookie := header.Get("Cookie")
+	s := session.Session{
+		Cookie:     cookie,
+		DeviceID:   q.Get("device_id"),
+		TeaUUID:    q.Get("tea_uuid"),
+		WebID:      q.Get("web_id"),
+		XFlowTrace: header.Get("X-Flow-Trace"),
+		RoomID:     firstNonEmpty(roomFromURL(header.Get("Referer")), roomFromURL(rawURL)),
+		Guest:      !session.HasLoginCookie(cookie),
+	}
+	if strings.Contains(u.Hostname(), "cici") {
+		s.Provider = provider.Cici
+	}
+	return s, true
+}
+
+// roomFromURL 从 https://www.doubao.com/chat/<room_id> 形式的地址中提取 room_id。
+func roomFromURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	rest, ok := strings.CutPrefix(u.Path, "/chat/")
+	if !ok {
+		return ""
+	}
+	room, _, _ := strings.Cut(rest, "/")
+	return room
+}
+
+// dedupe 合并同一设备的多条记录，靠后的非空字段补全靠前的记录；Cookie 会被上游轮换，取最后一条非空值。
+func dedupe(in []session.Session) []session.Session {
+	index := make(map[string]int, len(in))
+	var out []session.Session
+	for _, s := range in {
+		key := deviceKey(&s)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(out)
+			out = append(out, s)
+			continue
+		}
+		merged := &out[i]
+		if s.Cookie != "" {
+			// guest 由 Cookie 推断（或已被参数统一覆盖），随 Cookie 一起取较新的记录。
+			merged.Cookie, merged.Guest = s.Cookie, s.Guest
+		}
+		merged.TeaUUID = firstNonEmpty(merged.TeaUUID, s.TeaUUID)
+		merged.RoomID = firstNonEmpty(merged.RoomID, s.RoomID)
+		merged.XFlowTrace = firstNonEmpty(merged.XFlowTrace, s.XFlowTrace)
+	}
+	return out
+}
+
+// deviceKey 返回区分设备的键，同一设备的多条记录会被合并。
+func deviceKey(s *session.Session) string {
+	return s.Provider + "|" + s.DeviceID + "|" + s.WebID
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Report 汇总一次导入的结果。
+type Report struct {
+	Added   []string  `json:"added"`
+	Skipped []Skipped `json:"skipped"`
+}
+
+// Skipped 记录未被加入池的条目及原因（校验失败或与已有 Session 重复）。
+type Skipped struct {
+	DeviceID string `json:"device_id"`
+	Reason   string `json:"reason"`
+}
+
+// AddToPool 逐个校验提取结果并加入 pool，与已有 Session 重复的条目会被跳过。
+func AddToPool(pool *session.Pool, entries []session.Session) Report {
+	report := Report{Added: []string{}, Skipped: []Skipped{}}
+	for _, entry := range entries {
+		s, err := pool.CreateSession(entry)
+		if err != nil {
+			report.Skipped = append(report.Skipped, Skipped{DeviceID: entry.DeviceID, Reason: err.Error()})
+			continue
+		}
+		report.Added = append(report.Added, s.ID)
+	}
+	return report
+}
@@ -0,0 +1,65 @@
+package importer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"DoubaoProxy/internal/session"
+)
+
+// harWith 构造按顺序包含给定请求的 HAR，每个请求为 URL 与 Cookie。
+func harWith(t *testing.T, requests ...[2]string) []byte {
+	t.Helper()
+	entries := make([]any, 0, len(requests))
+	for _, r := range requests {
+		entries = append(entries, map[string]any{"request": map[string]any{
+			"url":     r[0],
+			"headers": []any{map[string]string{"name": "Cookie", "value": r[1]}},
+		}})
+	}
+	data, err := json.Marshal(map[string]any{"log": map[string]any{"entries": entries}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseHARPrefersLatestCookie(t *testing.T) {
+	const query = "?device_id=d1&tea_uuid=t1&web_id=w1"
+	data := harWith(t,
+		[2]string{"https://www.doubao.com/samantha/chat/completion" + query, "ttwid=old"},
+		[2]string{"https://www.doubao.com/alice/resource/prepare_upload" + query, "ttwid=new; sessionid=abc"},
+	)
+
+	out, err := Parse(FormatHAR, data, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 {
+		t.Fatalf("sessions = %d, want 1", len(out))
+	}
+	if out[0].Cookie != "ttwid=new; sessionid=abc" {
+		t.Errorf("cookie = %q, want the later one", out[0].Cookie)
+	}
+	if out[0].Guest {
+		t.Error("guest = true, want it inferred from the later cookie")
+	}
+}
+
+func TestDedupePrefersLaterCookie(t *testing.T) {
+	out := dedupe([]session.Session{
+		{DeviceID: "d1", WebID: "w1", Cookie: "ttwid=old", Guest: true, RoomID: "r1"},
+		{DeviceID: "d1", WebID: "w1", Cookie: "", TeaUUID: "t1"},
+		{DeviceID: "d1", WebID: "w1", Cookie: "sessionid=new", RoomID: "r2"},
+	})
+	if len(out) != 1 {
+		t.Fatalf("sessions = %d, want 1", len(out))
+	}
+	got := out[0]
+	if got.Cookie != "sessionid=new" || got.Guest {
+		t.Errorf("cookie = %q guest = %v, want the later non-empty cookie", got.Cookie, got.Guest)
+	}
+	if got.RoomID != "r1" || got.TeaUUID != "t1" {
+		t.Errorf("room_id = %q tea_uuid = %q, want earlier fields kept and gaps filled", got.RoomID, got.TeaUUID)
+	}
+}
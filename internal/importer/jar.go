@@ -0,0 +1,59 @@
+package importer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"DoubaoProxy/internal/session"
+)
+
+// parseJar 解析 Netscape 格式的 Cookie 文件，挑出对 rawURL 所在域名生效的 Cookie，
+// 其余字段从 rawURL 的查询参数中提取。
+func parseJar(data, rawURL string) ([]session.Session, error) {
+	if rawURL == "" {
+		return nil, errors.New("cookie jar import requires the captured request url")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	host := strings.ToLower(u.Hostname())
+
+	var parts []string
+	scanner := bufio.NewScanner(strings.NewReader(strings.TrimPrefix(data, "\uFEFF")))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// curl 与浏览器扩展用 #HttpOnly_ 前缀标记 HttpOnly Cookie，它们不是注释。
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			continue
+		}
+		domain := strings.ToLower(strings.TrimPrefix(fields[0], "."))
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			continue
+		}
+		parts = append(parts, fields[5]+"="+fields[6])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read cookie jar: %w", err)
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("cookie jar has no cookies for %s", host)
+	}
+
+	header := http.Header{"Cookie": {strings.Join(parts, "; ")}}
+	s, ok := fromRequest(rawURL, header)
+	if !ok {
+		return nil, fmt.Errorf("url %s has no device_id query parameter", rawURL)
+	}
+	return []session.Session{s}, nil
+}
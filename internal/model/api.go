@@ -9,6 +9,14 @@ type CompletionRequest struct {
 	SectionID      string       `json:"section_id,omitempty"`
 	UseDeepThink   bool         `json:"use_deep_think"`
 	UseAutoCoT     bool         `json:"use_auto_cot"`
+	// Provider 限定新会话使用的上游后端（doubao 或 cici），留空表示不限。
+	Provider string `json:"provider,omitempty"`
+	// User 为终端用户标识（同 OpenAI 的 user 字段），可用作 Session 亲和性的键。
+	User string `json:"user,omitempty"`
+	// AffinityKey 由接口层根据亲和性配置填入，不从请求体读取。
+	AffinityKey string `json:"-"`
+	// SessionTags 要求新会话使用带有其中全部标签的 Session。
+	SessionTags []string `json:"session_tags,omitempty"`
 }
 
 // Attachment 对应豆包 API 所要求的附件结构。
@@ -1,11 +1,16 @@
 package model
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // HTTPError 表示携带 HTTP 状态码的业务错误。
 type HTTPError struct {
 	Status  int
 	Message string
+	// RetryAfter 非零时作为 Retry-After 响应头返回给客户端。
+	RetryAfter time.Duration
 }
 
 func (e *HTTPError) Error() string {
@@ -23,6 +28,12 @@ func (e *HTTPError) StatusCode() int {
 	return e.Status
 }
 
+// WithRetryAfter 设置建议的重试等待时间并返回自身。
+func (e *HTTPError) WithRetryAfter(d time.Duration) *HTTPError {
+	e.RetryAfter = d
+	return e
+}
+
 // NewHTTPError 根据格式化字符串构造一个 HTTPError。
 func NewHTTPError(status int, format string, args ...any) *HTTPError {
 	return &HTTPError{
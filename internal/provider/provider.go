@@ -0,0 +1,145 @@
+// Package provider 描述可对接的上游后端（豆包及其国际版 Cici）。
+// 各后端的网页协议基本一致，差异集中在域名、aid/地区参数、请求头以及 SSE 事件编号上。
+package provider
+
+import (
+	"fmt"
+	"sort"
+
+	"DoubaoProxy/internal/config"
+	"DoubaoProxy/internal/fingerprint"
+)
+
+// 内置后端名称。
+const (
+	Doubao = "doubao"
+	Cici   = "cici"
+)
+
+// Default 是 Session 未声明 provider 时使用的后端。
+const Default = Doubao
+
+// EventSpec 描述后端 SSE 流中的事件编号与内容类型。
+type EventSpec struct {
+	Message           int
+	Meta              int
+	End               int
+	TextContentTypes  []int
+	ImageContentTypes []int
+	// LimitMarkers 出现在事件数据中时表示游客额度耗尽。
+	LimitMarkers []string
+}
+
+// IsText 报告 contentType 是否为文本消息。
+func (e EventSpec) IsText(contentType int) bool {
+	return contains(e.TextContentTypes, contentType)
+}
+
+// IsImage 报告 contentType 是否为图片生成结果。
+func (e EventSpec) IsImage(contentType int) bool {
+	return contains(e.ImageContentTypes, contentType)
+}
+
+// Provider 是一个上游后端的完整定义。
+type Provider struct {
+	Name          string
+	BaseURL       string
+	ImageXBaseURL string
+	TOSBaseURL    string
+	// ImageXRegion 是 ImageX SigV4 签名使用的区域。
+	ImageXRegion string
+	// RegisterURL 是申请游客设备标识（web_id）的注册接口。
+	RegisterURL string
+	// Fingerprint 是该后端的默认客户端指纹，全局与 Session 级配置在此基础上覆盖。
+	Fingerprint fingerprint.Profile
+	Events      EventSpec
+}
+
+// Registry 按名称保存全部可用后端。
+type Registry map[string]*Provider
+
+// Known 报告 name 是否为内置后端名称，空字符串视为默认后端。
+func Known(name string) bool {
+	switch name {
+	case "", Doubao, Cici:
+		return true
+	default:
+		return false
+	}
+}
+
+// FromConfig 根据配置构造内置后端注册表。
+func FromConfig(cfg config.Config) Registry {
+	doubaoEvents := EventSpec{
+		Message:           2001,
+		Meta:              2002,
+		End:               2003,
+		TextContentTypes:  []int{10000, 2001, 2008},
+		ImageContentTypes: []int{2074},
+		LimitMarkers:      []string{"tourist conversation reach limited"},
+	}
+
+	ciciEvents := doubaoEvents
+	ciciEvents.LimitMarkers = []string{"tourist conversation reach limited", "guest conversation reach limited"}
+
+	return Registry{
+		Doubao: {
+			Name:          Doubao,
+			BaseURL:       cfg.DoubaoBaseURL,
+			ImageXBaseURL: cfg.ImageXBaseURL,
+			TOSBaseURL:    cfg.TOSBaseURL,
+			ImageXRegion:  "cn-north-1",
+			RegisterURL:   cfg.DoubaoRegisterURL,
+			Fingerprint:   fingerprint.Default(),
+			Events:        doubaoEvents,
+		},
+		Cici: {
+			Name:          Cici,
+			BaseURL:       cfg.CiciBaseURL,
+			ImageXBaseURL: cfg.CiciImageXBaseURL,
+			TOSBaseURL:    cfg.CiciTOSBaseURL,
+			ImageXRegion:  "ap-singapore-1",
+			RegisterURL:   cfg.CiciRegisterURL,
+			Fingerprint: fingerprint.Profile{
+				UserAgent:   fingerprint.Default().UserAgent,
+				PCVersion:   "1.40.1",
+				VersionCode: "20800",
+				AID:         "495671",
+				Region:      "SG",
+				Language:    "en",
+			},
+			Events: ciciEvents,
+		},
+	}
+}
+
+// Get 返回指定名称的后端，空名称返回默认后端。
+func (r Registry) Get(name string) (*Provider, error) {
+	if name == "" {
+		name = Default
+	}
+	p, ok := r[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q (available: %v)", name, r.Names())
+	}
+	return p, nil
+}
+
+// Names 返回按字母排序的后端名称。
+func (r Registry) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func contains(values []int, v int) bool {
+	for _, item := range values {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
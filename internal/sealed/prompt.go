@@ -0,0 +1,45 @@
+package sealed
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// CanPrompt 报告当前进程能否从终端读取口令。
+func CanPrompt() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// PromptPassphrase 在终端上提示输入口令（不回显）。confirm 为 true 时要求输入两次。
+func PromptPassphrase(prompt string, confirm bool) (*Key, error) {
+	if !CanPrompt() {
+		return nil, errors.New("cannot prompt for passphrase: stdin is not a terminal")
+	}
+	first, err := readPassword(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		second, err := readPassword("Repeat " + prompt)
+		if err != nil {
+			return nil, err
+		}
+		if first != second {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	return Passphrase(first)
+}
+
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt+": ")
+	defer fmt.Fprintln(os.Stderr)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", fmt.Errorf("read passphrase: %w", err)
+	}
+	return string(b), nil
+}
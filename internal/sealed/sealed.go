@@ -0,0 +1,221 @@
+// Package sealed 用 AES-256-GCM 加密保存在磁盘上的 Session 配置。
+//
+// 密文是一个 JSON 信封，记录密钥派生方式、盐与随机数，明文的 Session 配置是 JSON 数组，
+// 两者可以据此区分。密钥可以是 32 字节的原始密钥，也可以是经 scrypt 派生的口令。
+package sealed
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Format 标识信封格式，同时作为 GCM 的附加认证数据。
+const Format = "doubaoproxy-sealed/v1"
+
+// KeySize 为原始密钥的字节数（AES-256）。
+const KeySize = 32
+
+const (
+	kdfNone   = "none"
+	kdfScrypt = "scrypt"
+)
+
+// ErrNoKey 表示文件已加密但没有配置任何密钥。
+var ErrNoKey = errors.New("session config is encrypted but no key is configured (set SESSION_KEY, SESSION_KEY_FILE or SESSION_PASSPHRASE)")
+
+type envelope struct {
+	Format     string        `json:"format"`
+	KDF        string        `json:"kdf"`
+	Scrypt     *scryptParams `json:"scrypt,omitempty"`
+	Salt       string        `json:"salt,omitempty"`
+	Nonce      string        `json:"nonce"`
+	Ciphertext string        `json:"ciphertext"`
+}
+
+type scryptParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+// defaultScrypt 在普通服务器上派生一次约需 100ms，对一次加载或写回可以接受。
+var defaultScrypt = scryptParams{N: 1 << 15, R: 8, P: 1}
+
+// Key 是加解密使用的密钥：原始密钥或口令二者之一。
+type Key struct {
+	raw        []byte
+	passphrase []byte
+}
+
+// NewKey 用 32 字节的原始密钥构造 Key。
+func NewKey(raw []byte) (*Key, error) {
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	return &Key{raw: append([]byte(nil), raw...)}, nil
+}
+
+// ParseKey 解析 base64 编码的原始密钥。
+func ParseKey(encoded string) (*Key, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %w", err)
+	}
+	return NewKey(raw)
+}
+
+// ReadKeyFile 读取保存 base64 原始密钥的文件。
+func ReadKeyFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	key, err := ParseKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// Passphrase 用口令构造 Key，每次加密都会生成新的盐并经 scrypt 派生密钥。
+func Passphrase(passphrase string) (*Key, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	return &Key{passphrase: []byte(passphrase)}, nil
+}
+
+// GenerateKey 生成一个随机原始密钥并以 base64 返回。
+func GenerateKey() (string, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// IsSealed 报告 data 是否为本包生成的密文信封。
+func IsSealed(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("{")) {
+		return false
+	}
+	var env struct {
+		Format string `json:"format"`
+	}
+	return json.Unmarshal(data, &env) == nil && env.Format == Format
+}
+
+// Seal 加密 plaintext 并返回 JSON 信封。
+func Seal(key *Key, plaintext []byte) ([]byte, error) {
+	if key == nil {
+		return nil, ErrNoKey
+	}
+	env := envelope{Format: Format, KDF: kdfNone}
+	var salt []byte
+	if key.passphrase != nil {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		params := defaultScrypt
+		env.KDF = kdfScrypt
+		env.Scrypt = &params
+		env.Salt = base64.StdEncoding.EncodeToString(salt)
+	}
+	aead, err := key.aead(env.KDF, env.Scrypt, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	env.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	env.Ciphertext = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, []byte(Format)))
+
+	out, err := json.MarshalIndent(env, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// Open 解密 Seal 生成的信封。
+func Open(key *Key, data []byte) ([]byte, error) {
+	if key == nil {
+		return nil, ErrNoKey
+	}
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("decode sealed envelope: %w", err)
+	}
+	if env.Format != Format {
+		return nil, fmt.Errorf("unsupported sealed format %q", env.Format)
+	}
+	salt, err := base64.StdEncoding.DecodeString(env.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("decode salt: %w", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("decode nonce: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("decode ciphertext: %w", err)
+	}
+
+	aead, err := key.aead(env.KDF, env.Scrypt, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(Format))
+	if err != nil {
+		return nil, errors.New("wrong key or corrupted file")
+	}
+	return plaintext, nil
+}
+
+// aead 按信封记录的派生方式得到 AES-GCM 实例。信封的 kdf 必须与密钥类型一致。
+func (k *Key) aead(kdf string, params *scryptParams, salt []byte) (cipher.AEAD, error) {
+	var raw []byte
+	switch {
+	case kdf == kdfNone && k.raw != nil:
+		raw = k.raw
+	case kdf == kdfScrypt && k.passphrase != nil:
+		if params == nil || len(salt) == 0 {
+			return nil, errors.New("sealed envelope is missing scrypt parameters")
+		}
+		derived, err := scrypt.Key(k.passphrase, salt, params.N, params.R, params.P, KeySize)
+		if err != nil {
+			return nil, fmt.Errorf("derive key: %w", err)
+		}
+		raw = derived
+	case kdf == kdfScrypt:
+		return nil, errors.New("session config was encrypted with a passphrase, but a raw key is configured")
+	case kdf == kdfNone:
+		return nil, errors.New("session config was encrypted with a raw key, but a passphrase is configured")
+	default:
+		return nil, fmt.Errorf("unsupported kdf %q", kdf)
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
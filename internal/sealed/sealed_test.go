@@ -0,0 +1,163 @@
+package sealed
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const plaintext = `[{"id":"a","cookie":"sessionid=secret"}]`
+
+func rawKey(t *testing.T) *Key {
+	t.Helper()
+	encoded, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func passphraseKey(t *testing.T, passphrase string) *Key {
+	t.Helper()
+	key, err := Passphrase(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func seal(t *testing.T, key *Key, data string) []byte {
+	t.Helper()
+	sealed, err := Seal(key, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, key := range map[string]*Key{"raw": rawKey(t), "passphrase": passphraseKey(t, "correct horse")} {
+		t.Run(name, func(t *testing.T) {
+			sealed := seal(t, key, plaintext)
+			if !IsSealed(sealed) {
+				t.Fatal("IsSealed = false for sealed output")
+			}
+			if bytes.Contains(sealed, []byte("secret")) {
+				t.Fatalf("sealed output leaks plaintext: %s", sealed)
+			}
+			got, err := Open(key, sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != plaintext {
+				t.Errorf("Open = %q, want %q", got, plaintext)
+			}
+			// 每次加密使用新的随机数（口令另有新的盐），同一明文的密文不同。
+			if again := seal(t, key, plaintext); bytes.Equal(again, sealed) {
+				t.Error("sealing twice produced identical output")
+			}
+		})
+	}
+	if IsSealed([]byte(plaintext)) {
+		t.Error("IsSealed = true for a plaintext config")
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	oldKey, newKey := passphraseKey(t, "old passphrase"), rawKey(t)
+	before := seal(t, oldKey, plaintext)
+
+	// 与 rotate-key 子命令相同：用旧密钥解密，再以新密钥加密。
+	opened, err := Open(oldKey, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := seal(t, newKey, string(opened))
+
+	if got, err := Open(newKey, after); err != nil || string(got) != plaintext {
+		t.Fatalf("Open(new key) = %q, %v", got, err)
+	}
+	// 轮换不改变已有的密文：旧备份仍可用旧密钥打开。
+	if got, err := Open(oldKey, before); err != nil || string(got) != plaintext {
+		t.Errorf("Open(old key, old ciphertext) = %q, %v", got, err)
+	}
+	if _, err := Open(oldKey, after); err == nil {
+		t.Error("old key opened the rotated file")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	key := rawKey(t)
+	sealed := seal(t, key, plaintext)
+
+	// tamper 解码信封、修改后重新编码。
+	tamper := func(edit func(env *envelope)) []byte {
+		var env envelope
+		if err := json.Unmarshal(sealed, &env); err != nil {
+			t.Fatal(err)
+		}
+		edit(&env)
+		out, err := json.Marshal(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	flip := func(encoded string) string {
+		raw, _ := base64.StdEncoding.DecodeString(encoded)
+		raw[len(raw)-1] ^= 1
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		name string
+		key  *Key
+		data []byte
+		want string
+	}{
+		{"wrong raw key", rawKey(t), sealed, "wrong key or corrupted file"},
+		{"passphrase for raw ciphertext", passphraseKey(t, "p"), sealed, "encrypted with a raw key"},
+		{"raw key for passphrase ciphertext", key, seal(t, passphraseKey(t, "p"), plaintext), "encrypted with a passphrase"},
+		{"wrong passphrase", passphraseKey(t, "wrong"), seal(t, passphraseKey(t, "right"), plaintext), "wrong key or corrupted file"},
+		{"tampered ciphertext", key, tamper(func(env *envelope) { env.Ciphertext = flip(env.Ciphertext) }), "wrong key or corrupted file"},
+		{"tampered nonce", key, tamper(func(env *envelope) { env.Nonce = flip(env.Nonce) }), "wrong key or corrupted file"},
+		{"truncated nonce", key, tamper(func(env *envelope) { env.Nonce = base64.StdEncoding.EncodeToString([]byte("short")) }), "invalid nonce size"},
+		{"unknown format", key, tamper(func(env *envelope) { env.Format = "doubaoproxy-sealed/v0" }), "unsupported sealed format"},
+		{"unknown kdf", key, tamper(func(env *envelope) { env.KDF = "argon2" }), "unsupported kdf"},
+		{"not an envelope", key, []byte(plaintext), "decode sealed envelope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Open(tt.key, tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Open = %q, %v; want error containing %q", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := Open(nil, sealed); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Open(nil key) err = %v, want ErrNoKey", err)
+	}
+	if _, err := Seal(nil, []byte(plaintext)); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Seal(nil key) err = %v, want ErrNoKey", err)
+	}
+}
+
+func TestKeyValidation(t *testing.T) {
+	if _, err := NewKey(make([]byte, 16)); err == nil {
+		t.Error("NewKey accepted a 16-byte key")
+	}
+	if _, err := ParseKey("not base64!"); err == nil {
+		t.Error("ParseKey accepted invalid base64")
+	}
+	if _, err := Passphrase(""); err == nil {
+		t.Error("Passphrase accepted an empty passphrase")
+	}
+}
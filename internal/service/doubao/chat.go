@@ -7,25 +7,134 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 
+	"DoubaoProxy/internal/fingerprint"
 	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/provider"
 	"DoubaoProxy/internal/session"
 )
 
 // ChatCompletion 代理豆包的 SSE 聊天接口。
+// 新会话（未携带 conversation_id）在 Session 故障时会换用其他 Session 重试，
+// 最多尝试 cfg.FailoverAttempts 次；已有会话只能由绑定的 Session 继续，指定了 Session 的请求也不转移。
+// 回退策略允许时，请求的类别（登录或游客）没有可用 Session 的新会话改由另一类 Session 服务，
+// 回退到游客时响应不携带上下文，无法继续对话；回退到登录账号时照常返回上下文并建立绑定。
 func (s *Service) ChatCompletion(ctx context.Context, req model.CompletionRequest) (*model.CompletionResponse, error) {
-	session, err := s.pool.GetSession(req.ConversationID, req.Guest)
+	route := routeFrom(ctx)
+	newConversation := req.ConversationID == "" && route.Pin == ""
+	attempts := 1
+	if newConversation {
+		attempts = max(s.cfg.FailoverAttempts, 1)
+	}
+
+	var (
+		tried    []string
+		lastErr  error
+		guest    = req.Guest
+		fellBack bool
+	)
+	for i := 0; i < attempts; i++ {
+		if i > 0 && ctx.Err() != nil {
+			break
+		}
+		criteria := session.Criteria{
+			ConversationID: req.ConversationID,
+			Guest:          guest,
+			Provider:       req.Provider,
+			Quota:          session.QuotaChat,
+			Exclude:        tried,
+			Affinity:       req.AffinityKey,
+			Tags:           route.Tags,
+			Pin:            route.Pin,
+		}
+		sess, err := s.pool.GetSession(criteria)
+		if err != nil && newConversation && !fellBack && s.canFallback(req, err) {
+			s.logger.Info("no usable sessions in requested class, falling back", "guest", guest, "error", err)
+			guest, fellBack = !guest, true
+			criteria.Guest = guest
+			sess, err = s.pool.GetSession(criteria)
+		}
+		if err != nil {
+			// 已有失败时返回上游错误，比"没有可用 Session"更有助于排查。
+			if lastErr != nil {
+				break
+			}
+			return nil, err
+		}
+		tried = append(tried, sess.ID)
+
+		resp, err := s.chatOnce(ctx, sess, req, fellBack)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		if !retryable(ctx, err) {
+			break
+		}
+		if i+1 < attempts {
+			s.logger.Warn("chat attempt failed, failing over", "session", sess.ID, "attempt", i+1, "error", err)
+		}
+	}
+	return nil, lastErr
+}
+
+// chatOnce 使用指定 Session 完成一次聊天，并记录健康状态、用量、会话绑定与尝试轨迹。
+// fallback 为 true 且 Session 为游客时，回退得到的会话不可继续，不建立绑定并清除响应中的上下文。
+func (s *Service) chatOnce(ctx context.Context, sess *session.Session, req model.CompletionRequest, fallback bool) (*model.CompletionResponse, error) {
+	trace := traceFrom(ctx)
+	prov, err := s.providerFor(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先取得并发名额再预约节奏，排队超时的请求不会留下预约。
+	release, err := s.pool.Acquire(ctx, sess)
+	if err != nil {
+		trace.add(Attempt{SessionID: sess.ID, Outcome: outcomeBusy, Reason: err.Error()})
+		return nil, err
+	}
+	if err := s.pool.Pace(ctx, sess, session.PaceMessage); err != nil {
+		release()
+		trace.add(Attempt{SessionID: sess.ID, Outcome: outcomePaced, Reason: err.Error()})
+		return nil, err
+	}
+	resp, err := s.sendChat(ctx, sess, prov, req)
+	release()
+	s.reportOutcome(ctx, sess, err)
 	if err != nil {
+		outcome := outcomeError
+		if kind, ok := classifyFailure(err); ok {
+			outcome = kind.String()
+		}
+		trace.add(Attempt{SessionID: sess.ID, Outcome: outcome, Reason: err.Error()})
 		return nil, err
 	}
+	trace.add(Attempt{SessionID: sess.ID, Outcome: outcomeOK, Guest: sess.Guest, Fallback: fallback})
+
+	s.pool.RecordChat(sess, len(resp.ImgURLs))
+	switch {
+	case fallback && sess.Guest:
+		resp.ConversationID, resp.SectionID = "", ""
+	case resp.ConversationID != "":
+		s.pool.BindConversation(resp.ConversationID, sess)
+	}
+	return resp, nil
+}
 
-	endpoint := buildChatURL(session)
-	body := buildChatPayload(req, session)
+// sendChat 使用指定 Session 向上游发起一次聊天请求并解析 SSE 结果。
+func (s *Service) sendChat(ctx context.Context, session *session.Session, prov *provider.Provider, req model.CompletionRequest) (*model.CompletionResponse, error) {
+	out, err := s.sessionEgress(session)
+	if err != nil {
+		return nil, err
+	}
+	cred := session.Snapshot()
+	fp := s.profileFor(&cred, prov)
+	endpoint := buildChatURL(prov.BaseURL, &cred, fp)
+	body := buildChatPayload(req, &cred)
 	payload, err := json.Marshal(body)
 	if err != nil {
 		return nil, fmt.Errorf("marshal chat payload: %w", err)
@@ -36,17 +145,17 @@ func (s *Service) ChatCompletion(ctx context.Context, req model.CompletionReques
 		return nil, fmt.Errorf("create chat request: %w", err)
 	}
 
-	// 豆包要求与浏览器一致的 SSE 请求头。
+	// 上游要求与浏览器一致的 SSE 请求头。
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Accept", "text/event-stream")
 	httpReq.Header.Set("Agw-Js-Conv", "str")
-	httpReq.Header.Set("Cookie", session.Cookie)
-	httpReq.Header.Set("Origin", "https://www.doubao.com")
-	httpReq.Header.Set("Referer", fmt.Sprintf("https://www.doubao.com/chat/%s", session.RoomID))
-	httpReq.Header.Set("User-Agent", defaultUserAgent)
-	httpReq.Header.Set("X-Flow-Trace", session.XFlowTrace)
+	httpReq.Header.Set("Origin", prov.BaseURL)
+	httpReq.Header.Set("Referer", fmt.Sprintf("%s/chat/%s", prov.BaseURL, cred.RoomID))
+	httpReq.Header.Set("X-Flow-Trace", cred.XFlowTrace)
+	fp.SetHeaders(httpReq.Header)
 
-	resp, err := s.streamingClient.Do(httpReq)
+	start := time.Now()
+	resp, err := s.sessionClient(out.streamingClient, session, prov).Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("call doubao chat: %w", err)
 	}
@@ -56,19 +165,13 @@ func (s *Service) ChatCompletion(ctx context.Context, req model.CompletionReques
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		return nil, model.NewHTTPError(resp.StatusCode, "doubao chat failed: %s", strings.TrimSpace(string(bodyBytes)))
 	}
+	s.pool.ObserveTTFB(session, time.Since(start))
 
-	text, images, conversationID, messageID, sectionID, err := parseSSE(resp.Body)
+	text, images, conversationID, messageID, sectionID, err := parseSSE(resp.Body, prov.Events)
 	if err != nil {
-		if httpErr, ok := err.(*model.HTTPError); ok && httpErr.StatusCode() == http.StatusTooManyRequests {
-			s.pool.RemoveSession(session)
-		}
 		return nil, err
 	}
 
-	if conversationID != "" {
-		s.pool.BindConversation(conversationID, session)
-	}
-
 	return &model.CompletionResponse{
 		Text:           strings.TrimSpace(text),
 		ImgURLs:        images,
@@ -78,23 +181,8 @@ func (s *Service) ChatCompletion(ctx context.Context, req model.CompletionReques
 	}, nil
 }
 
-func buildChatURL(session *session.Session) string {
-	query := url.Values{}
-	query.Set("aid", "497858")
-	query.Set("device_id", session.DeviceID)
-	query.Set("device_platform", "web")
-	query.Set("language", "zh")
-	query.Set("pc_version", "2.23.2")
-	query.Set("pkg_type", "release_version")
-	query.Set("real_aid", "497858")
-	query.Set("region", "CN")
-	query.Set("samantha_web", "1")
-	query.Set("sys_region", "CN")
-	query.Set("tea_uuid", session.TeaUUID)
-	query.Set("use-olympus-account", "1")
-	query.Set("version_code", "20800")
-	query.Set("web_id", session.WebID)
-	return "https://www.doubao.com/samantha/chat/completion?" + query.Encode()
+func buildChatURL(baseURL string, session *session.Session, fp fingerprint.Profile) string {
+	return baseURL + "/samantha/chat/completion?" + webQuery(session, fp).Encode()
 }
 
 func buildChatPayload(req model.CompletionRequest, session *session.Session) map[string]any {
@@ -160,5 +248,3 @@ func mustJSON(v any) string {
 	}
 	return string(buf)
 }
-
-const defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Safari/537.36 Edg/137.0.0.0"
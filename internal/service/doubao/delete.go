@@ -7,21 +7,34 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"strings"
 
+	"DoubaoProxy/internal/fingerprint"
 	"DoubaoProxy/internal/model"
 	"DoubaoProxy/internal/session"
 )
 
 // DeleteConversation 调用豆包接口删除指定的会话。
 func (s *Service) DeleteConversation(ctx context.Context, conversationID string) (*model.DeleteResponse, error) {
-	session, err := s.pool.GetSession(conversationID, false)
+	sess, err := s.pool.GetSession(session.Criteria{ConversationID: conversationID})
 	if err != nil {
 		return nil, err
 	}
 
-	endpoint := buildDeleteURL(session)
+	prov, err := s.providerFor(sess)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.sessionEgress(sess)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.pool.Pace(ctx, sess, session.PaceRequest); err != nil {
+		return nil, err
+	}
+	cred := sess.Snapshot()
+	fp := s.profileFor(&cred, prov)
+	endpoint := buildDeleteURL(prov.BaseURL, &cred, fp)
 	body := map[string]string{"conversation_id": conversationID}
 	payload, err := json.Marshal(body)
 	if err != nil {
@@ -34,41 +47,30 @@ func (s *Service) DeleteConversation(ctx context.Context, conversationID string)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Cookie", session.Cookie)
-	req.Header.Set("Origin", "https://www.doubao.com")
-	req.Header.Set("Referer", fmt.Sprintf("https://www.doubao.com/chat/%s", conversationID))
-	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Origin", prov.BaseURL)
+	req.Header.Set("Referer", fmt.Sprintf("%s/chat/%s", prov.BaseURL, conversationID))
+	fp.SetHeaders(req.Header)
 
-	resp, err := s.httpClient.Do(req)
+	resp, err := s.sessionClient(out.httpClient, sess, prov).Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("call doubao delete: %w", err)
+		err = fmt.Errorf("call doubao delete: %w", err)
+		s.reportOutcome(ctx, sess, err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return &model.DeleteResponse{OK: false, Msg: strings.TrimSpace(string(bodyBytes))}, nil
+		msg := strings.TrimSpace(string(bodyBytes))
+		s.reportOutcome(ctx, sess, model.NewHTTPError(resp.StatusCode, "doubao delete failed: %s", msg))
+		return &model.DeleteResponse{OK: false, Msg: msg}, nil
 	}
+	s.reportOutcome(ctx, sess, nil)
 
 	s.pool.ForgetConversation(conversationID)
 	return &model.DeleteResponse{OK: true, Msg: ""}, nil
 }
 
-func buildDeleteURL(session *session.Session) string {
-	values := url.Values{}
-	values.Set("aid", "497858")
-	values.Set("device_id", session.DeviceID)
-	values.Set("device_platform", "web")
-	values.Set("language", "zh")
-	values.Set("pc_version", "2.20.0")
-	values.Set("pkg_type", "release_version")
-	values.Set("real_aid", "497858")
-	values.Set("region", "CN")
-	values.Set("samantha_web", "1")
-	values.Set("sys_region", "CN")
-	values.Set("tea_uuid", session.TeaUUID)
-	values.Set("use-olympus-account", "1")
-	values.Set("version_code", "20800")
-	values.Set("web_id", session.WebID)
-	return "https://www.doubao.com/samantha/thread/delete?" + values.Encode()
+func buildDeleteURL(baseURL string, session *session.Session, fp fingerprint.Profile) string {
+	return baseURL + "/samantha/thread/delete?" + webQuery(session, fp).Encode()
 }
@@ -0,0 +1,61 @@
+package doubao
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"DoubaoProxy/internal/session"
+)
+
+// egress 是经由同一出口（代理或直连）访问上游的一组客户端，同一出口的 Session 共享连接池。
+type egress struct {
+	httpClient      *http.Client
+	streamingClient *http.Client
+}
+
+// egressFor 返回出站代理 proxy 对应的客户端，首次使用时创建。proxy 为空时沿用全局默认代理，
+// 二者都为空时与标准库一样读取 HTTPS_PROXY 等环境变量；direct 表示直连。
+// 启用上游卡带时全部请求都经过卡带，代理配置不生效。
+func (s *Service) egressFor(proxy string) (*egress, error) {
+	if s.cassette != nil {
+		return s.cassette, nil
+	}
+	key := strings.TrimSpace(proxy)
+	if key == "" {
+		key = strings.TrimSpace(s.cfg.UpstreamProxy)
+	}
+
+	s.egressMu.Lock()
+	defer s.egressMu.Unlock()
+	if e, ok := s.egresses[key]; ok {
+		return e, nil
+	}
+
+	u, err := session.ParseProxy(key)
+	if err != nil {
+		return nil, fmt.Errorf("configure upstream proxy: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	switch {
+	case u != nil:
+		transport.Proxy = http.ProxyURL(u)
+	case key != "":
+		transport.Proxy = nil
+	}
+	e := s.newEgress(transport)
+	s.egresses[key] = e
+	return e, nil
+}
+
+func (s *Service) newEgress(transport http.RoundTripper) *egress {
+	return &egress{
+		httpClient:      &http.Client{Timeout: s.cfg.HTTPClientTimeout, Transport: transport},
+		streamingClient: &http.Client{Timeout: s.cfg.HTTPClientTimeout, Transport: transport},
+	}
+}
+
+// sessionEgress 返回 Session 所用出口的客户端，上传的四个步骤都经由它发出，保证出口 IP 一致。
+func (s *Service) sessionEgress(sess *session.Session) (*egress, error) {
+	return s.egressFor(sess.Snapshot().Proxy)
+}
@@ -0,0 +1,136 @@
+package doubao_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"testing"
+
+	"DoubaoProxy/internal/config"
+	"DoubaoProxy/internal/fakedoubao"
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/service/doubao"
+	"DoubaoProxy/internal/session"
+)
+
+// forwardProxy 是记录经手请求的 HTTP 正向代理。
+type forwardProxy struct {
+	*httptest.Server
+	mu    sync.Mutex
+	paths []string
+}
+
+func newForwardProxy(t *testing.T) *forwardProxy {
+	t.Helper()
+	p := &forwardProxy{}
+	rp := &httputil.ReverseProxy{
+		// 正向代理收到的是绝对地址，原样转发。
+		Rewrite:       func(pr *httputil.ProxyRequest) { pr.Out.URL = pr.In.URL },
+		FlushInterval: -1,
+	}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.paths = append(p.paths, r.URL.Path)
+		p.mu.Unlock()
+		rp.ServeHTTP(w, r)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *forwardProxy) seen() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.paths...)
+}
+
+func proxiedSession(id, proxy string) session.Session {
+	s := testSession(id, "sessionid="+id, false)
+	s.Proxy = proxy
+	return s
+}
+
+func TestEgressPerSessionProxy(t *testing.T) {
+	own, global := newForwardProxy(t), newForwardProxy(t)
+	fake := fakedoubao.New()
+	t.Cleanup(fake.Close)
+	service, _ := startService(t, []session.Session{
+		proxiedSession("own", own.URL),
+		proxiedSession("direct", session.ProxyDirect),
+		proxiedSession("default", ""),
+	}, session.Options{}, func(cfg config.Config) config.Config {
+		cfg = fake.Apply(cfg)
+		cfg.UpstreamProxy = global.URL
+		return cfg
+	})
+	ctx := context.Background()
+	chat := func(id string) {
+		t.Helper()
+		pinned := doubao.WithRoute(ctx, doubao.Route{Pin: id})
+		if _, err := service.ChatCompletion(pinned, model.CompletionRequest{Prompt: "hi"}); err != nil {
+			t.Fatalf("chat via %s: %v", id, err)
+		}
+	}
+
+	chat("own")
+	if got := own.seen(); len(got) != 1 || got[0] != "/samantha/chat/completion" {
+		t.Errorf("session proxy saw %v, want one chat", got)
+	}
+	chat("direct")
+	chat("default")
+	if got := global.seen(); len(got) != 1 {
+		t.Errorf("default proxy saw %v, want only the default session's chat", got)
+	}
+	if n := len(own.seen()); n != 1 {
+		t.Errorf("session proxy saw %d requests, want 1", n)
+	}
+	if n := len(fake.Requests()); n != 3 {
+		t.Errorf("upstream got %d requests, want 3", n)
+	}
+}
+
+func TestEgressUploadUsesSessionProxy(t *testing.T) {
+	own := newForwardProxy(t)
+	service, _, fake := newTestService(t, []session.Session{proxiedSession("own", own.URL)}, session.Options{})
+
+	if _, err := service.UploadFile(context.Background(), "", 2, "pic.png", []byte("png-bytes")); err != nil {
+		t.Fatal(err)
+	}
+	// prepare_upload、ApplyImageUpload、TOS 上传与 CommitImageUpload 都经由同一出口。
+	if got, want := len(own.seen()), len(fake.Requests()); got != want || got < 4 {
+		t.Errorf("proxy saw %d of %d upstream requests: %v", got, want, own.seen())
+	}
+}
+
+func TestEgressRejectsInvalidProxy(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := session.NewPool(t.TempDir()+"/session.json", session.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, proxy := range []string{"ftp://proxy.example:21", "http://", "http://user:secret@[::1"} {
+		cfg.UpstreamProxy = proxy
+		_, err := doubao.NewService(pool, nil, cfg, logger)
+		if err == nil || !strings.Contains(err.Error(), "invalid UPSTREAM_PROXY") {
+			t.Errorf("UPSTREAM_PROXY=%q: err = %v", proxy, err)
+			continue
+		}
+		if strings.Contains(err.Error(), "secret") {
+			t.Errorf("error leaks the proxy password: %v", err)
+		}
+
+		_, err = pool.AddSession(proxiedSession("bad", proxy))
+		if statusCode(err) != http.StatusBadRequest || strings.Contains(err.Error(), "secret") {
+			t.Errorf("session proxy %q: err = %v", proxy, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package doubao
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"DoubaoProxy/internal/model"
+)
+
+// FallbackPolicy 决定新会话在请求的账号类别（登录或游客）无法提供服务时，能否改用另一类 Session。
+type FallbackPolicy string
+
+const (
+	// FallbackStrict 不做回退，请求的类别没有可用 Session 时直接报错。
+	FallbackStrict FallbackPolicy = "strict"
+	// FallbackToGuest 允许登录账号请求回退到游客 Session。
+	FallbackToGuest FallbackPolicy = "prefer-auth-fallback-guest"
+	// FallbackToAuth 允许游客请求回退到登录账号 Session。
+	FallbackToAuth FallbackPolicy = "prefer-guest-fallback-auth"
+)
+
+// ParseFallbackPolicy 解析回退策略，空串视为 strict。
+func ParseFallbackPolicy(raw string) (FallbackPolicy, error) {
+	switch p := FallbackPolicy(strings.ToLower(strings.TrimSpace(raw))); p {
+	case "":
+		return FallbackStrict, nil
+	case FallbackStrict, FallbackToGuest, FallbackToAuth:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown session fallback %q (want %s, %s or %s)", raw, FallbackStrict, FallbackToGuest, FallbackToAuth)
+	}
+}
+
+// canFallback 报告新会话在挑选 Session 失败（err）后能否改用另一类 Session。
+// 仅在本类别没有 Session、额度用尽或全部不可用时回退；游客不能使用附件，带附件的请求不会回退到游客。
+func (s *Service) canFallback(req model.CompletionRequest, err error) bool {
+	switch {
+	case s.fallback == FallbackToGuest && !req.Guest:
+		if len(req.Attachments) > 0 {
+			return false
+		}
+	case s.fallback == FallbackToAuth && req.Guest:
+	default:
+		return false
+	}
+	var httpErr *model.HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	switch httpErr.StatusCode() {
+	case http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	default:
+		return false
+	}
+}
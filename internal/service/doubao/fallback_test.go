@@ -0,0 +1,117 @@
+package doubao_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"DoubaoProxy/internal/config"
+	"DoubaoProxy/internal/fakedoubao"
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/service/doubao"
+	"DoubaoProxy/internal/session"
+)
+
+func newFallbackService(t *testing.T, policy doubao.FallbackPolicy, sessions ...session.Session) (*doubao.Service, *session.Pool, *fakedoubao.Server) {
+	t.Helper()
+	fake := fakedoubao.New()
+	t.Cleanup(fake.Close)
+	service, pool := startService(t, sessions, session.Options{}, func(cfg config.Config) config.Config {
+		cfg = fake.Apply(cfg)
+		cfg.SessionFallback = string(policy)
+		return cfg
+	})
+	return service, pool, fake
+}
+
+func TestParseFallbackPolicy(t *testing.T) {
+	for raw, want := range map[string]doubao.FallbackPolicy{
+		"":                            doubao.FallbackStrict,
+		"strict":                      doubao.FallbackStrict,
+		" Prefer-Auth-Fallback-Guest": doubao.FallbackToGuest,
+		"prefer-guest-fallback-auth":  doubao.FallbackToAuth,
+	} {
+		if got, err := doubao.ParseFallbackPolicy(raw); err != nil || got != want {
+			t.Errorf("ParseFallbackPolicy(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	if _, err := doubao.ParseFallbackPolicy("guest-only"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
+
+func TestStrictDoesNotFallBack(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newFallbackService(t, doubao.FallbackStrict, testSession("g", "ttwid=g", true))
+	if _, err := service.ChatCompletion(ctx, model.CompletionRequest{Prompt: "hi"}); statusCode(err) != http.StatusNotFound {
+		t.Errorf("auth request: err = %v, want 404", err)
+	}
+	service, _, _ = newFallbackService(t, doubao.FallbackStrict, testSession("a", "sessionid=a", false))
+	if _, err := service.ChatCompletion(ctx, model.CompletionRequest{Prompt: "hi", Guest: true}); statusCode(err) != http.StatusNotFound {
+		t.Errorf("guest request: err = %v, want 404", err)
+	}
+}
+
+func TestFallbackToGuest(t *testing.T) {
+	service, pool, _ := newFallbackService(t, doubao.FallbackToGuest, testSession("g", "ttwid=g", true))
+	ctx, trace := doubao.WithTrace(context.Background())
+
+	resp, err := service.ChatCompletion(ctx, model.CompletionRequest{Prompt: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class, fellBack := trace.ServedClass(); class != "guest" || !fellBack {
+		t.Errorf("served class = %s, fallback = %v; want guest, true", class, fellBack)
+	}
+	// 游客无法继续对话，响应不携带上下文，也不留下绑定。
+	if resp.ConversationID != "" || resp.SectionID != "" {
+		t.Errorf("guest fallback returned context %q/%q", resp.ConversationID, resp.SectionID)
+	}
+	if n := pool.Sessions()[0].Conversations; n != 0 {
+		t.Errorf("guest fallback bound %d conversations", n)
+	}
+
+	attachment := model.CompletionRequest{Prompt: "look", Attachments: []model.Attachment{{Key: "k", Name: "pic.png"}}}
+	if _, err := service.ChatCompletion(context.Background(), attachment); statusCode(err) != http.StatusNotFound {
+		t.Errorf("attachment request: err = %v, want 404 without fallback", err)
+	}
+
+	// 该策略只允许登录请求回退，游客请求不会改用登录 Session。
+	service, _, _ = newFallbackService(t, doubao.FallbackToGuest, testSession("a", "sessionid=a", false))
+	if _, err := service.ChatCompletion(context.Background(), model.CompletionRequest{Prompt: "hi", Guest: true}); statusCode(err) != http.StatusNotFound {
+		t.Errorf("guest request: err = %v, want 404", err)
+	}
+}
+
+func TestFallbackToAuth(t *testing.T) {
+	service, pool, fake := newFallbackService(t, doubao.FallbackToAuth, testSession("a", "sessionid=a", false))
+	ctx, trace := doubao.WithTrace(context.Background())
+
+	resp, err := service.ChatCompletion(ctx, model.CompletionRequest{Prompt: "hi", Guest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class, fellBack := trace.ServedClass(); class != "authenticated" || !fellBack {
+		t.Errorf("served class = %s, fallback = %v; want authenticated, true", class, fellBack)
+	}
+	// 登录 Session 可以继续对话，上下文照常返回并绑定，上游会话不会成为孤儿。
+	if resp.ConversationID == "" {
+		t.Fatal("auth fallback dropped conversation_id")
+	}
+	if n := pool.Sessions()[0].Conversations; n != 1 {
+		t.Errorf("auth fallback bound %d conversations, want 1", n)
+	}
+	if _, err := service.ChatCompletion(context.Background(), model.CompletionRequest{Prompt: "again", ConversationID: resp.ConversationID}); err != nil {
+		t.Fatalf("follow-up: %v", err)
+	}
+	chats := chatRequests(t, fake)
+	if len(chats) != 2 || chats[1].Get("device_id") != "device-a" {
+		t.Errorf("follow-up chats = %v, want a second chat on session a", chats)
+	}
+
+	// 该策略只允许游客请求回退，登录请求不会改用游客 Session。
+	service, _, _ = newFallbackService(t, doubao.FallbackToAuth, testSession("g", "ttwid=g", true))
+	if _, err := service.ChatCompletion(context.Background(), model.CompletionRequest{Prompt: "hi"}); statusCode(err) != http.StatusNotFound {
+		t.Errorf("auth request: err = %v, want 404", err)
+	}
+}
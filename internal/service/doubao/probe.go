@@ -0,0 +1,151 @@
+package doubao
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"DoubaoProxy/internal/fingerprint"
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/provider"
+	"DoubaoProxy/internal/session"
+)
+
+// 体检结论。
+const (
+	ProbeOK       = "ok"
+	ProbeAuth     = "auth_expired"
+	ProbeMismatch = "mismatch"
+	ProbeError    = "error"
+)
+
+// ProbeResult 是对单个 Session 的体检结果。
+type ProbeResult struct {
+	SessionID string        `json:"session_id"`
+	Provider  string        `json:"provider"`
+	Guest     bool          `json:"guest"`
+	Status    string        `json:"status"`
+	Latency   time.Duration `json:"latency_ns"`
+	// Mismatch 描述配置的 guest 标记与 Cookie 中登录态不一致的情况。
+	Mismatch string `json:"mismatch,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// probeConcurrency 限制同时体检的 Session 数，避免启动时对上游造成突发流量。
+const probeConcurrency = 4
+
+// Probe 用一次轻量的鉴权调用（prepare_upload）检查 Session，并把结果计入健康状态，
+// 使失效的 Session 在真实流量到来前就被标记。游客没有登录态，上游的鉴权接口总会拒绝，
+// 因此改为打开对话页面，只检查连通性与延迟，不据此判定凭证失效。
+func (s *Service) Probe(ctx context.Context, sess *session.Session) ProbeResult {
+	cred := sess.Snapshot()
+	result := ProbeResult{
+		SessionID: sess.ID,
+		Provider:  sess.ProviderName(),
+		Guest:     cred.Guest,
+		Mismatch:  guestMismatch(cred.Guest, cred.Cookie),
+	}
+
+	prov, err := s.providerFor(sess)
+	if err != nil {
+		result.Status, result.Error = ProbeError, err.Error()
+		return result
+	}
+	out, err := s.sessionEgress(sess)
+	if err != nil {
+		result.Status, result.Error = ProbeError, err.Error()
+		return result
+	}
+	fp := s.profileFor(&cred, prov)
+
+	// 体检同样占用并发名额并遵守节奏限制，不因后台任务突破上游的频率上限。
+	release, err := s.pool.Acquire(ctx, sess)
+	if err != nil {
+		result.Status, result.Error = ProbeError, err.Error()
+		return result
+	}
+	defer release()
+	if err := s.pool.Pace(ctx, sess, session.PaceRequest); err != nil {
+		result.Status, result.Error = ProbeError, err.Error()
+		return result
+	}
+
+	client := s.sessionClient(out.httpClient, sess, prov)
+	start := time.Now()
+	if cred.Guest {
+		err = s.openChatPage(ctx, client, prov, fp)
+	} else {
+		_, err = s.prepareUpload(ctx, client, prov, &cred, fp, 2)
+	}
+	result.Latency = time.Since(start)
+
+	kind, _ := classifyFailure(err)
+	if !cred.Guest || kind != session.FailureAuth {
+		s.reportOutcome(ctx, sess, err)
+	}
+	switch {
+	case err == nil && result.Mismatch != "":
+		result.Status = ProbeMismatch
+	case err == nil:
+		result.Status = ProbeOK
+	case kind == session.FailureAuth && !cred.Guest:
+		result.Status, result.Error = ProbeAuth, err.Error()
+	default:
+		result.Status, result.Error = ProbeError, err.Error()
+	}
+	return result
+}
+
+// openChatPage 携带 Session 的 Cookie 打开对话页面，用作游客 Session 的体检。
+func (s *Service) openChatPage(ctx context.Context, client *http.Client, prov *provider.Provider, fp fingerprint.Profile) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, prov.BaseURL+"/chat/", nil)
+	if err != nil {
+		return fmt.Errorf("create chat page request: %w", err)
+	}
+	fp.SetHeaders(req.Header)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("open chat page: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return model.NewHTTPError(resp.StatusCode, "open chat page: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ProbeAll 并发体检池中的全部 Session，结果顺序与池中顺序一致。
+func (s *Service) ProbeAll(ctx context.Context) []ProbeResult {
+	sessions := s.pool.All()
+	results := make([]ProbeResult, len(sessions))
+	sem := make(chan struct{}, probeConcurrency)
+	var wg sync.WaitGroup
+	for i, sess := range sessions {
+		wg.Add(1)
+		go func(i int, sess *session.Session) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = s.Probe(ctx, sess)
+		}(i, sess)
+	}
+	wg.Wait()
+	return results
+}
+
+// guestMismatch 根据 Cookie 中是否带有登录态检查 guest 标记是否配置正确。
+func guestMismatch(guest bool, cookie string) string {
+	loggedIn := session.HasLoginCookie(cookie)
+	switch {
+	case guest && loggedIn:
+		return "configured as guest but cookie carries a login session (sessionid/sid_tt)"
+	case !guest && !loggedIn:
+		return "configured as account but cookie has no sessionid/sid_tt"
+	default:
+		return ""
+	}
+}
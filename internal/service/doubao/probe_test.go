@@ -0,0 +1,104 @@
+package doubao_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"DoubaoProxy/internal/config"
+	"DoubaoProxy/internal/fakedoubao"
+	"DoubaoProxy/internal/service/doubao"
+	"DoubaoProxy/internal/session"
+)
+
+// newTestService 以 sessions 创建连接到假上游的 Service。
+func newTestService(t *testing.T, sessions []session.Session, opts session.Options) (*doubao.Service, *session.Pool, *fakedoubao.Server) {
+	t.Helper()
+	fake := fakedoubao.New()
+	t.Cleanup(fake.Close)
+	service, pool := startService(t, sessions, opts, fake.Apply)
+	return service, pool, fake
+}
+
+// startService 以 sessions 创建 Service，apply 把上游地址指向替身。
+func startService(t *testing.T, sessions []session.Session, opts session.Options, apply func(config.Config) config.Config) (*doubao.Service, *session.Pool) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "session.json")
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := session.NewPool(path, opts)
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	cfg = apply(cfg)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	service, err := doubao.NewService(pool, nil, cfg, logger)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return service, pool
+}
+
+func testSession(id, cookie string, guest bool) session.Session {
+	return session.Session{
+		ID:         id,
+		Cookie:     cookie,
+		DeviceID:   "device-" + id,
+		TeaUUID:    "tea-" + id,
+		WebID:      "web-" + id,
+		RoomID:     "room-" + id,
+		XFlowTrace: "trace-" + id,
+		Guest:      guest,
+	}
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name      string
+		sess      session.Session
+		status    string
+		state     session.State
+		wantPath  string
+		avoidPath string
+	}{
+		{"account", testSession("a", "sessionid=a", false), doubao.ProbeOK, session.StateHealthy, "/alice/resource/prepare_upload", "/chat/"},
+		{"expired account", testSession("a", "ttwid=a", false), doubao.ProbeAuth, session.StateDead, "/alice/resource/prepare_upload", "/chat/"},
+		// 游客没有登录态，鉴权接口必然拒绝；体检改用对话页面，不会把游客判为失效。
+		{"guest", testSession("g", "ttwid=g", true), doubao.ProbeOK, session.StateHealthy, "/chat/", "/alice/resource/prepare_upload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, pool, fake := newTestService(t, []session.Session{tt.sess}, session.Options{})
+			sess, _ := pool.Session(tt.sess.ID)
+
+			result := service.Probe(context.Background(), sess)
+			if result.Status != tt.status {
+				t.Fatalf("status = %s (%s), want %s", result.Status, result.Error, tt.status)
+			}
+			if state := pool.Sessions()[0].State; state != tt.state {
+				t.Errorf("state = %s, want %s", state, tt.state)
+			}
+			var paths []string
+			for _, r := range fake.Requests() {
+				paths = append(paths, r.Path)
+			}
+			if !slices.Contains(paths, tt.wantPath) || slices.Contains(paths, tt.avoidPath) {
+				t.Errorf("upstream paths = %v", paths)
+			}
+		})
+	}
+}
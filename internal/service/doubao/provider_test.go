@@ -0,0 +1,137 @@
+package doubao_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"DoubaoProxy/internal/config"
+	"DoubaoProxy/internal/fakedoubao"
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/provider"
+	"DoubaoProxy/internal/service/doubao"
+	"DoubaoProxy/internal/session"
+)
+
+// newProviderService 创建同时接入两个替身的 Service：一个扮演豆包，一个扮演 Cici。
+func newProviderService(t *testing.T, sessions []session.Session) (*doubao.Service, *session.Pool, *fakedoubao.Server, *fakedoubao.Server) {
+	t.Helper()
+	doubaoFake, ciciFake := fakedoubao.New(), fakedoubao.New()
+	t.Cleanup(doubaoFake.Close)
+	t.Cleanup(ciciFake.Close)
+	service, pool := startService(t, sessions, session.Options{}, func(cfg config.Config) config.Config {
+		return ciciFake.ApplyCici(doubaoFake.Apply(cfg))
+	})
+	return service, pool, doubaoFake, ciciFake
+}
+
+func providerSession(id, prov string, guest bool) session.Session {
+	s := testSession(id, "sessionid="+id, guest)
+	s.Provider = prov
+	return s
+}
+
+// chatRequests 返回替身收到的聊天请求的查询参数。
+func chatRequests(t *testing.T, fake *fakedoubao.Server) []url.Values {
+	t.Helper()
+	var out []url.Values
+	for _, r := range fake.Requests() {
+		if r.Path != "/samantha/chat/completion" {
+			continue
+		}
+		q, err := url.ParseQuery(r.Query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, q)
+	}
+	return out
+}
+
+func TestChatRoutesByProvider(t *testing.T) {
+	service, _, doubaoFake, ciciFake := newProviderService(t, []session.Session{
+		providerSession("d", provider.Doubao, false),
+		providerSession("c", provider.Cici, false),
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		if _, err := service.ChatCompletion(ctx, model.CompletionRequest{Prompt: "hi", Provider: provider.Cici}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := service.ChatCompletion(ctx, model.CompletionRequest{Prompt: "hi", Provider: provider.Doubao})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cici, dou := chatRequests(t, ciciFake), chatRequests(t, doubaoFake)
+	if len(cici) != 5 || len(dou) != 1 {
+		t.Fatalf("cici got %d chats, doubao got %d; want 5 and 1", len(cici), len(dou))
+	}
+	for _, q := range cici {
+		if q.Get("device_id") != "device-c" || q.Get("aid") != "495671" || q.Get("region") != "SG" {
+			t.Errorf("cici chat query = %v", q)
+		}
+	}
+	if q := dou[0]; q.Get("device_id") != "device-d" || q.Get("aid") == "495671" {
+		t.Errorf("doubao chat query = %v", q)
+	}
+
+	// 已有会话不带 provider 时仍由绑定的后端继续。
+	if _, err := service.ChatCompletion(ctx, model.CompletionRequest{Prompt: "again", ConversationID: resp.ConversationID}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(chatRequests(t, doubaoFake)); n != 2 {
+		t.Errorf("follow-up went to the wrong backend: doubao chats = %d", n)
+	}
+}
+
+func TestUploadRoutesByProvider(t *testing.T) {
+	service, _, doubaoFake, ciciFake := newProviderService(t, []session.Session{
+		providerSession("d", provider.Doubao, false),
+		providerSession("c", provider.Cici, false),
+	})
+
+	if _, err := service.UploadFile(context.Background(), provider.Cici, 2, "pic.png", []byte("png-bytes")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(doubaoFake.Requests()); n != 0 {
+		t.Errorf("doubao stand-in got %d requests for a cici upload", n)
+	}
+	signed := false
+	for _, r := range ciciFake.Requests() {
+		if strings.Contains(r.Query, "Action=ApplyImageUpload") {
+			signed = strings.Contains(r.Header.Get("Authorization"), "/ap-singapore-1/")
+		}
+	}
+	if !signed {
+		t.Error("cici upload not signed for the ap-singapore-1 region")
+	}
+}
+
+func TestCiciGuestLimitMarker(t *testing.T) {
+	service, pool, _, ciciFake := newProviderService(t, []session.Session{
+		providerSession("c", provider.Cici, true),
+	})
+	ciciFake.SetBehavior(fakedoubao.Behavior{TouristLimited: true, LimitMessage: "guest conversation reach limited"})
+
+	_, err := service.ChatCompletion(context.Background(), model.CompletionRequest{Prompt: "hi", Guest: true, Provider: provider.Cici})
+	if status := statusCode(err); status != http.StatusTooManyRequests {
+		t.Fatalf("err = %v, want 429", err)
+	}
+	if state := pool.Sessions()[0].State; state != session.StateCooling {
+		t.Errorf("state = %s, want cooling", state)
+	}
+}
+
+func statusCode(err error) int {
+	var httpErr *model.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode()
+	}
+	return 0
+}
@@ -0,0 +1,250 @@
+package doubao
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"DoubaoProxy/internal/fingerprint"
+	"DoubaoProxy/internal/provider"
+	"DoubaoProxy/internal/session"
+)
+
+// ProvisionedTag 标记由游客补充器自动申请的 Session，只有带此标签的 Session 会被自动淘汰。
+const ProvisionedTag = "provisioned"
+
+// provisionPerRound 限制每轮检查最多申请的游客 Session 数，缺口较大时分多轮补足，避免注册接口收到突发请求。
+const provisionPerRound = 2
+
+// GuestPolicy 控制游客 Session 的自动补充。
+type GuestPolicy struct {
+	// Min 为需要保持的可用游客 Session 数量，0 表示关闭。
+	Min int
+	// Provider 为申请游客 Session 的后端。
+	Provider string
+	// Interval 为检查间隔。
+	Interval time.Duration
+}
+
+// ProvisionGuest 通过上游的设备注册流程申请一组新的匿名标识，并作为游客 Session 加入池中：
+// 先向注册接口申请设备标识，再以该身份打开新对话页面领取游客 Cookie。
+func (s *Service) ProvisionGuest(ctx context.Context, providerName string) (*session.Session, error) {
+	prov, err := s.providers.Get(providerName)
+	if err != nil {
+		return nil, err
+	}
+	fp := prov.Fingerprint.Merge(s.cfg.Fingerprint)
+
+	// 申请流程不属于任何 Session，只受全局频率上限约束。
+	if err := s.pool.PaceGlobal(ctx); err != nil {
+		return nil, err
+	}
+	ids, err := s.registerDevice(ctx, prov, fp)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.pool.PaceGlobal(ctx); err != nil {
+		return nil, err
+	}
+	cookie, roomID, err := s.guestCookie(ctx, prov, fp)
+	if err != nil {
+		return nil, err
+	}
+	if roomID == "" {
+		// 上游未分配会话页时以 web_id 占位，room_id 只用于拼接 Referer。
+		roomID = ids.WebID
+	}
+
+	return s.pool.AddSession(session.Session{
+		Cookie:     cookie,
+		DeviceID:   ids.DeviceID,
+		TeaUUID:    ids.TeaUUID,
+		WebID:      ids.WebID,
+		RoomID:     roomID,
+		XFlowTrace: newFlowTrace(),
+		Guest:      true,
+		Provider:   prov.Name,
+		Tags:       []string{ProvisionedTag},
+	})
+}
+
+// deviceIDs 是设备注册接口分配的一组匿名标识。
+type deviceIDs struct {
+	DeviceID string `json:"device_id"`
+	TeaUUID  string `json:"tea_uuid"`
+	WebID    string `json:"web_id"`
+}
+
+// registerDevice 调用设备注册接口申请新的设备标识。响应未给出 device_id 或 tea_uuid 时沿用 web_id，与网页端一致。
+func (s *Service) registerDevice(ctx context.Context, prov *provider.Provider, fp fingerprint.Profile) (deviceIDs, error) {
+	appID, err := strconv.Atoi(fp.AID)
+	if err != nil {
+		return deviceIDs{}, fmt.Errorf("register device: invalid aid %q", fp.AID)
+	}
+	payload, err := json.Marshal(map[string]any{
+		"app_id":         appID,
+		"url":            prov.BaseURL + "/chat/",
+		"user_agent":     fp.UserAgent,
+		"referer":        prov.BaseURL + "/",
+		"user_unique_id": "",
+	})
+	if err != nil {
+		return deviceIDs{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, prov.RegisterURL, bytes.NewReader(payload))
+	if err != nil {
+		return deviceIDs{}, fmt.Errorf("create register request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	fp.SetHeaders(req.Header)
+
+	out, err := s.egressFor("")
+	if err != nil {
+		return deviceIDs{}, err
+	}
+	resp, err := out.httpClient.Do(req)
+	if err != nil {
+		return deviceIDs{}, fmt.Errorf("call device register: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return deviceIDs{}, fmt.Errorf("device register failed: status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		deviceIDs
+		E       int    `json:"e"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return deviceIDs{}, fmt.Errorf("decode device register response: %w", err)
+	}
+	if result.E != 0 || result.WebID == "" {
+		return deviceIDs{}, fmt.Errorf("device register rejected: e=%d %s", result.E, result.Message)
+	}
+	ids := result.deviceIDs
+	if ids.DeviceID == "" {
+		ids.DeviceID = ids.WebID
+	}
+	if ids.TeaUUID == "" {
+		ids.TeaUUID = ids.WebID
+	}
+	return ids, nil
+}
+
+// guestCookie 打开新对话页面，收集上游下发的游客 Cookie，并从最终地址中取出会话页的 room_id。
+func (s *Service) guestCookie(ctx context.Context, prov *provider.Provider, fp fingerprint.Profile) (cookie, roomID string, err error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return "", "", err
+	}
+	out, err := s.egressFor("")
+	if err != nil {
+		return "", "", err
+	}
+	client := &http.Client{Timeout: out.httpClient.Timeout, Transport: out.httpClient.Transport, Jar: jar}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, prov.BaseURL+"/chat/", nil)
+	if err != nil {
+		return "", "", fmt.Errorf("create chat page request: %w", err)
+	}
+	fp.SetHeaders(req.Header)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("open chat page: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("open chat page: status %d", resp.StatusCode)
+	}
+
+	cookies := jar.Cookies(resp.Request.URL)
+	if len(cookies) == 0 {
+		return "", "", errors.New("chat page set no guest cookies")
+	}
+	parts := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		parts = append(parts, c.Name+"="+c.Value)
+	}
+	roomID, _ = strings.CutPrefix(resp.Request.URL.Path, "/chat/")
+	return strings.Join(parts, "; "), strings.Trim(roomID, "/"), nil
+}
+
+// newFlowTrace 生成 04-<trace id>-<span id>-01 形式的 x-flow-trace。
+func newFlowTrace() string {
+	buf := make([]byte, 24)
+	_, _ = rand.Read(buf)
+	return "04-" + hex.EncodeToString(buf[:16]) + "-" + hex.EncodeToString(buf[16:]) + "-01"
+}
+
+// ReplenishGuests 淘汰已失效的自动申请游客 Session，再申请新的游客 Session，使指定后端的可用游客 Session
+// 不少于 min 个。冷却中的 Session 稍后会自行恢复，不被淘汰，也不计入可用数量；每轮最多申请 provisionPerRound 个，
+// 其余留待下一轮。手工配置的 Session 不受影响。
+func (s *Service) ReplenishGuests(ctx context.Context, providerName string, min int) (added, retired int, err error) {
+	prov, err := s.providers.Get(providerName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	available := 0
+	for _, info := range s.pool.Sessions() {
+		if !info.Guest || info.Provider != prov.Name {
+			continue
+		}
+		usable := info.Enabled && !info.Draining && (info.State == session.StateHealthy || info.State == session.StateSuspect)
+		if usable {
+			available++
+			continue
+		}
+		if info.State == session.StateDead && slices.Contains(info.Tags, ProvisionedTag) {
+			if err := s.pool.DeleteSession(info.ID); err == nil {
+				s.logger.Info("retired provisioned guest session", "session", info.ID, "state", info.State, "reason", info.Reason)
+				retired++
+			}
+		}
+	}
+
+	for ; available+added < min && added < provisionPerRound; added++ {
+		sess, err := s.ProvisionGuest(ctx, prov.Name)
+		if err != nil {
+			return added, retired, err
+		}
+		s.logger.Info("provisioned guest session", "session", sess.ID, "provider", prov.Name)
+	}
+	return added, retired, nil
+}
+
+// RunGuestProvisioner 立即补充一次游客 Session，之后每隔 policy.Interval 检查一次，直到 ctx 结束。
+func (s *Service) RunGuestProvisioner(ctx context.Context, policy GuestPolicy) {
+	replenish := func() {
+		if _, _, err := s.ReplenishGuests(ctx, policy.Provider, policy.Min); err != nil && ctx.Err() == nil {
+			s.logger.Warn("guest provisioning failed", "provider", policy.Provider, "error", err)
+		}
+	}
+	replenish()
+
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			replenish()
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package doubao_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"DoubaoProxy/internal/service/doubao"
+	"DoubaoProxy/internal/session"
+)
+
+// provisioned 返回池中自动申请的游客 Session。
+func provisioned(pool *session.Pool) []session.Info {
+	var out []session.Info
+	for _, info := range pool.Sessions() {
+		if slices.Contains(info.Tags, doubao.ProvisionedTag) {
+			out = append(out, info)
+		}
+	}
+	return out
+}
+
+func TestProvisionGuestUsesRegisteredIdentifiers(t *testing.T) {
+	service, pool, _ := newTestService(t, []session.Session{testSession("a", "sessionid=a", false)}, session.Options{})
+
+	sess, err := service.ProvisionGuest(context.Background(), "doubao")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := sess.Snapshot()
+	if !cred.Guest || cred.WebID == "" || cred.DeviceID == cred.WebID || cred.TeaUUID == cred.WebID {
+		t.Errorf("provisioned identifiers = device %q, tea %q, web %q", cred.DeviceID, cred.TeaUUID, cred.WebID)
+	}
+	if _, err := pool.Session(sess.ID); err != nil {
+		t.Errorf("provisioned session not in pool: %v", err)
+	}
+}
+
+func TestReplenishGuestsSpreadsBurstAcrossRounds(t *testing.T) {
+	service, pool, _ := newTestService(t, []session.Session{testSession("a", "sessionid=a", false)}, session.Options{})
+	ctx := context.Background()
+
+	added, _, err := service.ReplenishGuests(ctx, "doubao", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Fatalf("first round added %d, want 2", added)
+	}
+	if added, _, err = service.ReplenishGuests(ctx, "doubao", 3); err != nil || added != 1 {
+		t.Fatalf("second round added %d, %v; want 1", added, err)
+	}
+	if n := len(provisioned(pool)); n != 3 {
+		t.Errorf("provisioned = %d, want 3", n)
+	}
+}
+
+func TestReplenishGuestsRetiresOnlyDeadSessions(t *testing.T) {
+	service, pool, _ := newTestService(t, []session.Session{testSession("a", "sessionid=a", false)}, session.Options{})
+	ctx := context.Background()
+	if _, _, err := service.ReplenishGuests(ctx, "doubao", 2); err != nil {
+		t.Fatal(err)
+	}
+	guests := provisioned(pool)
+	dead, _ := pool.Session(guests[0].ID)
+	cooling, _ := pool.Session(guests[1].ID)
+	pool.ReportFailure(dead, session.FailureAuth, "expired")
+	pool.ReportFailure(cooling, session.FailureRateLimited, "tourist conversation reach limited")
+
+	added, retired, err := service.ReplenishGuests(ctx, "doubao", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retired != 1 || added != 2 {
+		t.Fatalf("added %d, retired %d; want 2, 1", added, retired)
+	}
+	if _, err := pool.Session(dead.ID); err == nil {
+		t.Error("dead session not retired")
+	}
+	if _, err := pool.Session(cooling.ID); err != nil {
+		t.Errorf("cooling session retired: %v", err)
+	}
+}
+
+func TestProvisionedGuestSurvivesReload(t *testing.T) {
+	service, pool, _ := newTestService(t, []session.Session{testSession("a", "sessionid=a", false)}, session.Options{})
+	sess, err := service.ProvisionGuest(context.Background(), "doubao")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.BindConversation("conv-1", sess)
+
+	result, err := pool.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(result.Removed, sess.ID) {
+		t.Errorf("reload removed provisioned guest: %+v", result)
+	}
+	if got, err := pool.Session(sess.ID); err != nil || got != sess {
+		t.Fatalf("provisioned guest after reload = %v, %v", got, err)
+	}
+	got, err := pool.GetSession(session.Criteria{ConversationID: "conv-1", Guest: true})
+	if err != nil || got != sess {
+		t.Errorf("bound session after reload = %v, %v; want %s", got, err, sess.ID)
+	}
+}
@@ -0,0 +1,107 @@
+package doubao
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"DoubaoProxy/internal/cassette"
+	"DoubaoProxy/internal/fingerprint"
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/provider"
+	"DoubaoProxy/internal/session"
+)
+
+// providerFor 返回 Session 所属的上游后端定义。
+func (s *Service) providerFor(sess *session.Session) (*provider.Provider, error) {
+	prov, err := s.providers.Get(sess.ProviderName())
+	if err != nil {
+		return nil, model.NewHTTPError(http.StatusInternalServerError, "%s", err.Error())
+	}
+	return prov, nil
+}
+
+// profileFor 返回 Session 实际生效的客户端指纹：后端默认值、全局配置、Session 覆盖依次叠加。
+func (s *Service) profileFor(sess *session.Session, prov *provider.Provider) fingerprint.Profile {
+	return sess.Profile(prov.Fingerprint.Merge(s.cfg.Fingerprint))
+}
+
+// sessionClient 返回以 base 的传输层与超时发起请求、并使用 Session 专属 Cookie Jar 的客户端，
+// 上游下发的 Set-Cookie 随之写回 Session。
+func (s *Service) sessionClient(base *http.Client, sess *session.Session, prov *provider.Provider) *http.Client {
+	return &http.Client{Timeout: base.Timeout, Transport: base.Transport, Jar: s.pool.CookieJar(sess, prov.BaseURL)}
+}
+
+// webQuery 构造网页接口通用的查询参数，聊天、删除与上传准备共用同一份指纹。
+func webQuery(sess *session.Session, fp fingerprint.Profile) url.Values {
+	values := url.Values{}
+	fp.SetQuery(values)
+	values.Set("device_id", sess.DeviceID)
+	values.Set("device_platform", "web")
+	values.Set("pkg_type", "release_version")
+	values.Set("samantha_web", "1")
+	values.Set("tea_uuid", sess.TeaUUID)
+	values.Set("use-olympus-account", "1")
+	values.Set("web_id", sess.WebID)
+	return values
+}
+
+// reportOutcome 根据一次上游调用的结果推进 Session 的健康状态。
+// 客户端主动取消的请求不计入 Session 的失败。
+func (s *Service) reportOutcome(ctx context.Context, sess *session.Session, err error) {
+	if err == nil {
+		s.pool.ReportSuccess(sess)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if kind, ok := classifyFailure(err); ok {
+		s.pool.ReportFailure(sess, kind, err.Error())
+	}
+}
+
+// retryable 报告失败是否由 Session 引起、值得换用其他 Session 重试（包括排队超时的 503）。
+// 客户端取消或请求本身的错误不重试。
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	_, ok := classifyFailure(err)
+	return ok
+}
+
+// classifyFailure 将上游错误归类：401/403 视为凭证失效，429 视为限流，
+// 5xx 与网络、超时错误视为瞬时故障，其余 4xx 属于请求本身的问题，不影响 Session 状态。
+func classifyFailure(err error) (session.FailureKind, bool) {
+	var httpErr *model.HTTPError
+	if !errors.As(err, &httpErr) {
+		if networkFailure(err) {
+			return session.FailureTransient, true
+		}
+		return 0, false
+	}
+	switch status := httpErr.StatusCode(); {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return session.FailureAuth, true
+	case status == http.StatusTooManyRequests:
+		return session.FailureRateLimited, true
+	case status >= http.StatusInternalServerError:
+		return session.FailureTransient, true
+	default:
+		return 0, false
+	}
+}
+
+// networkFailure 报告 err 是否为与上游通信时的网络或超时错误。
+// 客户端取消、回放时卡带缺少记录以及本地构造请求的错误与 Session 无关。
+func networkFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, cassette.ErrNoInteraction) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,50 @@
+package doubao
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"DoubaoProxy/internal/cassette"
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/session"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	callErr := func(err error) error {
+		return fmt.Errorf("call chat completion: %w", &url.Error{Op: "Post", URL: "https://example.invalid", Err: err})
+	}
+	_, marshalErr := json.Marshal(func() {})
+
+	tests := []struct {
+		name string
+		err  error
+		kind session.FailureKind
+		ok   bool
+	}{
+		{"unauthorized", model.NewHTTPError(http.StatusUnauthorized, "x"), session.FailureAuth, true},
+		{"forbidden", model.NewHTTPError(http.StatusForbidden, "x"), session.FailureAuth, true},
+		{"rate limited", model.NewHTTPError(http.StatusTooManyRequests, "x"), session.FailureRateLimited, true},
+		{"bad gateway", model.NewHTTPError(http.StatusBadGateway, "x"), session.FailureTransient, true},
+		{"bad request", model.NewHTTPError(http.StatusBadRequest, "x"), 0, false},
+		{"connection refused", callErr(&net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}), session.FailureTransient, true},
+		{"timeout", callErr(context.DeadlineExceeded), session.FailureTransient, true},
+		{"truncated body", fmt.Errorf("read stream: %w", io.ErrUnexpectedEOF), session.FailureTransient, true},
+		{"canceled", callErr(context.Canceled), 0, false},
+		{"no cassette interaction", callErr(fmt.Errorf("%w: POST /x", cassette.ErrNoInteraction)), 0, false},
+		{"marshal", fmt.Errorf("marshal payload: %w", marshalErr), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, ok := classifyFailure(tt.err)
+			if kind != tt.kind || ok != tt.ok {
+				t.Errorf("classifyFailure = %v, %v; want %v, %v", kind, ok, tt.kind, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package doubao
+
+import "context"
+
+// Route 是接口层附加在请求上的 Session 路由约束，对聊天与上传同样生效。
+type Route struct {
+	// Tags 要求新会话使用的 Session 带有其中全部标签。
+	Tags []string
+	// Pin 指定新会话使用的 Session ID，仅供管理员排查问题使用，不做故障转移。
+	Pin string
+}
+
+type routeKey struct{}
+
+// WithRoute 返回携带路由约束的 ctx。
+func WithRoute(ctx context.Context, r Route) context.Context {
+	return context.WithValue(ctx, routeKey{}, r)
+}
+
+func routeFrom(ctx context.Context) Route {
+	r, _ := ctx.Value(routeKey{}).(Route)
+	return r
+}
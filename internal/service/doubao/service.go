@@ -1,36 +1,86 @@
 package doubao
 
 import (
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"sync"
 
+	"DoubaoProxy/internal/cassette"
 	"DoubaoProxy/internal/config"
+	"DoubaoProxy/internal/provider"
 	"DoubaoProxy/internal/session"
+	"DoubaoProxy/internal/store"
 )
 
 // Service 封装与豆包交互的核心业务逻辑，对应原 Python 实现。
 type Service struct {
-	pool            *session.Pool
-	cfg             config.Config
-	httpClient      *http.Client
-	streamingClient *http.Client
-	logger          *slog.Logger
+	pool      *session.Pool
+	store     store.Store
+	cfg       config.Config
+	providers provider.Registry
+	logger    *slog.Logger
+	fallback  FallbackPolicy
+
+	// egresses 按出站代理缓存客户端，键为代理地址（空串表示默认出口）。
+	egressMu sync.Mutex
+	egresses map[string]*egress
+	// cassette 非空时表示启用了上游卡带，全部请求都经由它。
+	cassette *egress
+	// recorder 在录制模式下为卡带录制器，Close 时写入卡带文件。
+	recorder io.Closer
 }
 
-// NewService 创建 Service 实例，并配置合适的 HTTP 客户端。
-func NewService(pool *session.Pool, cfg config.Config, logger *slog.Logger) *Service {
+// NewService 创建 Service 实例。HTTP 客户端按出站代理在首次使用时创建。
+// 若配置了上游卡带，所有上游请求都会经过录制或回放传输层。
+func NewService(pool *session.Pool, st store.Store, cfg config.Config, logger *slog.Logger) (*Service, error) {
 	if logger == nil {
 		logger = slog.Default()
 	}
-	timeout := cfg.HTTPClientTimeout
-	stdClient := &http.Client{Timeout: timeout}
-	streamClient := &http.Client{Timeout: timeout}
+	if st == nil {
+		st = store.NewMemory()
+	}
+
+	if _, err := session.ParseProxy(cfg.UpstreamProxy); err != nil {
+		return nil, fmt.Errorf("invalid UPSTREAM_PROXY: %w", err)
+	}
+	fallback, err := ParseFallbackPolicy(cfg.SessionFallback)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{
+		pool:      pool,
+		store:     st,
+		cfg:       cfg,
+		providers: provider.FromConfig(cfg),
+		logger:    logger,
+		fallback:  fallback,
+		egresses:  make(map[string]*egress),
+	}
+	if cfg.CassetteMode == "" {
+		return s, nil
+	}
+
+	transport, err := cassette.Wrap(cassette.Mode(cfg.CassetteMode), cfg.CassettePath, http.DefaultTransport, func(err error) {
+		logger.Error("failed to write upstream cassette", "path", cfg.CassettePath, "error", err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("configure upstream cassette: %w", err)
+	}
+	logger.Info("upstream cassette enabled, outbound proxies are ignored", "mode", cfg.CassetteMode, "path", cfg.CassettePath)
+	s.cassette = s.newEgress(transport)
+	if c, ok := transport.(io.Closer); ok {
+		s.recorder = c
+	}
+	return s, nil
+}
 
-	return &Service{
-		pool:            pool,
-		cfg:             cfg,
-		httpClient:      stdClient,
-		streamingClient: streamClient,
-		logger:          logger,
+// Close 释放 Service 持有的资源：录制模式下把录到的交互写入卡带文件。
+func (s *Service) Close() error {
+	if s.recorder == nil {
+		return nil
 	}
+	return s.recorder.Close()
 }
@@ -8,222 +8,355 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/provider"
 )
 
-type sseEnvelope struct {
-	EventType int             `json:"event_type"`
-	EventData json.RawMessage `json:"event_data"`
+// sseEvent 是按 WHATWG event-stream 规范分发出的一个事件。
+// Data 指向解码器内部缓冲区，仅在下一次调用 Next 之前有效。
+type sseEvent struct {
+	Type string
+	Data []byte
+	ID   string
 }
 
-func parseSSE(r io.Reader) (text string, images []string, conversationID, messageID, sectionID string, err error) {
-	reader := bufio.NewReader(r)
-	var builder strings.Builder
-	texts := make([]string, 0)
-	images = make([]string, 0)
+// sseDecoder 按 WHATWG 规范解析 text/event-stream：
+// 支持 CR、LF、CRLF 三种换行，多行 data 字段，注释行以及 id 字段。
+// 解码器会复用内部缓冲区，避免每个事件产生额外分配。
+type sseDecoder struct {
+	r      *bufio.Reader
+	line   []byte
+	data   []byte
+	event  []byte
+	lastID string
+	skipLF bool
+	began  bool
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+func newSSEDecoder(r io.Reader) *sseDecoder {
+	return &sseDecoder{r: bufio.NewReaderSize(r, 16<<10)}
+}
+
+// Next 返回下一个完整事件。流结束时返回 io.EOF，未以空行结束的残余事件按规范丢弃。
+func (d *sseDecoder) Next() (sseEvent, error) {
+	d.data = d.data[:0]
+	d.event = d.event[:0]
 
 	for {
-		line, readErr := reader.ReadString('\n')
-		if readErr != nil && !errors.Is(readErr, io.EOF) {
-			return "", nil, "", "", "", fmt.Errorf("read sse: %w", readErr)
+		line, err := d.readLine()
+		if err != nil {
+			return sseEvent{}, err
 		}
 
-		if strings.Contains(line, "tourist conversation reach limited") {
-			return "", nil, "", "", "", model.NewHTTPError(http.StatusTooManyRequests, "tourist session limit reached; please refresh session")
+		if len(line) == 0 {
+			if len(d.data) == 0 {
+				d.event = d.event[:0]
+				continue
+			}
+			return d.dispatch(), nil
 		}
 
-		builder.WriteString(line)
-		if strings.TrimSpace(line) != "" && !errors.Is(readErr, io.EOF) {
-			if readErr != nil {
-				break
-			}
+		if line[0] == ':' {
 			continue
 		}
 
-		block := builder.String()
-		builder.Reset()
-		if strings.TrimSpace(block) == "" {
-			if errors.Is(readErr, io.EOF) {
-				break
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			if len(value) > 0 && value[0] == ' ' {
+				value = value[1:]
 			}
-			if readErr != nil {
-				break
+		}
+
+		switch string(field) {
+		case "event":
+			d.event = append(d.event[:0], value...)
+		case "data":
+			d.data = append(d.data, value...)
+			d.data = append(d.data, '\n')
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				d.lastID = string(value)
 			}
-			continue
+		case "retry":
+			// 重连间隔仅对浏览器 EventSource 有意义，这里忽略。
 		}
+	}
+}
 
-		eventName, dataLine := parseEventBlock(block)
-		if eventName == "gateway-error" {
-			if msg := dataLine; msg != "" {
-				return "", nil, "", "", "", model.NewHTTPError(http.StatusBadGateway, msg)
+func (d *sseDecoder) dispatch() sseEvent {
+	ev := sseEvent{Data: d.data[:len(d.data)-1], ID: d.lastID}
+	switch string(d.event) {
+	case "", "message":
+		ev.Type = "message"
+	default:
+		ev.Type = string(d.event)
+	}
+	return ev
+}
+
+// readLine 读取一行（不含换行符）。在缓冲区内即可找到换行时直接返回缓冲区切片，
+// 该切片仅在下一次读取前有效。
+func (d *sseDecoder) readLine() ([]byte, error) {
+	d.line = d.line[:0]
+	for {
+		if d.r.Buffered() == 0 {
+			if _, err := d.r.Peek(1); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil, io.EOF
+				}
+				return nil, fmt.Errorf("read sse: %w", err)
 			}
-			return "", nil, "", "", "", model.NewHTTPError(http.StatusBadGateway, "doubao gateway error")
 		}
+		buf, _ := d.r.Peek(d.r.Buffered())
 
-		if dataLine == "" {
-			if errors.Is(readErr, io.EOF) {
-				break
+		if d.skipLF {
+			d.skipLF = false
+			if buf[0] == '\n' {
+				_, _ = d.r.Discard(1)
+				continue
 			}
-			if readErr != nil {
-				break
+		}
+		if !d.began {
+			// BOM 可能被拆在多次短读中，凑齐三个字节再判断。
+			if len(buf) < len(utf8BOM) && bytes.HasPrefix(utf8BOM, buf) {
+				buf, _ = d.r.Peek(len(utf8BOM))
 			}
+			d.began = true
+			if bytes.HasPrefix(buf, utf8BOM) {
+				_, _ = d.r.Discard(len(utf8BOM))
+				continue
+			}
+		}
+
+		i := bytes.IndexAny(buf, "\r\n")
+		if i < 0 {
+			d.line = append(d.line, buf...)
+			_, _ = d.r.Discard(len(buf))
 			continue
 		}
 
-		var envelope sseEnvelope
-		if err := json.Unmarshal([]byte(dataLine), &envelope); err != nil {
-			if errors.Is(readErr, io.EOF) {
+		d.skipLF = buf[i] == '\r'
+		var line []byte
+		if len(d.line) == 0 {
+			line = buf[:i]
+		} else {
+			d.line = append(d.line, buf[:i]...)
+			line = d.line
+		}
+		_, _ = d.r.Discard(i + 1)
+		return line, nil
+	}
+}
+
+type sseEnvelope struct {
+	EventType int             `json:"event_type"`
+	EventData json.RawMessage `json:"event_data"`
+}
+
+// sseEventData 是 event_data 中我们关心的字段，按事件类型按需填充。
+type sseEventData struct {
+	Message        *sseMessage `json:"message"`
+	ConversationID flexString  `json:"conversation_id"`
+	MessageID      flexString  `json:"message_id"`
+	SectionID      flexString  `json:"section_id"`
+}
+
+type sseMessage struct {
+	ContentType int `json:"content_type"`
+	// Content 通常是再编码成字符串的 JSON，偶尔直接下发对象，统一由 messageContent 展开。
+	Content json.RawMessage `json:"content"`
+}
+
+// flexString 兼容上游偶尔以数字形式下发的 ID 字段。
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) error {
+	if len(b) == 0 || string(b) == "null" {
+		return nil
+	}
+	if b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*f = flexString(s)
+		return nil
+	}
+	if _, err := strconv.ParseFloat(string(b), 64); err != nil {
+		return fmt.Errorf("flexString: unexpected value %s", b)
+	}
+	*f = flexString(b)
+	return nil
+}
+
+// parseSSE 按后端的事件约定解析聊天 SSE 流。
+func parseSSE(r io.Reader, spec provider.EventSpec) (text string, images []string, conversationID, messageID, sectionID string, err error) {
+	dec := newSSEDecoder(r)
+	markers := make([][]byte, len(spec.LimitMarkers))
+	for i, m := range spec.LimitMarkers {
+		markers[i] = []byte(m)
+	}
+	var (
+		texts   strings.Builder
+		hasText bool
+		scratch []byte
+	)
+	images = make([]string, 0)
+
+	for {
+		ev, nextErr := dec.Next()
+		if nextErr != nil {
+			if errors.Is(nextErr, io.EOF) {
 				break
 			}
+			return "", nil, "", "", "", nextErr
+		}
+
+		if containsAny(ev.Data, markers) {
+			return "", nil, "", "", "", model.NewHTTPError(http.StatusTooManyRequests, "tourist session limit reached; please refresh session")
+		}
+
+		if ev.Type == "gateway-error" {
+			if msg := strings.TrimSpace(string(ev.Data)); msg != "" {
+				return "", nil, "", "", "", model.NewHTTPError(http.StatusBadGateway, msg)
+			}
+			return "", nil, "", "", "", model.NewHTTPError(http.StatusBadGateway, "doubao gateway error")
+		}
+
+		if len(bytes.TrimSpace(ev.Data)) == 0 {
 			continue
 		}
 
-		data, err := decodeEventData(envelope.EventData)
-		if err != nil {
+		var envelope sseEnvelope
+		if err := json.Unmarshal(ev.Data, &envelope); err != nil {
+			continue
+		}
+
+		var (
+			data      sseEventData
+			decodeErr error
+		)
+		scratch, decodeErr = decodeEventData(envelope.EventData, scratch, &data)
+		if decodeErr != nil {
 			continue
 		}
 
 		switch envelope.EventType {
-		case 2001:
-			messageMap, _ := data["message"].(map[string]any)
-			if len(messageMap) == 0 {
+		case spec.Message:
+			if data.Message == nil {
 				continue
 			}
-			contentType := getInt(messageMap["content_type"])
-			switch contentType {
-			case 10000, 2001, 2008:
-				if txt := extractText(messageMap["content"]); txt != "" {
-					texts = append(texts, txt)
+			switch contentType := data.Message.ContentType; {
+			case spec.IsText(contentType):
+				if txt := extractText(data.Message.Content); txt != "" {
+					texts.WriteString(txt)
+					hasText = true
 				}
-			case 2074:
-				images = appendUnique(images, extractImages(messageMap["content"])...)
-			default:
-				continue
+			case spec.IsImage(contentType):
+				images = appendUnique(images, extractImages(data.Message.Content)...)
 			}
-		case 2002:
-			if v := getString(data["conversation_id"], ""); v != "" {
-				conversationID = v
+		case spec.Meta:
+			if data.ConversationID != "" {
+				conversationID = string(data.ConversationID)
 			}
-			if v := getString(data["message_id"], ""); v != "" {
-				messageID = v
+			if data.MessageID != "" {
+				messageID = string(data.MessageID)
 			}
-			if v := getString(data["section_id"], ""); v != "" {
-				sectionID = v
+			if data.SectionID != "" {
+				sectionID = string(data.SectionID)
 			}
-		case 2003:
-			if v := getString(data["conversation_id"], conversationID); v != "" {
-				conversationID = v
+		case spec.End:
+			if data.ConversationID != "" {
+				conversationID = string(data.ConversationID)
 			}
-			if v := getString(data["message_id"], messageID); v != "" {
-				messageID = v
+			if data.MessageID != "" {
+				messageID = string(data.MessageID)
 			}
-			if v := getString(data["section_id"], sectionID); v != "" {
-				sectionID = v
+			if data.SectionID != "" {
+				sectionID = string(data.SectionID)
 			}
-			text = strings.Join(texts, "")
-			return text, images, conversationID, messageID, sectionID, nil
-		}
-
-		if errors.Is(readErr, io.EOF) {
-			break
+			return texts.String(), images, conversationID, messageID, sectionID, nil
 		}
 	}
 
-	if len(texts) == 0 && len(images) == 0 {
+	if !hasText && len(images) == 0 {
 		return "", nil, "", "", "", model.NewHTTPError(http.StatusBadGateway, "empty response from doubao")
 	}
 
-	text = strings.Join(texts, "")
-	return text, images, conversationID, messageID, sectionID, nil
+	return texts.String(), images, conversationID, messageID, sectionID, nil
 }
 
-func parseEventBlock(block string) (eventName string, data string) {
-	lines := strings.Split(block, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		switch {
-		case strings.HasPrefix(line, "event:"):
-			eventName = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
-		case strings.HasPrefix(line, "data:"):
-			data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+func containsAny(data []byte, markers [][]byte) bool {
+	for _, m := range markers {
+		if bytes.Contains(data, m) {
+			return true
 		}
 	}
-	return eventName, data
+	return false
 }
 
-func decodeEventData(raw json.RawMessage) (map[string]any, error) {
-	result := make(map[string]any)
-	if len(raw) == 0 {
-		return result, nil
+// decodeEventData 将 event_data 解码到 dst。上游通常把 JSON 再编码成字符串下发，
+// 此时借助 scratch 缓冲区二次解码，并返回可复用的缓冲区。
+func decodeEventData(raw json.RawMessage, scratch []byte, dst *sseEventData) ([]byte, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return scratch, nil
 	}
 
-	var asString string
-	if err := json.Unmarshal(raw, &asString); err == nil {
-		if strings.TrimSpace(asString) == "" {
-			return result, nil
-		}
-		decoder := json.NewDecoder(strings.NewReader(asString))
-		decoder.UseNumber()
-		if err := decoder.Decode(&result); err != nil {
-			return nil, err
-		}
-		return result, nil
+	if raw[0] != '"' {
+		return scratch, json.Unmarshal(raw, dst)
 	}
 
-	decoder := json.NewDecoder(bytes.NewReader(raw))
-	decoder.UseNumber()
-	if err := decoder.Decode(&result); err != nil {
-		return nil, err
+	var asString string
+	if err := json.Unmarshal(raw, &asString); err != nil {
+		return scratch, err
 	}
-	return result, nil
-}
-
-func getString(value any, fallback string) string {
-	switch v := value.(type) {
-	case string:
-		if v != "" {
-			return v
-		}
-	case json.Number:
-		if v != "" {
-			return v.String()
-		}
+	if strings.TrimSpace(asString) == "" {
+		return scratch, nil
 	}
-	return fallback
+	scratch = append(scratch[:0], asString...)
+	return scratch, json.Unmarshal(scratch, dst)
 }
 
-func getInt(value any) int {
-	switch v := value.(type) {
-	case json.Number:
-		n, _ := v.Int64()
-		return int(n)
-	case float64:
-		return int(v)
-	case int:
-		return v
+// messageContent 返回 content 字段中的 JSON 文档：字符串形式时先解码一层，其余原样返回。
+func messageContent(raw json.RawMessage) []byte {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if raw[0] != '"' {
+		return raw
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil
 	}
-	return 0
+	return []byte(s)
 }
 
-func extractText(value any) string {
-	str, _ := value.(string)
-	if strings.TrimSpace(str) == "" {
+func extractText(raw json.RawMessage) string {
+	content := messageContent(raw)
+	if len(bytes.TrimSpace(content)) == 0 {
 		return ""
 	}
 	var payload struct {
 		Text string `json:"text"`
 	}
-	if err := json.Unmarshal([]byte(str), &payload); err != nil {
+	if err := json.Unmarshal(content, &payload); err != nil {
 		return ""
 	}
 	return payload.Text
 }
 
-func extractImages(value any) []string {
-	str, _ := value.(string)
-	if strings.TrimSpace(str) == "" {
+func extractImages(raw json.RawMessage) []string {
+	content := messageContent(raw)
+	if len(bytes.TrimSpace(content)) == 0 {
 		return nil
 	}
 	var payload struct {
@@ -242,10 +375,10 @@ func extractImages(value any) []string {
 			} `json:"image"`
 		} `json:"creations"`
 	}
-	if err := json.Unmarshal([]byte(str), &payload); err != nil {
+	if err := json.Unmarshal(content, &payload); err != nil {
 		return nil
 	}
-	urls := make([]string, 0)
+	urls := make([]string, 0, len(payload.Creations))
 	for _, creation := range payload.Creations {
 		if creation.Image.Status != 2 {
 			continue
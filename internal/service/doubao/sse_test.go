@@ -0,0 +1,255 @@
+package doubao
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/provider"
+)
+
+var testEvents = provider.EventSpec{
+	Message:           2001,
+	Meta:              2002,
+	End:               2003,
+	TextContentTypes:  []int{10000, 2001, 2008},
+	ImageContentTypes: []int{2074},
+	LimitMarkers:      []string{"tourist conversation reach limited"},
+}
+
+// chunkReader 按给定长度依次返回数据，用于模拟网络上的短读。
+type chunkReader struct {
+	data   string
+	chunks []int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.EOF
+	}
+	n := len(r.data)
+	if len(r.chunks) > 0 {
+		n, r.chunks = min(r.chunks[0], n), r.chunks[1:]
+	}
+	n = copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// statusOf 返回 err 携带的 HTTP 状态码，非 HTTPError 时返回 0。
+func statusOf(err error) int {
+	var httpErr *model.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Status
+	}
+	return 0
+}
+
+type wantEvent struct {
+	typ, data, id string
+}
+
+func decodeAll(t *testing.T, r io.Reader) []wantEvent {
+	t.Helper()
+	dec := newSSEDecoder(r)
+	var got []wantEvent
+	for {
+		ev, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got = append(got, wantEvent{ev.Type, string(ev.Data), ev.ID})
+	}
+}
+
+func TestSSEDecoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		chunks []int
+		want   []wantEvent
+	}{
+		{
+			name:  "lf",
+			input: "data: a\n\ndata: b\n\n",
+			want:  []wantEvent{{"message", "a", ""}, {"message", "b", ""}},
+		},
+		{
+			name:  "cr",
+			input: "data: a\r\rdata: b\r\r",
+			want:  []wantEvent{{"message", "a", ""}, {"message", "b", ""}},
+		},
+		{
+			name:  "crlf",
+			input: "data: a\r\n\r\ndata: b\r\n\r\n",
+			want:  []wantEvent{{"message", "a", ""}, {"message", "b", ""}},
+		},
+		{
+			name:   "crlf split between reads",
+			input:  "data: a\r\n\r\ndata: b\r\n\r\n",
+			chunks: []int{8, 1, 1, 1},
+			want:   []wantEvent{{"message", "a", ""}, {"message", "b", ""}},
+		},
+		{
+			name:  "multi-line data",
+			input: "data: first\ndata:second\ndata\n\n",
+			want:  []wantEvent{{"message", "first\nsecond\n", ""}},
+		},
+		{
+			name:  "comments and unknown fields",
+			input: ": keep-alive\nretry: 100\nfoo: bar\ndata: x\n:\n\n",
+			want:  []wantEvent{{"message", "x", ""}},
+		},
+		{
+			name:  "event type",
+			input: "event: gateway-error\ndata: boom\n\nevent: message\ndata: ok\n\n",
+			want:  []wantEvent{{"gateway-error", "boom", ""}, {"message", "ok", ""}},
+		},
+		{
+			name:  "id persists",
+			input: "id: 1\ndata: a\n\ndata: b\n\nid\ndata: c\n\n",
+			want:  []wantEvent{{"message", "a", "1"}, {"message", "b", "1"}, {"message", "c", ""}},
+		},
+		{
+			name:  "id containing NUL is ignored",
+			input: "id: 1\ndata: a\n\nid: 2\x003\ndata: b\n\n",
+			want:  []wantEvent{{"message", "a", "1"}, {"message", "b", "1"}},
+		},
+		{
+			name:  "event without data is dropped",
+			input: "event: ping\n\ndata: a\n\n",
+			want:  []wantEvent{{"message", "a", ""}},
+		},
+		{
+			name:  "unterminated event is dropped",
+			input: "data: a\n\ndata: b",
+			want:  []wantEvent{{"message", "a", ""}},
+		},
+		{
+			name:  "bom",
+			input: "\uFEFFdata: a\n\n",
+			want:  []wantEvent{{"message", "a", ""}},
+		},
+		{
+			name:   "bom split across short reads",
+			input:  "\uFEFFdata: a\n\n",
+			chunks: []int{2, 1},
+			want:   []wantEvent{{"message", "a", ""}},
+		},
+		{
+			name:   "one byte reads",
+			input:  "\uFEFFid: 7\r\ndata: a\r\ndata: b\r\n\r\n",
+			chunks: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+			want:   []wantEvent{{"message", "a\nb", "7"}},
+		},
+		{
+			name:  "bom only at stream start",
+			input: "data: a\n\n\uFEFFdata: b\n\n",
+			want:  []wantEvent{{"message", "a", ""}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeAll(t, &chunkReader{data: tt.input, chunks: tt.chunks})
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events %q, want %q", len(got), got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("event %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func sseFrame(eventType int, data any) string {
+	inner, _ := json.Marshal(data)
+	outer, _ := json.Marshal(map[string]any{"event_type": eventType, "event_data": string(inner)})
+	return "data: " + string(outer) + "\n\n"
+}
+
+func TestParseSSE(t *testing.T) {
+	textContent, _ := json.Marshal(map[string]string{"text": "hel"})
+	stream := sseFrame(2002, map[string]any{"conversation_id": 42, "section_id": "s1"}) +
+		sseFrame(2001, map[string]any{"message": map[string]any{"content_type": 2001, "content": string(textContent)}}) +
+		sseFrame(2001, map[string]any{"message": map[string]any{"content_type": 2001, "content": map[string]string{"text": "lo"}}}) +
+		sseFrame(2001, map[string]any{"message": map[string]any{"content_type": 2074, "content": map[string]any{
+			"creations": []any{map[string]any{"image": map[string]any{"status": 2, "image_raw": map[string]string{"url": "https://img/1"}}}},
+		}}}) +
+		sseFrame(2003, map[string]any{"message_id": "m1"})
+
+	text, images, conv, msg, section, err := parseSSE(strings.NewReader(stream), testEvents)
+	if err != nil {
+		t.Fatalf("parseSSE: %v", err)
+	}
+	if text != "hello" {
+		t.Errorf("text = %q, want %q", text, "hello")
+	}
+	if len(images) != 1 || images[0] != "https://img/1" {
+		t.Errorf("images = %q", images)
+	}
+	if conv != "42" || msg != "m1" || section != "s1" {
+		t.Errorf("ids = %q %q %q", conv, msg, section)
+	}
+}
+
+func TestParseSSEErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		status int
+	}{
+		{"gateway error", "event: gateway-error\ndata: upstream down\n\n", 502},
+		{"tourist limit", "data: {\"code\":1,\"msg\":\"tourist conversation reach limited\"}\n\n", 429},
+		{"empty", sseFrame(2002, map[string]any{"conversation_id": "c"}), 502},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, _, _, err := parseSSE(strings.NewReader(tt.stream), testEvents)
+			if status := statusOf(err); status != tt.status {
+				t.Fatalf("err = %v (status %d), want status %d", err, status, tt.status)
+			}
+		})
+	}
+}
+
+func FuzzParseSSE(f *testing.F) {
+	f.Add([]byte(sseFrame(2001, map[string]any{"message": map[string]any{"content_type": 2001, "content": `{"text":"hi"}`}})))
+	f.Add([]byte("\uFEFFid: 1\r\ndata: {\"event_type\":2003}\r\r"))
+	f.Add([]byte("event: gateway-error\ndata: x\n\n"))
+	f.Add([]byte(": comment\ndata\ndata: \x00\n\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _, _, _, _, _ = parseSSE(&chunkReader{data: string(data), chunks: []int{1, 3, 7}}, testEvents)
+		for _, ev := range decodeAll(t, strings.NewReader(string(data))) {
+			if strings.IndexByte(ev.id, 0) >= 0 {
+				t.Fatalf("id %q contains NUL", ev.id)
+			}
+		}
+	})
+}
+
+func BenchmarkParseSSE(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString(sseFrame(2002, map[string]any{"conversation_id": "c1", "section_id": "s1"}))
+	for i := 0; i < 200; i++ {
+		content, _ := json.Marshal(map[string]string{"text": "token "})
+		sb.WriteString(sseFrame(2001, map[string]any{"message": map[string]any{"content_type": 2001, "content": string(content)}}))
+	}
+	sb.WriteString(sseFrame(2003, map[string]any{"message_id": "m1"}))
+	stream := sb.String()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(stream)))
+	for i := 0; i < b.N; i++ {
+		if _, _, _, _, _, err := parseSSE(strings.NewReader(stream), testEvents); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package doubao
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Attempt 记录一次使用某个 Session 的上游尝试。
+type Attempt struct {
+	SessionID string `json:"session_id"`
+	// Outcome 为 ok，或失败类别（transient、rate_limited、auth_expired、busy、paced、error）。
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason,omitempty"`
+	// Guest 与 Fallback 仅在成功的尝试上记录：服务的 Session 是否为游客、是否来自类别回退。
+	Guest    bool `json:"guest,omitempty"`
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// Trace 收集一次请求中的全部上游尝试，供 handler 写入响应头。
+type Trace struct {
+	mu       sync.Mutex
+	attempts []Attempt
+}
+
+type traceKey struct{}
+
+// WithTrace 返回携带 Trace 的 ctx。
+func WithTrace(ctx context.Context) (context.Context, *Trace) {
+	t := &Trace{}
+	return context.WithValue(ctx, traceKey{}, t), t
+}
+
+func traceFrom(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+func (t *Trace) add(a Attempt) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.attempts = append(t.attempts, a)
+}
+
+// Attempts 返回已记录的尝试。
+func (t *Trace) Attempts() []Attempt {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Attempt(nil), t.attempts...)
+}
+
+// Served 返回最终成功的 Session ID，没有成功尝试时返回空串。
+func (t *Trace) Served() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, a := range t.attempts {
+		if a.Outcome == outcomeOK {
+			return a.SessionID
+		}
+	}
+	return ""
+}
+
+// ServedClass 返回最终成功的 Session 类别（authenticated 或 guest）以及是否来自类别回退，没有成功尝试时返回空串。
+func (t *Trace) ServedClass() (class string, fallback bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, a := range t.attempts {
+		if a.Outcome == outcomeOK {
+			if a.Guest {
+				return "guest", a.Fallback
+			}
+			return "authenticated", a.Fallback
+		}
+	}
+	return "", false
+}
+
+// Header 将尝试序列化为 "s-1=rate_limited, s-2=ok" 形式，原因不写入响应头。
+func (t *Trace) Header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, 0, len(t.attempts))
+	for _, a := range t.attempts {
+		parts = append(parts, a.SessionID+"="+a.Outcome)
+	}
+	return strings.Join(parts, ", ")
+}
+
+const (
+	outcomeOK    = "ok"
+	outcomeBusy  = "busy"
+	outcomePaced = "paced"
+	outcomeError = "error"
+)
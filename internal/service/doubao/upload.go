@@ -11,7 +11,6 @@ import (
 	"hash/crc32"
 	"io"
 	"net/http"
-	"net/url"
 	"path/filepath"
 	"strings"
 	"time"
@@ -20,12 +19,16 @@ import (
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/google/uuid"
 
+	"DoubaoProxy/internal/fingerprint"
 	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/provider"
 	"DoubaoProxy/internal/session"
+	"DoubaoProxy/internal/store"
 )
 
-// UploadFile 完整复刻 Python 版本的四步上传流程。
-func (s *Service) UploadFile(ctx context.Context, fileType int, fileName string, fileBytes []byte) (*model.UploadResponse, error) {
+// UploadFile 完整复刻 Python 版本的四步上传流程。providerName 为空时不限定后端。
+// 四个步骤都经由所选 Session 的出站代理发出。
+func (s *Service) UploadFile(ctx context.Context, providerName string, fileType int, fileName string, fileBytes []byte) (*model.UploadResponse, error) {
 	if len(fileBytes) == 0 {
 		return nil, model.NewHTTPError(http.StatusBadRequest, "file_bytes body is empty")
 	}
@@ -33,12 +36,43 @@ func (s *Service) UploadFile(ctx context.Context, fileType int, fileName string,
 		return nil, model.NewHTTPError(http.StatusBadRequest, "file_name must include an extension")
 	}
 
-	session, err := s.pool.GetSession("", false)
+	route := routeFrom(ctx)
+	sess, err := s.pool.GetSession(session.Criteria{
+		Provider: providerName,
+		Quota:    session.QuotaUpload,
+		Tags:     route.Tags,
+		Pin:      route.Pin,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	info, err := s.prepareUpload(ctx, session, fileType)
+	prov, err := s.providerFor(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.sessionEgress(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.pool.Acquire(ctx, sess)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// 上传的四步都是独立的上游请求，每一步都按节奏预约。
+	if err := s.pool.Pace(ctx, sess, session.PaceRequest); err != nil {
+		return nil, err
+	}
+
+	cred := sess.Snapshot()
+	fp := s.profileFor(&cred, prov)
+	info, err := s.prepareUpload(ctx, s.sessionClient(out.httpClient, sess, prov), prov, &cred, fp, fileType)
+	// 只有 prepare_upload 携带 Session 凭证，后续三步的失败与 Session 健康无关。
+	s.reportOutcome(ctx, sess, err)
 	if err != nil {
 		return nil, err
 	}
@@ -50,21 +84,50 @@ func (s *Service) UploadFile(ctx context.Context, fileType int, fileName string,
 		Source:          "doubao",
 	}
 
-	apply, err := s.applyUpload(ctx, creds, info.ServiceID, fileName, len(fileBytes))
+	if err := s.pool.Pace(ctx, sess, session.PaceRequest); err != nil {
+		return nil, err
+	}
+	apply, err := s.applyUpload(ctx, out.httpClient, prov, fp, creds, info.ServiceID, fileName, len(fileBytes))
 	if err != nil {
 		return nil, err
 	}
 
-	if err := s.uploadToStore(ctx, apply.StoreURI, apply.StoreAuth, fileBytes); err != nil {
+	if err := s.pool.Pace(ctx, sess, session.PaceRequest); err != nil {
+		return nil, err
+	}
+	if err := s.uploadToStore(ctx, out.httpClient, prov, fp, apply.StoreURI, apply.StoreAuth, fileBytes); err != nil {
 		return nil, err
 	}
 
-	result, err := s.commitUpload(ctx, creds, info.ServiceID, apply.SessionKey)
+	if err := s.pool.Pace(ctx, sess, session.PaceRequest); err != nil {
+		return nil, err
+	}
+	result, err := s.commitUpload(ctx, out.httpClient, prov, fp, creds, info.ServiceID, apply.SessionKey)
 	if err != nil {
 		return nil, err
 	}
 
-	return buildUploadResponse(fileType, fileName, fileBytes, result), nil
+	resp := buildUploadResponse(fileType, fileName, fileBytes, result)
+	s.pool.RecordUpload(sess)
+	s.recordFile(sess, resp)
+	return resp, nil
+}
+
+// recordFile 保存上传结果与所用 Session，失败只记录日志，不影响本次上传。
+func (s *Service) recordFile(session *session.Session, resp *model.UploadResponse) {
+	meta := store.FileMeta{
+		Key:        resp.Key,
+		Name:       resp.Name,
+		Type:       resp.Type,
+		Size:       resp.Size,
+		MD5:        resp.MD5,
+		SessionID:  session.ID,
+		Provider:   session.ProviderName(),
+		UploadedAt: time.Now(),
+	}
+	if err := store.PutJSON(s.store, store.BucketFiles, resp.Key, meta); err != nil {
+		s.logger.Error("save file metadata failed", "key", resp.Key, "error", err)
+	}
 }
 
 type uploadAuth struct {
@@ -131,24 +194,9 @@ type uploadAck struct {
 	Message string `json:"message"`
 }
 
-func (s *Service) prepareUpload(ctx context.Context, sess *session.Session, fileType int) (*prepareInfo, error) {
-	values := url.Values{}
-	values.Set("aid", "497858")
-	values.Set("device_id", sess.DeviceID)
-	values.Set("device_platform", "web")
-	values.Set("language", "zh")
-	values.Set("pc_version", "2.20.0")
-	values.Set("pkg_type", "release_version")
-	values.Set("real_aid", "497858")
-	values.Set("region", "CN")
-	values.Set("samantha_web", "1")
-	values.Set("sys_region", "CN")
-	values.Set("tea_uuid", sess.TeaUUID)
-	values.Set("use-olympus-account", "1")
-	values.Set("version_code", "20800")
-	values.Set("web_id", sess.WebID)
-
-	endpoint := "https://www.doubao.com/alice/resource/prepare_upload?" + values.Encode()
+// prepareUpload 用携带 Session Cookie 的 client 申请上传凭证。
+func (s *Service) prepareUpload(ctx context.Context, client *http.Client, prov *provider.Provider, sess *session.Session, fp fingerprint.Profile, fileType int) (*prepareInfo, error) {
+	endpoint := prov.BaseURL + "/alice/resource/prepare_upload?" + webQuery(sess, fp).Encode()
 	payload := map[string]any{
 		"resource_type": fileType,
 		"scene_id":      "5",
@@ -164,12 +212,11 @@ func (s *Service) prepareUpload(ctx context.Context, sess *session.Session, file
 		return nil, fmt.Errorf("create prepare request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Cookie", sess.Cookie)
-	req.Header.Set("Origin", "https://www.doubao.com")
-	req.Header.Set("Referer", "https://www.doubao.com/chat/")
-	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Origin", prov.BaseURL)
+	req.Header.Set("Referer", prov.BaseURL+"/chat/")
+	fp.SetHeaders(req.Header)
 
-	resp, err := s.httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("call prepare_upload: %w", err)
 	}
@@ -198,27 +245,27 @@ func (s *Service) prepareUpload(ctx context.Context, sess *session.Session, file
 	return info, nil
 }
 
-func (s *Service) applyUpload(ctx context.Context, creds aws.Credentials, serviceID, fileName string, fileSize int) (*applyInfo, error) {
+func (s *Service) applyUpload(ctx context.Context, client *http.Client, prov *provider.Provider, fp fingerprint.Profile, creds aws.Credentials, serviceID, fileName string, fileSize int) (*applyInfo, error) {
 	ext := filepath.Ext(fileName)
 	if ext == "" {
 		return nil, model.NewHTTPError(http.StatusBadRequest, "file_name must include an extension")
 	}
 
-	endpoint := fmt.Sprintf("https://imagex.bytedanceapi.com/?Action=ApplyImageUpload&Version=2018-08-01&ServiceId=%s&NeedFallback=true&FileSize=%d&FileExtension=%s", serviceID, fileSize, ext)
+	endpoint := fmt.Sprintf("%s/?Action=ApplyImageUpload&Version=2018-08-01&ServiceId=%s&NeedFallback=true&FileSize=%d&FileExtension=%s", prov.ImageXBaseURL, serviceID, fileSize, ext)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, fmt.Errorf("create apply upload request: %w", err)
 	}
-	req.Header.Set("Origin", "https://www.doubao.com")
-	req.Header.Set("Referer", "https://www.doubao.com")
-	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Origin", prov.BaseURL)
+	req.Header.Set("Referer", prov.BaseURL)
+	fp.SetHeaders(req.Header)
 
-	if err := signAWSRequest(ctx, req, creds, ""); err != nil {
+	if err := signAWSRequest(ctx, req, creds, prov.ImageXRegion, ""); err != nil {
 		return nil, err
 	}
 
-	resp, err := s.httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("call apply_upload: %w", err)
 	}
@@ -246,12 +293,12 @@ func (s *Service) applyUpload(ctx context.Context, creds aws.Credentials, servic
 	}, nil
 }
 
-func (s *Service) uploadToStore(ctx context.Context, storeURI, auth string, fileBytes []byte) error {
+func (s *Service) uploadToStore(ctx context.Context, client *http.Client, prov *provider.Provider, fp fingerprint.Profile, storeURI, auth string, fileBytes []byte) error {
 	if storeURI == "" {
 		return model.NewHTTPError(http.StatusBadGateway, "store uri missing from apply_upload response")
 	}
 
-	endpoint := fmt.Sprintf("https://tos-d-x-hl.snssdk.com/upload/v1/%s", storeURI)
+	endpoint := fmt.Sprintf("%s/upload/v1/%s", prov.TOSBaseURL, storeURI)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(fileBytes))
 	if err != nil {
 		return fmt.Errorf("create store upload request: %w", err)
@@ -261,14 +308,14 @@ func (s *Service) uploadToStore(ctx context.Context, storeURI, auth string, file
 	crcHex := fmt.Sprintf("%08x", crc)
 
 	req.Header.Set("Authorization", auth)
-	req.Header.Set("Origin", "https://www.doubao.com")
-	req.Header.Set("Referer", "https://www.doubao.com")
-	req.Header.Set("Host", "tos-d-x-hl.snssdk.com")
+	req.Header.Set("Origin", prov.BaseURL)
+	req.Header.Set("Referer", prov.BaseURL)
 	req.Header.Set("Content-Type", "application/octet-stream")
 	req.Header.Set("Content-Disposition", "attachment; filename=\"undefined\"")
 	req.Header.Set("Content-Crc32", crcHex)
+	fp.SetHeaders(req.Header)
 
-	resp, err := s.httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("call store upload: %w", err)
 	}
@@ -289,30 +336,30 @@ func (s *Service) uploadToStore(ctx context.Context, storeURI, auth string, file
 	return nil
 }
 
-func (s *Service) commitUpload(ctx context.Context, creds aws.Credentials, serviceID, sessionKey string) (*commitResult, error) {
+func (s *Service) commitUpload(ctx context.Context, client *http.Client, prov *provider.Provider, fp fingerprint.Profile, creds aws.Credentials, serviceID, sessionKey string) (*commitResult, error) {
 	payload := map[string]string{"SessionKey": sessionKey}
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("marshal commit payload: %w", err)
 	}
 
-	endpoint := fmt.Sprintf("https://imagex.bytedanceapi.com/?Action=CommitImageUpload&Version=2018-08-01&ServiceId=%s", serviceID)
+	endpoint := fmt.Sprintf("%s/?Action=CommitImageUpload&Version=2018-08-01&ServiceId=%s", prov.ImageXBaseURL, serviceID)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("create commit request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Origin", "https://www.doubao.com")
-	req.Header.Set("Referer", "https://www.doubao.com/")
-	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Origin", prov.BaseURL)
+	req.Header.Set("Referer", prov.BaseURL+"/")
+	fp.SetHeaders(req.Header)
 
 	hash := sha256.Sum256(data)
-	if err := signAWSRequest(ctx, req, creds, hex.EncodeToString(hash[:])); err != nil {
+	if err := signAWSRequest(ctx, req, creds, prov.ImageXRegion, hex.EncodeToString(hash[:])); err != nil {
 		return nil, err
 	}
 
-	resp, err := s.httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("call commit_upload: %w", err)
 	}
@@ -385,12 +432,12 @@ func fallbackInt(value, fallback int) int {
 
 const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
 
-func signAWSRequest(ctx context.Context, req *http.Request, creds aws.Credentials, payloadHash string) error {
+func signAWSRequest(ctx context.Context, req *http.Request, creds aws.Credentials, region, payloadHash string) error {
 	signer := v4.NewSigner()
 	if payloadHash == "" {
 		payloadHash = emptyPayloadHash
 	}
-	if err := signer.SignHTTP(ctx, creds, req, payloadHash, "imagex", "cn-north-1", time.Now()); err != nil {
+	if err := signer.SignHTTP(ctx, creds, req, payloadHash, "imagex", region, time.Now()); err != nil {
 		return fmt.Errorf("sign request: %w", err)
 	}
 	return nil
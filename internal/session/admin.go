@@ -0,0 +1,362 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/sealed"
+	"DoubaoProxy/internal/store"
+)
+
+// Info 是管理接口展示的 Session 详情，凭证已脱敏。
+type Info struct {
+	Status
+	Cookie        string      `json:"cookie"`
+	Weight        int         `json:"weight"`
+	MaxConcurrent int         `json:"max_concurrent"`
+	Tags          []string    `json:"tags"`
+	Proxy         string      `json:"proxy,omitempty"`
+	Enabled       bool        `json:"enabled"`
+	Draining      bool        `json:"draining"`
+	Conversations int         `json:"conversations"`
+	Quota         QuotaStatus `json:"quota"`
+}
+
+// Patch 描述管理接口可修改的字段，nil 表示不修改。
+type Patch struct {
+	Weight  *int      `json:"weight"`
+	Tags    *[]string `json:"tags"`
+	Enabled *bool     `json:"enabled"`
+}
+
+// ErrSessionNotFound 表示指定 ID 的 Session 不存在。
+var ErrSessionNotFound = model.NewHTTPError(http.StatusNotFound, "session not found")
+
+// RedactCookie 仅保留 Cookie 名称，隐藏全部取值。
+func RedactCookie(cookie string) string {
+	parts := strings.Split(cookie, ";")
+	out := make([]string, 0, len(parts))
+	for _, part := range parts {
+		name, _, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		out = append(out, name+"=***")
+	}
+	return strings.Join(out, "; ")
+}
+
+// RedactProxy 隐藏代理地址中的密码。
+func RedactProxy(proxy string) string {
+	if u, err := ParseProxy(proxy); err == nil && u != nil {
+		return u.Redacted()
+	}
+	return proxy
+}
+
+// loginCookies 是只有登录账号才会携带的 Cookie。
+var loginCookies = []string{"sessionid", "sid_tt"}
+
+// HasLoginCookie 报告 Cookie 中是否带有登录态（sessionid 或 sid_tt），可据此判断是否为游客。
+func HasLoginCookie(cookie string) bool {
+	for _, part := range strings.Split(cookie, ";") {
+		name, _, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if slices.Contains(loginCookies, strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sessions 返回全部 Session 的管理视图。
+func (p *Pool) Sessions() []Info {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	bound := p.bindingCounts()
+
+	now := p.now()
+	out := make([]Info, 0, len(p.authSessions)+len(p.guestSessions))
+	for _, s := range p.all() {
+		snap := s.Snapshot()
+		tags := snap.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		out = append(out, Info{
+			Status:        s.status(now),
+			Cookie:        RedactCookie(s.currentCookie()),
+			Weight:        snap.Weight,
+			MaxConcurrent: int(p.limit(s)),
+			Tags:          tags,
+			Proxy:         RedactProxy(snap.Proxy),
+			Enabled:       !snap.Disabled,
+			Draining:      s.Draining(),
+			Conversations: bound[s.ID],
+			Quota:         p.quotaStatus(s, now),
+		})
+	}
+	return out
+}
+
+// All 返回池中全部 Session（登录账号在前，游客在后）。
+func (p *Pool) All() []*Session {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.all()
+}
+
+// Session 按 ID 返回 Session。
+func (p *Pool) Session(id string) (*Session, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if s := p.find(id); s != nil {
+		return s, nil
+	}
+	return nil, ErrSessionNotFound
+}
+
+// AddSession 校验并加入一个新 Session。ID 冲突，或与已有 Session 的设备、Cookie 重复时返回 409。
+// 加入的 Session 只存在于内存中，例如游客补充器申请的 Session；管理接口新增条目使用 CreateSession。
+func (p *Pool) AddSession(entry Session) (*Session, error) {
+	if err := entry.validate(); err != nil {
+		return nil, model.NewHTTPError(http.StatusBadRequest, "invalid session: %s", err.Error())
+	}
+	entry.init(time.Now())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.find(entry.ID) != nil {
+		return nil, model.NewHTTPError(http.StatusConflict, "session %s already exists", entry.ID)
+	}
+	if dup := p.duplicateOf(&entry); dup != nil {
+		return nil, model.NewHTTPError(http.StatusConflict, "session duplicates existing session %s", dup.ID)
+	}
+	s := &entry
+	if s.Guest {
+		p.guestSessions = append(append([]*Session(nil), p.guestSessions...), s)
+	} else {
+		p.authSessions = append(append([]*Session(nil), p.authSessions...), s)
+	}
+	slog.Info("session added", "session", s.ID)
+	return s, nil
+}
+
+// CreateSession 与 AddSession 相同，但条目同时保存在 Store 中，重载与重启后仍然保留。
+func (p *Pool) CreateSession(entry Session) (*Session, error) {
+	entry.origin = origin{admin: true}
+	s, err := p.AddSession(entry)
+	if err != nil {
+		return nil, err
+	}
+	op, _, err := overrideOp(s, time.Now())
+	if err == nil {
+		err = p.store.Batch(op)
+	}
+	if err != nil {
+		p.RemoveSession(s)
+		return nil, model.NewHTTPError(http.StatusInternalServerError, "save session %s: %s", s.ID, err.Error())
+	}
+	return s, nil
+}
+
+// duplicateOf 返回与 entry 使用同一设备或同一 Cookie 的已有 Session。调用方须持有 mu。
+func (p *Pool) duplicateOf(entry *Session) *Session {
+	for _, s := range p.all() {
+		snap := s.Snapshot()
+		if snap.ProviderName() == entry.ProviderName() && snap.DeviceID == entry.DeviceID {
+			return s
+		}
+		if snap.Cookie == entry.Cookie {
+			return s
+		}
+	}
+	return nil
+}
+
+// PatchSession 修改 Session 的权重、标签或启用状态，并把结果保存在 Store 中，重载与重启后仍然生效。
+// 重新启用时同时清除排空标记与健康惩罚。
+func (p *Pool) PatchSession(id string, patch Patch) (*Session, error) {
+	s, err := p.Session(id)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Weight != nil && *patch.Weight < 0 {
+		return nil, model.NewHTTPError(http.StatusBadRequest, "weight must not be negative")
+	}
+
+	s.rt.mu.Lock()
+	if patch.Weight != nil {
+		s.Weight = *patch.Weight
+	}
+	if patch.Tags != nil {
+		s.Tags = append([]string(nil), (*patch.Tags)...)
+	}
+	if patch.Enabled != nil {
+		s.Disabled = !*patch.Enabled
+	}
+	s.rt.mu.Unlock()
+
+	now := time.Now()
+	if patch.Enabled != nil && *patch.Enabled {
+		s.rt.draining.Store(false)
+		s.rt.health.revive(now)
+	}
+	if op, ok, err := overrideOp(s, now); err != nil {
+		return nil, errNotSaved(s.ID, err)
+	} else if ok {
+		if err := p.store.Batch(op); err != nil {
+			return nil, errNotSaved(s.ID, err)
+		}
+	}
+	return s, nil
+}
+
+// DrainSession 让 Session 停止承接新会话，已绑定的会话继续由它服务。
+func (p *Pool) DrainSession(id string) (*Session, error) {
+	s, err := p.Session(id)
+	if err != nil {
+		return nil, err
+	}
+	s.rt.draining.Store(true)
+	slog.Info("session draining", "session", s.ID)
+	return s, nil
+}
+
+// DeleteSession 从池中移除 Session 并清理其会话绑定。移除与清理在同一次加锁内完成，
+// 其间不会有新会话绑定到该 Session。来自配置的 Session 在 Store 中留下删除记录，重载与重启后不再出现。
+func (p *Pool) DeleteSession(id string) error {
+	p.mu.Lock()
+	s := p.find(id)
+	if s == nil {
+		p.mu.Unlock()
+		return ErrSessionNotFound
+	}
+	p.removeLocked(s)
+	p.pruneBindings(func(id string) bool { return id != s.ID })
+	p.mu.Unlock()
+	slog.Info("session deleted", "session", s.ID)
+
+	ops := []store.Op{store.DeleteOp(store.BucketCookies, s.ID)}
+	op, ok, err := deletedOp(s, time.Now())
+	if err != nil {
+		return errNotSaved(s.ID, err)
+	}
+	if ok {
+		ops = append(ops, op)
+	}
+	if err := p.store.Batch(ops...); err != nil {
+		return errNotSaved(s.ID, err)
+	}
+	return nil
+}
+
+// SaveConfig 将当前池中的 Session 以原子替换的方式写回配置文件，配置了密钥时写入密文。
+// 启动时因校验失败被跳过的条目不会被写回；来自配置目录的条目由外部管理，也不会写回；
+// 只存在于内存中的 Session（如自动申请的游客）不写回，以免变成手工配置的条目。
+// ${env:...}/${file:...} 引用按原样写回。
+func (p *Pool) SaveConfig() error {
+	p.mu.RLock()
+	entries := make([]any, 0, len(p.authSessions)+len(p.guestSessions))
+	for _, s := range p.all() {
+		snap := s.Snapshot()
+		if (snap.origin.file != "" && snap.origin.file != p.configPath) || snap.origin.runtime() {
+			continue
+		}
+		snap.rt = nil
+		entry, err := encodeEntry(snap)
+		if err != nil {
+			p.mu.RUnlock()
+			return fmt.Errorf("encode session %s: %w", snap.ID, err)
+		}
+		entries = append(entries, entry)
+	}
+	p.mu.RUnlock()
+
+	data, err := json.MarshalIndent(entries, "", "    ")
+	if err != nil {
+		return fmt.Errorf("encode session config: %w", err)
+	}
+	return WriteConfigFile(p.configPath, append(data, '\n'), p.key)
+}
+
+// WriteConfigFile 原子写入 Session 配置文件，key 非空时先加密。
+func WriteConfigFile(path string, plaintext []byte, key *sealed.Key) error {
+	data := plaintext
+	if key != nil {
+		var err error
+		if data, err = sealed.Seal(key, plaintext); err != nil {
+			return fmt.Errorf("encrypt session config: %w", err)
+		}
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic 先写入同目录的临时文件再重命名，避免读者看到写了一半的文件。
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp session config: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		tmp.Close()
+		return fmt.Errorf("stat session config: %w", err)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp session config: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync temp session config: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp session config: %w", err)
+	}
+	if err := os.Chmod(tmpName, mode); err != nil {
+		return fmt.Errorf("chmod temp session config: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("replace session config: %w", err)
+	}
+	return nil
+}
+
+// all 返回全部 Session（登录账号在前，游客在后）。调用方须持有 mu。
+func (p *Pool) all() []*Session {
+	out := make([]*Session, 0, len(p.authSessions)+len(p.guestSessions))
+	out = append(out, p.authSessions...)
+	return append(out, p.guestSessions...)
+}
+
+// find 按 ID 查找 Session。调用方须持有 mu。
+func (p *Pool) find(id string) *Session {
+	for _, s := range p.authSessions {
+		if s.ID == id {
+			return s
+		}
+	}
+	for _, s := range p.guestSessions {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
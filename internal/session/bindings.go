@@ -0,0 +1,379 @@
+package session
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/store"
+)
+
+// BindingPolicy 控制会话绑定的淘汰。
+type BindingPolicy struct {
+	// TTL 为绑定的最长空闲时间，超过后视为过期。
+	TTL time.Duration
+	// MaxEntries 为绑定数量上限，超出时淘汰最久未使用的绑定。
+	MaxEntries int
+}
+
+// DefaultBindingPolicy 返回默认的绑定淘汰策略。
+func DefaultBindingPolicy() BindingPolicy {
+	return BindingPolicy{
+		TTL:        7 * 24 * time.Hour,
+		MaxEntries: 100000,
+	}
+}
+
+func (bp BindingPolicy) normalize() BindingPolicy {
+	def := DefaultBindingPolicy()
+	if bp.TTL <= 0 {
+		bp.TTL = def.TTL
+	}
+	if bp.MaxEntries <= 0 {
+		bp.MaxEntries = def.MaxEntries
+	}
+	return bp
+}
+
+// 淘汰原因，同时用作指标名。
+const (
+	evictedTTL = "evicted_ttl"
+	evictedLRU = "evicted_lru"
+	// evictedRemoved 表示绑定的 Session 已被删除、移出配置或被回收。
+	evictedRemoved = "evicted_session_removed"
+)
+
+// bindingMetrics 通过 expvar 暴露绑定数量与淘汰次数。
+var bindingMetrics = expvar.NewMap("session_bindings")
+
+// bindingTable 是会话绑定在内存中的 LRU 索引，并把每次变更写入 Store。
+// 被淘汰的绑定会留下墓碑，保留一个 TTL 周期，用于返回明确的过期错误。
+//
+// mu 只保护内存索引：变更在持有 mu 时收集成一批写操作，在释放 mu 之后以单个事务写入，
+// 磁盘 I/O 不会阻塞查找。writeMu 在释放 mu 之前获取，保证各批写操作按变更顺序落盘。
+type bindingTable struct {
+	mu      sync.Mutex
+	writeMu sync.Mutex
+	store   store.Store
+	policy  BindingPolicy
+	// order 按最近使用时间排列，队首最新。
+	order *list.List
+	items map[string]*list.Element
+}
+
+type bindingEntry struct {
+	conversationID string
+	binding        store.Binding
+}
+
+func newBindingTable(st store.Store, policy BindingPolicy) *bindingTable {
+	return &bindingTable{
+		store:  st,
+		policy: policy.normalize(),
+		order:  list.New(),
+		items:  make(map[string]*list.Element),
+	}
+}
+
+// load 从 Store 重建 LRU 索引，并立即执行一次淘汰。
+func (t *bindingTable) load(now time.Time) error {
+	var entries []*bindingEntry
+	err := t.store.ForEach(store.BucketBindings, func(conversationID string, value []byte) error {
+		var b store.Binding
+		if err := json.Unmarshal(value, &b); err != nil {
+			slog.Warn("skip corrupt conversation binding", "conversation_id", conversationID, "error", err)
+			return nil
+		}
+		if b.LastUsed.IsZero() {
+			b.LastUsed = b.CreatedAt
+		}
+		entries = append(entries, &bindingEntry{conversationID: conversationID, binding: b})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].binding.LastUsed.After(entries[j].binding.LastUsed)
+	})
+	t.mu.Lock()
+	for _, e := range entries {
+		t.items[e.conversationID] = t.order.PushBack(e)
+	}
+	var ops []store.Op
+	t.evictLocked(now, &ops)
+	t.unlockAndWrite(ops)
+	return nil
+}
+
+// unlockAndWrite 释放 mu，并以单个事务写入 ops。调用方须持有 mu。
+func (t *bindingTable) unlockAndWrite(ops []store.Op) {
+	if len(ops) == 0 {
+		t.mu.Unlock()
+		return
+	}
+	t.writeMu.Lock()
+	t.mu.Unlock()
+	defer t.writeMu.Unlock()
+	if err := t.store.Batch(ops...); err != nil {
+		slog.Error("save conversation bindings failed", "ops", len(ops), "error", err)
+	}
+}
+
+// lookup 返回会话绑定的 Session ID；未绑定时返回空串。
+// 绑定已空闲超时或此前已被淘汰时返回 410。墓碑在释放 mu 之后再从 Store 读取。
+func (t *bindingTable) lookup(conversationID string, now time.Time) (string, error) {
+	t.mu.Lock()
+	if el, ok := t.items[conversationID]; ok {
+		e := el.Value.(*bindingEntry)
+		if now.Sub(e.binding.LastUsed) <= t.policy.TTL {
+			id := e.binding.SessionID
+			t.mu.Unlock()
+			return id, nil
+		}
+		var ops []store.Op
+		t.expireLocked(el, evictedTTL, now, &ops)
+		t.unlockAndWrite(ops)
+		return "", conversationExpired(conversationID)
+	}
+	t.mu.Unlock()
+
+	var tomb store.Tombstone
+	err := store.GetJSON(t.store, store.BucketExpired, conversationID, &tomb)
+	switch {
+	case err == nil:
+		bindingMetrics.Add("expired_requests", 1)
+		if tomb.Reason == evictedRemoved {
+			return "", conversationOrphaned(conversationID)
+		}
+		return "", conversationExpired(conversationID)
+	case errors.Is(err, store.ErrNotFound):
+		return "", nil
+	default:
+		return "", model.NewHTTPError(http.StatusInternalServerError, "load conversation binding: %s", err.Error())
+	}
+}
+
+func conversationExpired(conversationID string) error {
+	return model.NewHTTPError(http.StatusGone, "conversation %s expired, please start a new conversation", conversationID)
+}
+
+func conversationOrphaned(conversationID string) error {
+	return model.NewHTTPError(http.StatusGone, "conversation %s belongs to a session that was removed, please start a new conversation", conversationID)
+}
+
+// bind 创建或刷新绑定。同一 Session 的重复绑定只更新最近使用时间。
+// 写入绑定与删除旧墓碑在同一个事务中完成。
+func (t *bindingTable) bind(conversationID, sessionID string, now time.Time) {
+	t.mu.Lock()
+	b := store.Binding{SessionID: sessionID, CreatedAt: now, LastUsed: now}
+	if el, ok := t.items[conversationID]; ok {
+		e := el.Value.(*bindingEntry)
+		if e.binding.SessionID == sessionID {
+			b.CreatedAt = e.binding.CreatedAt
+		}
+		e.binding = b
+		t.order.MoveToFront(el)
+	} else {
+		t.items[conversationID] = t.order.PushFront(&bindingEntry{conversationID: conversationID, binding: b})
+	}
+
+	var ops []store.Op
+	if op, err := store.PutJSONOp(store.BucketBindings, conversationID, b); err != nil {
+		slog.Error("save conversation binding failed", "conversation_id", conversationID, "session", sessionID, "error", err)
+	} else {
+		ops = append(ops, op, store.DeleteOp(store.BucketExpired, conversationID))
+	}
+	t.evictLocked(now, &ops)
+	t.unlockAndWrite(ops)
+}
+
+// forget 删除绑定，不留墓碑。
+func (t *bindingTable) forget(conversationID string) {
+	t.mu.Lock()
+	if el, ok := t.items[conversationID]; ok {
+		t.order.Remove(el)
+		delete(t.items, conversationID)
+	}
+	// 不在索引中也删除，兼容索引与存储短暂不一致的情况。
+	t.unlockAndWrite([]store.Op{store.DeleteOp(store.BucketBindings, conversationID)})
+}
+
+// prune 删除 keep 返回 false 的 Session 的全部绑定并留下墓碑，返回删除数量。
+// 墓碑使这些会话明确返回 410，而不是悄悄换到其他 Session 上。
+func (t *bindingTable) prune(keep func(sessionID string) bool, now time.Time) int {
+	t.mu.Lock()
+	var ops []store.Op
+	removed := 0
+	for el := t.order.Front(); el != nil; {
+		next := el.Next()
+		if !keep(el.Value.(*bindingEntry).binding.SessionID) {
+			t.expireLocked(el, evictedRemoved, now, &ops)
+			removed++
+		}
+		el = next
+	}
+	t.unlockAndWrite(ops)
+	return removed
+}
+
+// counts 统计每个 Session ID 当前绑定的会话数量。
+func (t *bindingTable) counts() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]int)
+	for el := t.order.Front(); el != nil; el = el.Next() {
+		out[el.Value.(*bindingEntry).binding.SessionID]++
+	}
+	return out
+}
+
+// sweep 淘汰过期绑定并清理过旧的墓碑。
+func (t *bindingTable) sweep(now time.Time) {
+	t.mu.Lock()
+	var ops []store.Op
+	expired := t.evictLocked(now, &ops)
+	t.unlockAndWrite(ops)
+	if expired > 0 {
+		slog.Info("evicted idle conversation bindings", "count", expired)
+	}
+	t.sweepTombstones(now)
+}
+
+// evictLocked 先淘汰空闲超时的绑定，再按 LRU 淘汰超出上限的绑定，写操作追加到 ops。调用方须持有 mu。
+func (t *bindingTable) evictLocked(now time.Time, ops *[]store.Op) int {
+	evicted := 0
+	for el := t.order.Back(); el != nil; el = t.order.Back() {
+		if now.Sub(el.Value.(*bindingEntry).binding.LastUsed) <= t.policy.TTL {
+			break
+		}
+		t.expireLocked(el, evictedTTL, now, ops)
+		evicted++
+	}
+	for t.order.Len() > t.policy.MaxEntries {
+		t.expireLocked(t.order.Back(), evictedLRU, now, ops)
+		evicted++
+	}
+	return evicted
+}
+
+// expireLocked 从索引中淘汰一条绑定，并把删除绑定、写入墓碑追加到 ops。调用方须持有 mu。
+func (t *bindingTable) expireLocked(el *list.Element, reason string, now time.Time, ops *[]store.Op) {
+	e := t.order.Remove(el).(*bindingEntry)
+	delete(t.items, e.conversationID)
+	bindingMetrics.Add(reason, 1)
+	*ops = append(*ops, store.DeleteOp(store.BucketBindings, e.conversationID))
+	tomb, err := store.PutJSONOp(store.BucketExpired, e.conversationID, store.Tombstone{ExpiredAt: now, Reason: reason})
+	if err != nil {
+		slog.Error("save conversation tombstone failed", "conversation_id", e.conversationID, "error", err)
+		return
+	}
+	*ops = append(*ops, tomb)
+}
+
+// sweepTombstones 删除超过一个 TTL 周期的墓碑，数量超出上限时优先删除最旧的。
+func (t *bindingTable) sweepTombstones(now time.Time) {
+	type tombEntry struct {
+		conversationID string
+		expiredAt      time.Time
+	}
+	var (
+		stale []string
+		kept  []tombEntry
+	)
+	err := t.store.ForEach(store.BucketExpired, func(conversationID string, value []byte) error {
+		var tomb store.Tombstone
+		if err := json.Unmarshal(value, &tomb); err != nil || now.Sub(tomb.ExpiredAt) > t.policy.TTL {
+			stale = append(stale, conversationID)
+			return nil
+		}
+		kept = append(kept, tombEntry{conversationID, tomb.ExpiredAt})
+		return nil
+	})
+	if err != nil {
+		slog.Error("scan conversation tombstones failed", "error", err)
+		return
+	}
+	if over := len(kept) - t.policy.MaxEntries; over > 0 {
+		sort.Slice(kept, func(i, j int) bool { return kept[i].expiredAt.Before(kept[j].expiredAt) })
+		for _, e := range kept[:over] {
+			stale = append(stale, e.conversationID)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	ops := make([]store.Op, 0, len(stale))
+	for _, conversationID := range stale {
+		ops = append(ops, store.DeleteOp(store.BucketExpired, conversationID))
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if err := t.store.Batch(ops...); err != nil {
+		slog.Error("delete conversation tombstones failed", "count", len(stale), "error", err)
+	}
+}
+
+func (t *bindingTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.order.Len()
+}
+
+// BindConversation 将会话 ID 与具体 Session 绑定，并刷新其最近使用时间。
+func (p *Pool) BindConversation(conversationID string, s *Session) {
+	if conversationID == "" || s == nil {
+		return
+	}
+	p.bindings.bind(conversationID, s.ID, time.Now())
+}
+
+// ForgetConversation 移除会话 ID 与 Session 的映射。
+func (p *Pool) ForgetConversation(conversationID string) {
+	if conversationID == "" {
+		return
+	}
+	p.bindings.forget(conversationID)
+}
+
+// SweepBindings 每隔 interval 淘汰一次过期的会话绑定，直到 ctx 结束。
+func (p *Pool) SweepBindings(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.bindings.sweep(now)
+		}
+	}
+}
+
+// boundSession 返回绑定到 sessionID 的 Session。
+// 该 Session 已不在池中时返回 410，避免会话被悄悄转到其他账号。调用方须持有 mu。
+func (p *Pool) boundSession(conversationID, sessionID string) (*Session, error) {
+	if s := p.find(sessionID); s != nil {
+		return s, nil
+	}
+	return nil, conversationOrphaned(conversationID)
+}
+
+// pruneBindings 删除 keep 返回 false 的 Session 的全部绑定并留下墓碑，返回删除数量。
+func (p *Pool) pruneBindings(keep func(sessionID string) bool) int {
+	return p.bindings.prune(keep, time.Now())
+}
+
+// bindingCounts 统计每个 Session ID 当前绑定的会话数量。
+func (p *Pool) bindingCounts() map[string]int {
+	return p.bindings.counts()
+}
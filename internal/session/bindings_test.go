@@ -0,0 +1,197 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/store"
+)
+
+func testEntry(id string) Session {
+	return Session{
+		ID:         id,
+		Cookie:     "sessionid=" + id,
+		DeviceID:   "device-" + id,
+		TeaUUID:    "tea-" + id,
+		WebID:      "web-" + id,
+		RoomID:     "room-" + id,
+		XFlowTrace: "trace-" + id,
+	}
+}
+
+// newTestPool 把 entries 写入临时配置文件并以其创建 Pool。
+func newTestPool(t *testing.T, opts Options, entries ...Session) (*Pool, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "session.json")
+	writeTestConfig(t, path, entries...)
+	p, err := NewPool(path, opts)
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	return p, path
+}
+
+func writeTestConfig(t *testing.T, path string, entries ...Session) {
+	t.Helper()
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func wantStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var httpErr *model.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Status != status {
+		t.Fatalf("err = %v, want status %d", err, status)
+	}
+}
+
+func TestRemovedSessionBindingsAreTombstoned(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove func(t *testing.T, p *Pool, path string)
+	}{
+		{"delete", func(t *testing.T, p *Pool, _ string) {
+			if err := p.DeleteSession("a"); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"reload", func(t *testing.T, p *Pool, path string) {
+			writeTestConfig(t, path, testEntry("b"))
+			if _, err := p.Reload(); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, path := newTestPool(t, Options{}, testEntry("a"), testEntry("b"))
+			a, _ := p.Session("a")
+			p.BindConversation("c1", a)
+
+			tt.remove(t, p, path)
+
+			_, err := p.GetSession(Criteria{ConversationID: "c1"})
+			wantStatus(t, err, http.StatusGone)
+			if _, err := p.store.Get(store.BucketExpired, "c1"); err != nil {
+				t.Errorf("tombstone not written: %v", err)
+			}
+		})
+	}
+}
+
+func TestBindingToMissingSessionIsGone(t *testing.T) {
+	p, _ := newTestPool(t, Options{}, testEntry("a"))
+	// 索引中的绑定指向池中不存在的 Session，例如与存储短暂不一致时。
+	p.bindings.bind("c1", "ghost", time.Now())
+
+	_, err := p.GetSession(Criteria{ConversationID: "c1"})
+	wantStatus(t, err, http.StatusGone)
+}
+
+// recordingStore 记录写调用次数，并可让 Get 阻塞，用于检查绑定表的锁与事务边界。
+type recordingStore struct {
+	store.Store
+	mu      sync.Mutex
+	writes  int
+	batches [][]store.Op
+	// block 非空时 Get 先通知 entered，再等待 block 关闭。
+	block   chan struct{}
+	entered chan struct{}
+}
+
+func (s *recordingStore) Put(bucket, key string, value []byte) error {
+	s.mu.Lock()
+	s.writes++
+	s.mu.Unlock()
+	return s.Store.Put(bucket, key, value)
+}
+
+func (s *recordingStore) Delete(bucket, key string) error {
+	s.mu.Lock()
+	s.writes++
+	s.mu.Unlock()
+	return s.Store.Delete(bucket, key)
+}
+
+func (s *recordingStore) Batch(ops ...store.Op) error {
+	s.mu.Lock()
+	s.batches = append(s.batches, ops)
+	s.mu.Unlock()
+	return s.Store.Batch(ops...)
+}
+
+func (s *recordingStore) Get(bucket, key string) ([]byte, error) {
+	if s.block != nil {
+		s.entered <- struct{}{}
+		<-s.block
+	}
+	return s.Store.Get(bucket, key)
+}
+
+func TestBindWritesOneTransaction(t *testing.T) {
+	st := &recordingStore{Store: store.NewMemory()}
+	table := newBindingTable(st, BindingPolicy{})
+	now := time.Now()
+	if err := st.Store.Put(store.BucketExpired, "c1", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	table.bind("c1", "a", now)
+
+	if st.writes != 0 || len(st.batches) != 1 {
+		t.Fatalf("writes = %d, batches = %d; want a single batch", st.writes, len(st.batches))
+	}
+	if _, err := st.Store.Get(store.BucketBindings, "c1"); err != nil {
+		t.Errorf("binding not saved: %v", err)
+	}
+	if _, err := st.Store.Get(store.BucketExpired, "c1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("tombstone not deleted: %v", err)
+	}
+}
+
+func TestLookupReadsStoreOutsideLock(t *testing.T) {
+	st := &recordingStore{Store: store.NewMemory(), block: make(chan struct{}), entered: make(chan struct{}, 1)}
+	table := newBindingTable(st, BindingPolicy{})
+	now := time.Now()
+	table.bind("bound", "a", now)
+
+	// 未绑定的会话需要读取墓碑，此时 Store 被阻塞。
+	done := make(chan error, 1)
+	go func() {
+		_, err := table.lookup("unknown", now)
+		done <- err
+	}()
+	<-st.entered
+
+	// 阻塞的读取不应妨碍内存中的查找与绑定。
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		if id, err := table.lookup("bound", now); err != nil || id != "a" {
+			t.Errorf("lookup = %q, %v", id, err)
+		}
+		table.bind("other", "b", now)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("lookup blocked behind store I/O")
+	}
+
+	close(st.block)
+	if err := <-done; err != nil {
+		t.Fatalf("lookup unknown: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"DoubaoProxy/internal/store"
+)
+
+// cookieJar 是 Session 专属的 http.CookieJar，以配置中的 Cookie 串为种子，按名称合并上游 Set-Cookie。
+// 只对后端网页接口所在的站点生效，ImageX、TOS 等其他域名拿不到 Session 凭证。
+type cookieJar struct {
+	// seed 为建立 Jar 时配置中的 Cookie 串，配置变化后 Jar 需要重建。
+	seed string
+	site string
+
+	mu      sync.Mutex
+	cookies []*http.Cookie
+	// onChange 在 Cookie 内容变化后以最新的 Cookie 串调用，不持有 mu。
+	onChange func(cookie string)
+}
+
+func newCookieJar(seed, cookie, baseURL string, onChange func(string)) *cookieJar {
+	j := &cookieJar{seed: seed, onChange: onChange}
+	if u, err := url.Parse(baseURL); err == nil {
+		j.site = site(u.Hostname())
+	}
+	for _, part := range strings.Split(cookie, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || name == "" {
+			continue
+		}
+		j.cookies = append(j.cookies, &http.Cookie{Name: name, Value: value})
+	}
+	return j
+}
+
+// Cookies 实现 http.CookieJar，返回尚未过期的 Cookie。
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	if !j.matches(u) {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	out := make([]*http.Cookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		if c.Expires.IsZero() || c.Expires.After(now) {
+			out = append(out, &http.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	return out
+}
+
+// SetCookies 实现 http.CookieJar：同名 Cookie 原位替换，新 Cookie 追加在末尾，已过期或 Max-Age<0 的删除。
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if !j.matches(u) || len(cookies) == 0 {
+		return
+	}
+	now := time.Now()
+	j.mu.Lock()
+	before := j.stringLocked(now)
+	for _, c := range cookies {
+		i := j.indexLocked(c.Name)
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && !c.Expires.After(now)) {
+			if i >= 0 {
+				j.cookies = append(j.cookies[:i], j.cookies[i+1:]...)
+			}
+			continue
+		}
+		next := &http.Cookie{Name: c.Name, Value: c.Value, Expires: c.Expires}
+		if c.MaxAge > 0 {
+			next.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		if i >= 0 {
+			j.cookies[i] = next
+		} else {
+			j.cookies = append(j.cookies, next)
+		}
+	}
+	after := j.stringLocked(now)
+	j.mu.Unlock()
+
+	if after != before && j.onChange != nil {
+		j.onChange(after)
+	}
+}
+
+// String 返回当前有效的 Cookie 串。
+func (j *cookieJar) String() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.stringLocked(time.Now())
+}
+
+func (j *cookieJar) stringLocked(now time.Time) string {
+	parts := make([]string, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		if c.Expires.IsZero() || c.Expires.After(now) {
+			parts = append(parts, c.Name+"="+c.Value)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (j *cookieJar) indexLocked(name string) int {
+	for i, c := range j.cookies {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (j *cookieJar) matches(u *url.URL) bool {
+	return j.site != "" && site(u.Hostname()) == j.site
+}
+
+// site 返回主机所属的站点：IP 原样返回，域名取最后两段，如 www.doubao.com → doubao.com。
+func site(host string) string {
+	if host == "" || net.ParseIP(host) != nil {
+		return host
+	}
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	if len(labels) <= 2 {
+		return strings.Join(labels, ".")
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// CookieJar 返回 Session 的 Cookie Jar，baseURL 为其后端网页接口地址。
+// 上游通过 Set-Cookie 轮换的 Cookie 会写入 Store，重启后只要配置中的 Cookie 未改动就沿用最新值；
+// 配置中的 Cookie 被修改（热加载或管理接口）后以新配置为准重建。
+func (p *Pool) CookieJar(s *Session, baseURL string) http.CookieJar {
+	if s.rt == nil {
+		return newCookieJar(s.Cookie, s.Cookie, baseURL, nil)
+	}
+	s.rt.mu.Lock()
+	defer s.rt.mu.Unlock()
+	if s.rt.jar != nil && s.rt.jar.seed == s.Cookie {
+		return s.rt.jar
+	}
+
+	seed, id := s.Cookie, s.ID
+	cookie := seed
+	var rec store.Cookie
+	switch err := store.GetJSON(p.store, store.BucketCookies, id, &rec); {
+	case err == nil && rec.Seed == cookieDigest(seed):
+		cookie = rec.Cookie
+	case err != nil && !errors.Is(err, store.ErrNotFound):
+		slog.Warn("load refreshed cookie failed", "session", id, "error", err)
+	}
+
+	s.rt.jar = newCookieJar(seed, cookie, baseURL, func(cookie string) {
+		rec := store.Cookie{Seed: cookieDigest(seed), Cookie: cookie, UpdatedAt: time.Now()}
+		if err := store.PutJSON(p.store, store.BucketCookies, id, rec); err != nil {
+			slog.Error("save refreshed cookie failed", "session", id, "error", err)
+			return
+		}
+		slog.Debug("session cookie refreshed", "session", id)
+	})
+	return s.rt.jar
+}
+
+// currentCookie 返回 Session 正在使用的 Cookie 串：已建立 Jar 时为其中的最新值，否则为配置值。
+func (s *Session) currentCookie() string {
+	if s.rt == nil {
+		return s.Cookie
+	}
+	s.rt.mu.RLock()
+	jar, cookie := s.rt.jar, s.Cookie
+	s.rt.mu.RUnlock()
+	if jar != nil && jar.seed == cookie {
+		return jar.String()
+	}
+	return cookie
+}
+
+// cookieDigest 摘要配置中的 Cookie，Store 中只记录摘要，用于判断配置是否改动过。
+func cookieDigest(cookie string) string {
+	sum := sha256.Sum256([]byte(cookie))
+	return hex.EncodeToString(sum[:16])
+}
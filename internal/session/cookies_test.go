@@ -0,0 +1,132 @@
+package session
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"DoubaoProxy/internal/store"
+)
+
+const cookieBase = "https://www.doubao.com"
+
+func cookieURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// rotate 模拟上游在聊天响应中通过 Set-Cookie 轮换 msToken。
+func rotate(t *testing.T, p *Pool, id, token string) {
+	t.Helper()
+	s, err := p.Session(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.CookieJar(s, cookieBase).SetCookies(cookieURL(t, cookieBase+"/samantha/chat/completion"), []*http.Cookie{{Name: "msToken", Value: token}})
+}
+
+func currentCookie(t *testing.T, p *Pool, id string) string {
+	t.Helper()
+	s, err := p.Session(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cookieString(p.CookieJar(s, cookieBase).Cookies(cookieURL(t, cookieBase+"/")))
+}
+
+func cookieString(cookies []*http.Cookie) string {
+	parts := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		parts = append(parts, c.Name+"="+c.Value)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func TestCookieJarMergesSetCookie(t *testing.T) {
+	jar := newCookieJar("", "sessionid=a; msToken=old; ttwid=t", cookieBase, nil)
+	jar.SetCookies(cookieURL(t, "https://api.doubao.com/x"), []*http.Cookie{
+		{Name: "msToken", Value: "new"},
+		{Name: "ttwid", MaxAge: -1},
+		{Name: "s_v_web_id", Value: "v", MaxAge: 60},
+	})
+	if got, want := jar.String(), "sessionid=a; msToken=new; s_v_web_id=v"; got != want {
+		t.Errorf("jar = %q, want %q", got, want)
+	}
+	// 其他站点（如 ImageX、TOS）既拿不到 Session 凭证，也不能改写它。
+	other := cookieURL(t, "https://imagex.bytedanceapi.com/")
+	if got := jar.Cookies(other); len(got) != 0 {
+		t.Errorf("cookies sent to another site: %v", got)
+	}
+	jar.SetCookies(other, []*http.Cookie{{Name: "sessionid", Value: "evil"}})
+	if got := jar.String(); got != "sessionid=a; msToken=new; s_v_web_id=v" {
+		t.Errorf("another site changed the jar: %q", got)
+	}
+}
+
+func TestRotatedCookieSurvivesRestart(t *testing.T) {
+	st := store.NewMemory()
+	p, path := newTestPool(t, Options{Store: st}, testEntry("a"))
+	rotate(t, p, "a", "t1")
+
+	var rec store.Cookie
+	if err := store.GetJSON(st, store.BucketCookies, "a", &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Cookie != "sessionid=a; msToken=t1" || rec.Seed != cookieDigest("sessionid=a") {
+		t.Errorf("stored cookie = %+v", rec)
+	}
+	if rec.Seed == "sessionid=a" {
+		t.Error("stored seed is the raw cookie, want a digest")
+	}
+
+	restarted, err := NewPool(path, Options{Store: st})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := currentCookie(t, restarted, "a"); got != "sessionid=a; msToken=t1" {
+		t.Errorf("cookie after restart = %q", got)
+	}
+	// 未改动配置的热加载同样沿用 Jar。
+	reload(t, restarted)
+	if got := currentCookie(t, restarted, "a"); got != "sessionid=a; msToken=t1" {
+		t.Errorf("cookie after reload = %q", got)
+	}
+}
+
+func TestSeedChangeDiscardsRotatedCookie(t *testing.T) {
+	st := store.NewMemory()
+	p, path := newTestPool(t, Options{Store: st}, testEntry("a"))
+	rotate(t, p, "a", "t1")
+
+	a := testEntry("a")
+	a.Cookie = "sessionid=renewed"
+	writeTestConfig(t, path, a)
+
+	// 热加载：配置中的 Cookie 改动后 Jar 以新配置重建。
+	reload(t, p)
+	if got := currentCookie(t, p, "a"); got != "sessionid=renewed" {
+		t.Errorf("cookie after reload = %q, want the new seed", got)
+	}
+	// 重启：Store 中的记录属于旧种子，不再采用。
+	restarted, err := NewPool(path, Options{Store: st})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := currentCookie(t, restarted, "a"); got != "sessionid=renewed" {
+		t.Errorf("cookie after restart = %q, want the new seed", got)
+	}
+
+	rotate(t, restarted, "a", "t2")
+	var rec store.Cookie
+	if err := store.GetJSON(st, store.BucketCookies, "a", &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Cookie != "sessionid=renewed; msToken=t2" || rec.Seed != cookieDigest("sessionid=renewed") {
+		t.Errorf("stored cookie = %+v", rec)
+	}
+}
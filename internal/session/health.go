@@ -0,0 +1,203 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// State 表示 Session 的健康状态。
+type State string
+
+const (
+	// StateHealthy 可正常接收流量。
+	StateHealthy State = "healthy"
+	// StateSuspect 近期出现过瞬时错误或刚结束冷却，仍可接收流量但优先级低于 healthy。
+	StateSuspect State = "suspect"
+	// StateCooling 处于冷却期，冷却结束后自动恢复为 suspect。
+	StateCooling State = "cooling"
+	// StateDead 凭证失效，需要更新凭证后才能恢复。
+	StateDead State = "dead"
+)
+
+// FailureKind 描述一次上游失败的类别，决定状态机如何迁移。
+type FailureKind int
+
+const (
+	// FailureTransient 为 5xx、网关错误或网络错误，连续出现多次后进入冷却。
+	FailureTransient FailureKind = iota + 1
+	// FailureRateLimited 为额度或频率限制，立即进入指数退避冷却。
+	FailureRateLimited
+	// FailureAuth 为凭证过期或被拒绝，直接标记为 dead。
+	FailureAuth
+)
+
+func (k FailureKind) String() string {
+	switch k {
+	case FailureTransient:
+		return "transient"
+	case FailureRateLimited:
+		return "rate_limited"
+	case FailureAuth:
+		return "auth_expired"
+	default:
+		return "unknown"
+	}
+}
+
+// HealthPolicy 控制冷却时长与判定阈值。
+type HealthPolicy struct {
+	// CooldownBase 为首次冷却时长，之后每次翻倍。
+	CooldownBase time.Duration
+	// CooldownMax 为单次冷却的上限。
+	CooldownMax time.Duration
+	// SuspectThreshold 为连续瞬时错误达到多少次后进入冷却。
+	SuspectThreshold int
+}
+
+// DefaultHealthPolicy 返回默认的健康策略。
+func DefaultHealthPolicy() HealthPolicy {
+	return HealthPolicy{
+		CooldownBase:     time.Minute,
+		CooldownMax:      time.Hour,
+		SuspectThreshold: 3,
+	}
+}
+
+func (hp HealthPolicy) normalize() HealthPolicy {
+	def := DefaultHealthPolicy()
+	if hp.CooldownBase <= 0 {
+		hp.CooldownBase = def.CooldownBase
+	}
+	if hp.CooldownMax < hp.CooldownBase {
+		hp.CooldownMax = max(def.CooldownMax, hp.CooldownBase)
+	}
+	if hp.SuspectThreshold <= 0 {
+		hp.SuspectThreshold = def.SuspectThreshold
+	}
+	return hp
+}
+
+func (hp HealthPolicy) cooldown(level int) time.Duration {
+	d := hp.CooldownBase
+	for i := 0; i < level && d < hp.CooldownMax; i++ {
+		d *= 2
+	}
+	return min(d, hp.CooldownMax)
+}
+
+// Status 是某个 Session 健康状况的快照。
+type Status struct {
+	ID       string    `json:"id"`
+	Provider string    `json:"provider"`
+	Guest    bool      `json:"guest"`
+	State    State     `json:"state"`
+	Reason   string    `json:"reason,omitempty"`
+	Since    time.Time `json:"since"`
+	// Until 为冷却结束时间，仅在 cooling 状态下有值。
+	Until    *time.Time `json:"until,omitempty"`
+	Failures int        `json:"consecutive_failures"`
+	InFlight int64      `json:"in_flight"`
+	// Queued 为正在等待并发名额的请求数。
+	Queued int `json:"queued"`
+}
+
+// health 保存单个 Session 的状态机数据。
+type health struct {
+	mu       sync.Mutex
+	state    State
+	reason   string
+	since    time.Time
+	until    time.Time
+	failures int
+	level    int
+	// probation 表示刚结束冷却，期间再次失败会立即重新冷却。
+	probation bool
+}
+
+func newHealth(now time.Time) *health {
+	return &health{state: StateHealthy, since: now}
+}
+
+// current 返回当前状态，冷却到期时自动迁移为 suspect（重新放行）。调用方须持有 mu。
+func (h *health) current(now time.Time) State {
+	if h.state == StateCooling && !now.Before(h.until) {
+		h.set(StateSuspect, "cooldown expired", now)
+		h.until = time.Time{}
+		h.probation = true
+	}
+	return h.state
+}
+
+func (h *health) set(state State, reason string, now time.Time) {
+	if h.state != state {
+		h.since = now
+	}
+	h.state = state
+	h.reason = reason
+}
+
+func (h *health) success(now time.Time) (changed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prev := h.current(now)
+	h.failures = 0
+	h.level = 0
+	h.probation = false
+	if prev == StateDead {
+		return false
+	}
+	h.set(StateHealthy, "", now)
+	return prev != StateHealthy
+}
+
+func (h *health) failure(kind FailureKind, reason string, policy HealthPolicy, now time.Time) (State, time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prev := h.current(now)
+	if prev == StateDead {
+		return StateDead, 0
+	}
+
+	switch kind {
+	case FailureAuth:
+		h.set(StateDead, reason, now)
+		return StateDead, 0
+	case FailureRateLimited:
+		return h.cool(reason, policy, now)
+	default:
+		h.failures++
+		if h.probation || h.failures >= policy.SuspectThreshold {
+			return h.cool(reason, policy, now)
+		}
+		h.set(StateSuspect, reason, now)
+		return StateSuspect, 0
+	}
+}
+
+func (h *health) cool(reason string, policy HealthPolicy, now time.Time) (State, time.Duration) {
+	d := policy.cooldown(h.level)
+	h.level++
+	h.failures = 0
+	h.probation = false
+	h.set(StateCooling, reason, now)
+	h.until = now.Add(d)
+	return StateCooling, d
+}
+
+// revive 将 dead 或 cooling 的 Session 重置为 healthy，用于凭证更新等人工干预。
+func (h *health) revive(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures = 0
+	h.level = 0
+	h.probation = false
+	h.until = time.Time{}
+	h.set(StateHealthy, "", now)
+}
+
+func (h *health) snapshot(now time.Time) (State, string, time.Time, time.Time, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state := h.current(now)
+	return state, h.reason, h.since, h.until, h.failures
+}
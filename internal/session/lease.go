@@ -0,0 +1,122 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"DoubaoProxy/internal/model"
+)
+
+// LeasePolicy 控制每个 Session 的并发上限与排队等待。
+type LeasePolicy struct {
+	// MaxConcurrent 为未单独配置 max_concurrent 的 Session 的并发上限，0 表示不限。
+	MaxConcurrent int
+	// QueueTimeout 为等待空闲名额的最长时间。
+	QueueTimeout time.Duration
+}
+
+// DefaultLeasePolicy 返回默认的并发策略：不限并发，排队最多 30 秒。
+func DefaultLeasePolicy() LeasePolicy {
+	return LeasePolicy{QueueTimeout: 30 * time.Second}
+}
+
+func (lp LeasePolicy) normalize() LeasePolicy {
+	if lp.MaxConcurrent < 0 {
+		lp.MaxConcurrent = 0
+	}
+	if lp.QueueTimeout <= 0 {
+		lp.QueueTimeout = DefaultLeasePolicy().QueueTimeout
+	}
+	return lp
+}
+
+// limit 返回 Session 的有效并发上限，0 表示不限。
+func (p *Pool) limit(s *Session) int64 {
+	if n := s.Snapshot().MaxConcurrent; n > 0 {
+		return int64(n)
+	}
+	return int64(p.lease.MaxConcurrent)
+}
+
+// hasCapacity 报告 Session 是否还有空闲的并发名额。
+func (p *Pool) hasCapacity(s *Session) bool {
+	if s.rt == nil {
+		return true
+	}
+	limit := p.limit(s)
+	if limit == 0 {
+		return true
+	}
+	s.rt.leaseMu.Lock()
+	defer s.rt.leaseMu.Unlock()
+	return s.rt.waiters.Len() == 0 && s.rt.inFlight.Load() < limit
+}
+
+// Acquire 为一次上游调用租用 Session 的并发名额，返回的函数须在调用结束时执行。
+// 名额已满时按 FIFO 排队，等待超时或 ctx 结束时返回携带 Retry-After 的 503。
+func (p *Pool) Acquire(ctx context.Context, s *Session) (func(), error) {
+	if s == nil || s.rt == nil {
+		return func() {}, nil
+	}
+	rt := s.rt
+	limit := p.limit(s)
+
+	rt.leaseMu.Lock()
+	if limit == 0 || (rt.waiters.Len() == 0 && rt.inFlight.Load() < limit) {
+		rt.inFlight.Add(1)
+		rt.leaseMu.Unlock()
+		return p.releaser(s), nil
+	}
+	ready := make(chan struct{})
+	el := rt.waiters.PushBack(ready)
+	rt.leaseMu.Unlock()
+
+	timer := time.NewTimer(p.lease.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return p.releaser(s), nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	rt.leaseMu.Lock()
+	select {
+	case <-ready:
+		// 超时的同时恰好轮到自己，名额已转交过来，需要归还。
+		rt.leaseMu.Unlock()
+		p.releaser(s)()
+	default:
+		rt.waiters.Remove(el)
+		rt.leaseMu.Unlock()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, model.NewHTTPError(http.StatusServiceUnavailable, "session %s is busy (%d concurrent requests), queue wait timed out after %s", s.ID, limit, p.lease.QueueTimeout).
+		WithRetryAfter(p.lease.QueueTimeout)
+}
+
+// releaser 返回只生效一次的归还函数：名额依次转交给队首的等待者。
+func (p *Pool) releaser(s *Session) func() {
+	released := false
+	return func() {
+		rt := s.rt
+		rt.leaseMu.Lock()
+		defer rt.leaseMu.Unlock()
+		if released {
+			return
+		}
+		released = true
+		rt.inFlight.Add(-1)
+
+		// 并发上限可能被热加载调高，因此尽可能多地唤醒等待者。
+		limit := p.limit(s)
+		for rt.waiters.Len() > 0 && (limit == 0 || rt.inFlight.Load() < limit) {
+			ready := rt.waiters.Remove(rt.waiters.Front()).(chan struct{})
+			rt.inFlight.Add(1)
+			close(ready)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"DoubaoProxy/internal/model"
+)
+
+// leasePool 返回并发上限为 1 的单 Session 池，以及已占用唯一名额的 Session 与归还函数。
+func leasePool(t *testing.T, timeout time.Duration) (*Pool, *Session, func()) {
+	t.Helper()
+	p, _ := newTestPool(t, Options{Lease: LeasePolicy{MaxConcurrent: 1, QueueTimeout: timeout}}, testEntry("a"))
+	s, err := p.Session("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := p.Acquire(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, s, release
+}
+
+// queued 返回排队等待名额的请求数。
+func queued(s *Session) int {
+	s.rt.leaseMu.Lock()
+	defer s.rt.leaseMu.Unlock()
+	return s.rt.waiters.Len()
+}
+
+// waitQueued 等待直到恰好有 n 个请求在排队。
+func waitQueued(t *testing.T, s *Session, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for queued(s) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("queued = %d, want %d", queued(s), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAcquireServesWaitersInArrivalOrder(t *testing.T) {
+	p, s, release := leasePool(t, time.Minute)
+
+	const waiters = 5
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			done, err := p.Acquire(context.Background(), s)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			done()
+		}(i)
+		// 等前一个请求入队后再发起下一个，使到达顺序确定。
+		waitQueued(t, s, i+1)
+	}
+
+	release()
+	wg.Wait()
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("served order = %v, want arrival order", order)
+		}
+	}
+	if n := s.InFlight(); n != 0 {
+		t.Errorf("in flight = %d after all released", n)
+	}
+}
+
+func TestAcquireQueueTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	p, s, release := leasePool(t, timeout)
+	defer release()
+
+	start := time.Now()
+	_, err := p.Acquire(context.Background(), s)
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Errorf("gave up after %v, want at least %v", elapsed, timeout)
+	}
+	wantStatus(t, err, http.StatusServiceUnavailable)
+	var httpErr *model.HTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter != timeout {
+		t.Errorf("Retry-After = %v, want %v", httpErr.RetryAfter, timeout)
+	}
+	if n := queued(s); n != 0 {
+		t.Errorf("queued = %d after timeout, want 0", n)
+	}
+}
+
+func TestAcquireCancelRemovesWaiter(t *testing.T) {
+	p, s, release := leasePool(t, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := p.Acquire(ctx, s)
+		errc <- err
+	}()
+	waitQueued(t, s, 1)
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if n := queued(s); n != 0 {
+		t.Fatalf("queued = %d after cancel, want 0", n)
+	}
+
+	// 被取消的请求不会占走名额：归还后下一个请求立即获得名额。
+	release()
+	if n := s.InFlight(); n != 0 {
+		t.Fatalf("in flight = %d, want 0", n)
+	}
+	next, err := p.Acquire(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next()
+}
@@ -0,0 +1,139 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/store"
+)
+
+// 管理接口的修改保存在 Store 的 BucketOverrides 中，加载与热加载时叠加在配置之上，
+// 因此未开启写回、或条目来自由外部管理的配置目录时，修改同样能在重载与重启后保留。
+// 配置中的条目在修改之后又被改动（包括写回本身）时以配置为准，过时的修改随之丢弃。
+
+// configDigest 摘要配置中的条目，Store 中只记录摘要，用于判断配置是否改动过。
+func configDigest(s *Session) string {
+	data, _ := json.Marshal(s)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// overlay 把 Store 中的修改叠加到已校验的配置条目上：删除被管理接口删除的条目，
+// 覆盖权重、标签与启用状态，并追加由管理接口新增的条目。
+func (p *Pool) overlay(entries []*Session) []*Session {
+	overrides := make(map[string]store.Override)
+	err := p.store.ForEach(store.BucketOverrides, func(id string, value []byte) error {
+		var ov store.Override
+		if err := json.Unmarshal(value, &ov); err != nil {
+			slog.Warn("skip malformed session override", "session", id, "error", err)
+			return nil
+		}
+		overrides[id] = ov
+		return nil
+	})
+	if err != nil {
+		slog.Error("load session overrides failed", "error", err)
+		return entries
+	}
+
+	var stale []store.Op
+	out := make([]*Session, 0, len(entries))
+	present := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		present[entry.ID] = true
+		ov, ok := overrides[entry.ID]
+		switch {
+		case !ok:
+		case ov.Seed != entry.origin.seed:
+			stale = append(stale, store.DeleteOp(store.BucketOverrides, entry.ID))
+			slog.Info("session override dropped, config changed", "session", entry.ID)
+		case ov.Deleted:
+			continue
+		default:
+			entry.Weight, entry.Tags, entry.Disabled = ov.Weight, ov.Tags, ov.Disabled
+		}
+		out = append(out, entry)
+	}
+
+	ids := make([]string, 0, len(overrides))
+	for id := range overrides {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		ov := overrides[id]
+		switch {
+		case present[id]:
+		case ov.Entry == nil:
+			// 被删除的条目已从配置中移除，删除记录不再需要。
+			stale = append(stale, store.DeleteOp(store.BucketOverrides, id))
+		default:
+			var entry Session
+			if err := json.Unmarshal(ov.Entry, &entry); err != nil {
+				slog.Warn("skip malformed admin session", "session", id, "error", err)
+				continue
+			}
+			if err := entry.validate(); err != nil {
+				slog.Warn("skip invalid admin session", "session", id, "error", err)
+				continue
+			}
+			entry.ID = id
+			entry.origin = origin{admin: true}
+			out = append(out, &entry)
+		}
+	}
+
+	if len(stale) > 0 {
+		if err := p.store.Batch(stale...); err != nil {
+			slog.Warn("drop stale session overrides failed", "error", err)
+		}
+	}
+	return out
+}
+
+// overrideOp 返回把 Session 当前的管理配置保存到 Store 的操作。
+// 游客补充器等运行时加入的 Session 不需要保存，返回 false。
+func overrideOp(s *Session, now time.Time) (store.Op, bool, error) {
+	snap := s.Snapshot()
+	ov := store.Override{UpdatedAt: now}
+	switch {
+	case snap.origin.admin:
+		snap.rt = nil
+		data, err := json.Marshal(snap)
+		if err != nil {
+			return store.Op{}, false, err
+		}
+		ov.Entry = data
+	case snap.origin.seed != "":
+		ov.Seed, ov.Weight, ov.Tags, ov.Disabled = snap.origin.seed, snap.Weight, snap.Tags, snap.Disabled
+	default:
+		return store.Op{}, false, nil
+	}
+	op, err := store.PutJSONOp(store.BucketOverrides, snap.ID, ov)
+	return op, err == nil, err
+}
+
+// deletedOp 返回记录 Session 已被删除的操作：来自配置的条目写入删除记录，由管理接口新增的条目直接移除。
+func deletedOp(s *Session, now time.Time) (store.Op, bool, error) {
+	snap := s.Snapshot()
+	switch {
+	case snap.origin.admin:
+		return store.DeleteOp(store.BucketOverrides, snap.ID), true, nil
+	case snap.origin.seed != "":
+		op, err := store.PutJSONOp(store.BucketOverrides, snap.ID, store.Override{Seed: snap.origin.seed, Deleted: true, UpdatedAt: now})
+		return op, err == nil, err
+	default:
+		return store.Op{}, false, nil
+	}
+}
+
+// errNotSaved 包装保存修改时的错误：修改已在内存中生效，但重载或重启后会丢失。
+func errNotSaved(id string, err error) error {
+	return model.NewHTTPError(http.StatusInternalServerError, "session %s changed but not saved: %s", id, err.Error())
+}
@@ -0,0 +1,163 @@
+package session
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"DoubaoProxy/internal/store"
+)
+
+func reload(t *testing.T, p *Pool) {
+	t.Helper()
+	if _, err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPatchSurvivesReloadAndRestart(t *testing.T) {
+	st := store.NewMemory()
+	p, path := newTestPool(t, Options{Store: st}, testEntry("a"), testEntry("b"))
+	weight, enabled := 5, false
+	if _, err := p.PatchSession("a", Patch{Weight: &weight, Enabled: &enabled}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(p *Pool, when string) {
+		t.Helper()
+		s, err := p.Session("a")
+		if err != nil {
+			t.Fatalf("%s: %v", when, err)
+		}
+		if snap := s.Snapshot(); snap.Weight != 5 || !snap.Disabled {
+			t.Errorf("%s: weight = %d, disabled = %v", when, snap.Weight, snap.Disabled)
+		}
+	}
+	reload(t, p)
+	check(p, "after reload")
+	restarted, err := NewPool(path, Options{Store: st})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(restarted, "after restart")
+
+	// 配置中的条目随后被改动时以配置为准。
+	a := testEntry("a")
+	a.Cookie = "sessionid=rotated"
+	writeTestConfig(t, path, a, testEntry("b"))
+	reload(t, p)
+	s, _ := p.Session("a")
+	if snap := s.Snapshot(); snap.Weight != 0 || snap.Disabled {
+		t.Errorf("stale override applied: weight = %d, disabled = %v", snap.Weight, snap.Disabled)
+	}
+	if _, err := st.Get(store.BucketOverrides, "a"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("stale override kept: %v", err)
+	}
+}
+
+func TestCreatedSessionSurvivesReloadAndRestart(t *testing.T) {
+	st := store.NewMemory()
+	p, path := newTestPool(t, Options{Store: st}, testEntry("a"))
+	if _, err := p.CreateSession(testEntry("c")); err != nil {
+		t.Fatal(err)
+	}
+	reload(t, p)
+	if _, err := p.Session("c"); err != nil {
+		t.Fatalf("created session lost on reload: %v", err)
+	}
+	restarted, err := NewPool(path, Options{Store: st})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Session("c"); err != nil {
+		t.Fatalf("created session lost on restart: %v", err)
+	}
+
+	if err := p.DeleteSession("c"); err != nil {
+		t.Fatal(err)
+	}
+	reload(t, p)
+	if _, err := p.Session("c"); err == nil {
+		t.Error("deleted session came back on reload")
+	}
+}
+
+func TestRuntimeSessionIsNotSaved(t *testing.T) {
+	st := store.NewMemory()
+	p, _ := newTestPool(t, Options{Store: st}, testEntry("a"))
+	if _, err := p.AddSession(testEntry("g")); err != nil {
+		t.Fatal(err)
+	}
+	weight := 3
+	if _, err := p.PatchSession("g", Patch{Weight: &weight}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DeleteSession("g"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get(store.BucketOverrides, "g"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("runtime session saved: %v", err)
+	}
+}
+
+func TestDeletedDirectorySessionStaysDeleted(t *testing.T) {
+	st := store.NewMemory()
+	dir := t.TempDir()
+	writeTestConfig(t, filepath.Join(dir, "b.json"), testEntry("b"))
+	p, path := newTestPool(t, Options{Store: st, ConfigDir: dir}, testEntry("a"))
+
+	if err := p.DeleteSession("b"); err != nil {
+		t.Fatal(err)
+	}
+	reload(t, p)
+	if _, err := p.Session("b"); err == nil {
+		t.Fatal("deleted session came back on reload")
+	}
+	restarted, err := NewPool(path, Options{Store: st, ConfigDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Session("b"); err == nil {
+		t.Fatal("deleted session came back on restart")
+	}
+
+	// 条目从配置中移除后，删除记录随之清理，之后重新加入的同名条目照常加载。
+	if err := os.Remove(filepath.Join(dir, "b.json")); err != nil {
+		t.Fatal(err)
+	}
+	reload(t, p)
+	if _, err := st.Get(store.BucketOverrides, "b"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("delete record kept: %v", err)
+	}
+	writeTestConfig(t, filepath.Join(dir, "b.json"), testEntry("b"))
+	reload(t, p)
+	if _, err := p.Session("b"); err != nil {
+		t.Errorf("re-added session not loaded: %v", err)
+	}
+}
+
+func TestSaveConfigSkipsRuntimeSessions(t *testing.T) {
+	p, path := newTestPool(t, Options{}, testEntry("a"))
+	if _, err := p.AddSession(testEntry("g")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.CreateSession(testEntry("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readEntries(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	if want := []string{"a", "c"}; !slices.Equal(ids, want) {
+		t.Errorf("saved ids = %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,362 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"DoubaoProxy/internal/model"
+)
+
+// Pacing 控制单个 Session 发往上游的节奏，使流量更接近真人使用。零值字段沿用 PacingPolicy 中的全局默认值。
+type Pacing struct {
+	// MinIntervalMS 为两条消息之间的最短间隔，单位毫秒。
+	MinIntervalMS int `json:"min_interval_ms,omitempty"`
+	// JitterMS 为在最短间隔之上额外附加的随机延迟上限，单位毫秒。
+	JitterMS int `json:"jitter_ms,omitempty"`
+	// RatePerMinute 为令牌桶每分钟补充的令牌数，即长期的请求速率上限。
+	RatePerMinute float64 `json:"rate_per_minute,omitempty"`
+	// Burst 为令牌桶容量，即允许的突发请求数。
+	Burst int `json:"burst,omitempty"`
+	// QuietHours 为静默时段，格式 HH:MM-HH:MM，可跨越午夜；该时段内不发起请求。
+	QuietHours string `json:"quiet_hours,omitempty"`
+	// Timezone 为静默时段所用的 IANA 时区，留空使用服务器本地时区。
+	Timezone string `json:"timezone,omitempty"`
+}
+
+func (pc *Pacing) validate() error {
+	if pc == nil {
+		return nil
+	}
+	if pc.MinIntervalMS < 0 || pc.JitterMS < 0 || pc.RatePerMinute < 0 || pc.Burst < 0 {
+		return errors.New("pacing values must not be negative")
+	}
+	if _, err := pc.quietHours(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// quietHours 解析静默时段，未配置时返回 nil。
+func (pc *Pacing) quietHours() (*quietHours, error) {
+	if pc == nil {
+		return nil, nil
+	}
+	return parseQuietHours(pc.QuietHours, pc.Timezone)
+}
+
+// PacingPolicy 是节奏控制的全局配置，Session 的 pacing 字段可逐项覆盖前四项。
+type PacingPolicy struct {
+	MinInterval   time.Duration
+	Jitter        time.Duration
+	RatePerMinute float64
+	Burst         int
+	// GlobalRPS 为全部 Session 合计每秒发往上游的请求上限，0 表示不限。
+	GlobalRPS float64
+}
+
+// PaceKind 区分需要遵守哪些节奏限制。
+type PaceKind int
+
+const (
+	// PaceMessage 是一条聊天消息，额外受最短间隔约束。
+	PaceMessage PaceKind = iota
+	// PaceRequest 是上传、删除等其他请求，只受令牌桶、静默时段与全局上限约束。
+	PaceRequest
+)
+
+// pacingMetrics 通过 expvar 暴露节奏控制造成的等待与拒绝。
+var pacingMetrics = expvar.NewMap("session_pacing")
+
+// paceState 是 Session 的节奏状态，时间均为预约制：请求先预约发送时刻，再等待到该时刻。
+type paceState struct {
+	mu sync.Mutex
+	// next 为下一条消息最早可以发送的时刻。
+	next time.Time
+	// bucket 为令牌桶的理论到达时刻（GCRA）。
+	bucket time.Time
+}
+
+// rpsLimiter 是全部 Session 共用的全局令牌桶。
+type rpsLimiter struct {
+	mu  sync.Mutex
+	tat time.Time
+}
+
+// gcra 以通用信元速率算法实现令牌桶：返回 at 之后最早可发送的时刻以及发送后新的理论到达时刻。
+func gcra(tat, at time.Time, interval time.Duration, burst int) (allowed, next time.Time) {
+	if burst < 1 {
+		burst = 1
+	}
+	allowed = at
+	if earliest := tat.Add(-time.Duration(burst-1) * interval); earliest.After(allowed) {
+		allowed = earliest
+	}
+	next = tat
+	if allowed.After(next) {
+		next = allowed
+	}
+	return allowed, next.Add(interval)
+}
+
+// resolvedPacing 是合并了全局默认值后的 Session 节奏配置。
+type resolvedPacing struct {
+	minInterval time.Duration
+	jitter      time.Duration
+	interval    time.Duration // 令牌桶补充一个令牌的间隔，0 表示不限
+	burst       int
+	quiet       *quietHours
+}
+
+func (p *Pool) resolvePacing(pc *Pacing, quiet *quietHours) resolvedPacing {
+	out := resolvedPacing{
+		minInterval: p.pacing.MinInterval,
+		jitter:      p.pacing.Jitter,
+		burst:       p.pacing.Burst,
+		quiet:       quiet,
+	}
+	rate := p.pacing.RatePerMinute
+	if pc != nil {
+		if pc.MinIntervalMS > 0 {
+			out.minInterval = time.Duration(pc.MinIntervalMS) * time.Millisecond
+		}
+		if pc.JitterMS > 0 {
+			out.jitter = time.Duration(pc.JitterMS) * time.Millisecond
+		}
+		if pc.RatePerMinute > 0 {
+			rate = pc.RatePerMinute
+		}
+		if pc.Burst > 0 {
+			out.burst = pc.Burst
+		}
+	}
+	if rate > 0 {
+		out.interval = time.Duration(float64(time.Minute) / rate)
+	}
+	return out
+}
+
+// Pace 按 Session 与全局的节奏限制为一次上游请求预约发送时刻，并等待到该时刻。
+// 预约时刻晚于 ctx 的截止时间（未设置时为排队超时 QueueTimeout 之后）时不等待，直接返回携带 Retry-After 的 503。
+// 等待期间 ctx 结束时撤销预约，不占用后续请求的额度。调用方应先取得并发名额再调用 Pace，
+// 以免排队超时的请求留下预约。
+func (p *Pool) Pace(ctx context.Context, s *Session, kind PaceKind) error {
+	if s == nil || s.rt == nil {
+		return p.PaceGlobal(ctx)
+	}
+	pc, quiet := s.pacing()
+	cfg := p.resolvePacing(pc, quiet)
+	now := time.Now()
+
+	st := &s.rt.pace
+	st.mu.Lock()
+	at, reason := now, ""
+	if end, quiet := cfg.quiet.until(now); quiet {
+		at, reason = end, "quiet_hours"
+	}
+	if kind == PaceMessage && st.next.After(at) {
+		at, reason = st.next, "min_interval"
+	}
+	bucket := st.bucket
+	if cfg.interval > 0 {
+		var allowed time.Time
+		if allowed, bucket = gcra(st.bucket, at, cfg.interval, cfg.burst); allowed.After(at) {
+			at, reason = allowed, "token_bucket"
+		}
+	}
+
+	p.rps.mu.Lock()
+	global := p.rps.tat
+	if p.pacing.GlobalRPS > 0 {
+		var allowed time.Time
+		interval := time.Duration(float64(time.Second) / p.pacing.GlobalRPS)
+		burst := max(int(p.pacing.GlobalRPS), 1)
+		if allowed, global = gcra(p.rps.tat, at, interval, burst); allowed.After(at) {
+			at, reason = allowed, "global_rps"
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = now.Add(p.lease.QueueTimeout)
+	}
+	if at.After(deadline) {
+		p.rps.mu.Unlock()
+		st.mu.Unlock()
+		pacingMetrics.Add("rejected", 1)
+		return model.NewHTTPError(http.StatusServiceUnavailable, "session %s is paced (%s) until %s, beyond the request deadline", s.ID, reason, at.Format(time.RFC3339)).
+			WithRetryAfter(at.Sub(now))
+	}
+	prevGlobal, prevBucket, prevNext := p.rps.tat, st.bucket, st.next
+	p.rps.tat = global
+	p.rps.mu.Unlock()
+	st.bucket = bucket
+	if kind == PaceMessage {
+		st.next = at.Add(cfg.minInterval)
+		if cfg.jitter > 0 {
+			st.next = st.next.Add(rand.N(cfg.jitter))
+		}
+	}
+	next := st.next
+	st.mu.Unlock()
+
+	if err := p.wait(ctx, at.Sub(now), reason); err != nil {
+		// 只有在此后没有新的预约时才能原样回退；否则保留，后续预约仍是保守的。
+		st.mu.Lock()
+		if st.bucket.Equal(bucket) {
+			st.bucket = prevBucket
+		}
+		if kind == PaceMessage && st.next.Equal(next) {
+			st.next = prevNext
+		}
+		st.mu.Unlock()
+		p.rps.mu.Lock()
+		if p.rps.tat.Equal(global) {
+			p.rps.tat = prevGlobal
+		}
+		p.rps.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// PaceGlobal 只按全局上限为一次不属于任何 Session 的上游请求（例如申请游客身份）预约并等待。
+func (p *Pool) PaceGlobal(ctx context.Context) error {
+	if p.pacing.GlobalRPS <= 0 {
+		return nil
+	}
+	now := time.Now()
+	interval := time.Duration(float64(time.Second) / p.pacing.GlobalRPS)
+	burst := max(int(p.pacing.GlobalRPS), 1)
+
+	p.rps.mu.Lock()
+	at, global := gcra(p.rps.tat, now, interval, burst)
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = now.Add(p.lease.QueueTimeout)
+	}
+	if at.After(deadline) {
+		p.rps.mu.Unlock()
+		pacingMetrics.Add("rejected", 1)
+		return model.NewHTTPError(http.StatusServiceUnavailable, "upstream requests are paced (global_rps) until %s, beyond the request deadline", at.Format(time.RFC3339)).
+			WithRetryAfter(at.Sub(now))
+	}
+	prev := p.rps.tat
+	p.rps.tat = global
+	p.rps.mu.Unlock()
+
+	if err := p.wait(ctx, at.Sub(now), "global_rps"); err != nil {
+		p.rps.mu.Lock()
+		if p.rps.tat.Equal(global) {
+			p.rps.tat = prev
+		}
+		p.rps.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// wait 等待 d 并记录指标，ctx 先结束时返回其错误。
+func (p *Pool) wait(ctx context.Context, d time.Duration, reason string) error {
+	if d <= 0 {
+		return nil
+	}
+	pacingMetrics.Add("delayed", 1)
+	pacingMetrics.Add("delayed_"+reason, 1)
+	pacingMetrics.Add("wait_ms_total", d.Milliseconds())
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		pacingMetrics.Add("cancelled", 1)
+		return ctx.Err()
+	}
+}
+
+// pacing 返回 Session 的节奏配置与加载时解析好的静默时段。
+func (s *Session) pacing() (*Pacing, *quietHours) {
+	if s.rt == nil {
+		return s.Pacing, nil
+	}
+	s.rt.mu.RLock()
+	defer s.rt.mu.RUnlock()
+	return s.Pacing, s.rt.quiet
+}
+
+// quiet 报告 Session 当前是否处于静默时段。
+func (p *Pool) quiet(s *Session, now time.Time) bool {
+	_, q := s.pacing()
+	_, quiet := q.until(now)
+	return quiet
+}
+
+// quietHours 是每天重复的静默时段，start 与 end 为自零点起的分钟数。
+type quietHours struct {
+	start, end int
+	loc        *time.Location
+}
+
+// parseQuietHours 解析 HH:MM-HH:MM 格式的静默时段，空串返回 nil。
+func parseQuietHours(spec, timezone string) (*quietHours, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("quiet_hours %q: want HH:MM-HH:MM", spec)
+	}
+	q := &quietHours{loc: time.Local}
+	var err error
+	if q.start, err = parseClock(from); err != nil {
+		return nil, fmt.Errorf("quiet_hours %q: %w", spec, err)
+	}
+	if q.end, err = parseClock(to); err != nil {
+		return nil, fmt.Errorf("quiet_hours %q: %w", spec, err)
+	}
+	if q.start == q.end {
+		return nil, fmt.Errorf("quiet_hours %q: start and end must differ", spec)
+	}
+	if timezone != "" {
+		if q.loc, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("pacing timezone %q: %w", timezone, err)
+		}
+	}
+	return q, nil
+}
+
+func parseClock(raw string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", raw)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// until 报告 now 是否处于静默时段，是则同时返回时段结束的时刻。
+func (q *quietHours) until(now time.Time) (time.Time, bool) {
+	if q == nil {
+		return time.Time{}, false
+	}
+	local := now.In(q.loc)
+	minute := local.Hour()*60 + local.Minute()
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, q.loc)
+	switch {
+	case q.start < q.end && minute >= q.start && minute < q.end:
+		return midnight.Add(time.Duration(q.end) * time.Minute), true
+	case q.start > q.end && minute >= q.start:
+		return midnight.AddDate(0, 0, 1).Add(time.Duration(q.end) * time.Minute), true
+	case q.start > q.end && minute < q.end:
+		return midnight.Add(time.Duration(q.end) * time.Minute), true
+	default:
+		return time.Time{}, false
+	}
+}
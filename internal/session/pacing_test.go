@@ -0,0 +1,106 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// quietAround 返回覆盖 now 前后一小时的 UTC 静默时段。
+func quietAround(now time.Time) *Pacing {
+	now = now.UTC()
+	return &Pacing{
+		QuietHours: now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04"),
+		Timezone:   "UTC",
+	}
+}
+
+func TestQuietHoursParsedOnLoadAndReload(t *testing.T) {
+	now := time.Now()
+	a := testEntry("a")
+	a.Pacing = quietAround(now)
+	p, path := newTestPool(t, Options{}, a)
+
+	s, _ := p.Session("a")
+	if s.rt.quiet == nil || s.rt.quiet.loc != time.UTC {
+		t.Fatalf("quiet hours not resolved on load: %+v", s.rt.quiet)
+	}
+	if !p.quiet(s, now) {
+		t.Error("session not quiet inside its quiet hours")
+	}
+
+	a.Pacing = nil
+	writeTestConfig(t, path, a)
+	if _, err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if s.rt.quiet != nil || p.quiet(s, now) {
+		t.Error("quiet hours kept after reload removed them")
+	}
+
+	a.Pacing = quietAround(now)
+	writeTestConfig(t, path, a)
+	if _, err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.quiet(s, now) {
+		t.Error("quiet hours not applied after reload")
+	}
+}
+
+func TestQuietSessionsServeOnlyAsLastResort(t *testing.T) {
+	now := time.Now()
+	a, b := testEntry("a"), testEntry("b")
+	a.Pacing = quietAround(now)
+	p, _ := newTestPool(t, Options{}, a, b)
+
+	for i := 0; i < 20; i++ {
+		s, err := p.GetSession(Criteria{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.ID != "b" {
+			t.Fatalf("picked quiet session %s", s.ID)
+		}
+	}
+}
+
+func TestCancelledPaceReleasesReservation(t *testing.T) {
+	p, _ := newTestPool(t, Options{Pacing: PacingPolicy{MinInterval: time.Second, GlobalRPS: 1}}, testEntry("a"))
+	s, _ := p.Session("a")
+	if err := p.Pace(context.Background(), s, PaceMessage); err != nil {
+		t.Fatal(err)
+	}
+	next, global := s.rt.pace.next, p.rps.tat
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ctx, stop := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, stop)
+	if err := p.Pace(ctx, s, PaceMessage); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if !s.rt.pace.next.Equal(next) || !p.rps.tat.Equal(global) {
+		t.Error("cancelled reservation still holds the slot")
+	}
+}
+
+func TestCancelledGlobalPaceReleasesReservation(t *testing.T) {
+	p, _ := newTestPool(t, Options{Pacing: PacingPolicy{GlobalRPS: 1}}, testEntry("a"))
+	if err := p.PaceGlobal(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	global := p.rps.tat
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ctx, stop := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, stop)
+	if err := p.PaceGlobal(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if !p.rps.tat.Equal(global) {
+		t.Error("cancelled reservation still holds the slot")
+	}
+}
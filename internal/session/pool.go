@@ -1,130 +1,383 @@
 package session
 
 import (
-	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"log/slog"
-	"math/rand"
+	"net/http"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/sealed"
+	"DoubaoProxy/internal/store"
 )
 
-// Session 保存调用豆包接口所需的一组凭证。
-type Session struct {
-	Cookie     string `json:"cookie"`
-	DeviceID   string `json:"device_id"`
-	TeaUUID    string `json:"tea_uuid"`
-	WebID      string `json:"web_id"`
-	RoomID     string `json:"room_id"`
-	XFlowTrace string `json:"x_flow_trace"`
-	Guest      bool   `json:"guest,omitempty"`
-}
-
-func (s *Session) validate() error {
-	switch {
-	case s == nil:
-		return errors.New("nil session")
-	case s.Cookie == "":
-		return errors.New("cookie is required")
-	case s.DeviceID == "":
-		return errors.New("device_id is required")
-	case s.TeaUUID == "":
-		return errors.New("tea_uuid is required")
-	case s.WebID == "":
-		return errors.New("web_id is required")
-	case s.RoomID == "":
-		return errors.New("room_id is required")
-	case s.XFlowTrace == "":
-		return errors.New("x_flow_trace is required")
-	default:
-		return nil
-	}
-}
-
 // Pool 负责管理所有 Session 并维护与会话 ID 的关联关系。
+// 会话绑定保存在 Store 中，以 Session ID 引用。
 type Pool struct {
-	mu              sync.RWMutex
-	configPath      string
-	conversationMap map[string]*Session
-	authSessions    []*Session
-	guestSessions   []*Session
-	rng             *rand.Rand
+	mu            sync.RWMutex
+	configPath    string
+	configDir     string
+	bindings      *bindingTable
+	usage         *usageTable
+	store         store.Store
+	authSessions  []*Session
+	guestSessions []*Session
+	selector      Selector
+	health        HealthPolicy
+	lease         LeasePolicy
+	pacing        PacingPolicy
+	rps           rpsLimiter
+	key           *sealed.Key
+	// now 返回当前时间，分配与用量计数经由它取时，测试可以替换。
+	now func() time.Time
+}
+
+// Options 是会话池的可选配置。
+type Options struct {
+	// Selector 为新会话挑选 Session 的策略，为空时使用随机策略。
+	Selector Selector
+	// Health 控制冷却退避与判定阈值，零值字段使用默认值。
+	Health HealthPolicy
+	// Store 保存会话绑定、用量计数与上游轮换后的 Cookie，为空时使用内存存储。
+	Store store.Store
+	// Bindings 控制会话绑定的空闲过期与数量上限，零值字段使用默认值。
+	Bindings BindingPolicy
+	// Lease 控制每个 Session 的并发上限与排队超时。
+	Lease LeasePolicy
+	// Pacing 控制请求节奏的全局默认值与全局每秒请求上限。
+	Pacing PacingPolicy
+	// ConfigDir 非空时，目录中的 *.json 文件与主配置文件一起组成 Session 池。
+	ConfigDir string
+	// Key 非空时，加密的配置文件用它解密，写回时也用它加密。
+	Key *sealed.Key
 }
 
 // NewPool 根据配置文件初始化会话池。
-func NewPool(configPath string) (*Pool, error) {
+func NewPool(configPath string, opts Options) (*Pool, error) {
+	if opts.Selector == nil {
+		opts.Selector = newRandomSelector()
+	}
+	if opts.Store == nil {
+		opts.Store = store.NewMemory()
+	}
 	p := &Pool{
-		configPath:      configPath,
-		conversationMap: make(map[string]*Session),
-		rng:             rand.New(rand.NewSource(time.Now().UnixNano())),
+		configPath: configPath,
+		configDir:  opts.ConfigDir,
+		bindings:   newBindingTable(opts.Store, opts.Bindings),
+		usage:      newUsageTable(opts.Store),
+		store:      opts.Store,
+		selector:   opts.Selector,
+		health:     opts.Health.normalize(),
+		lease:      opts.Lease.normalize(),
+		pacing:     opts.Pacing,
+		key:        opts.Key,
+		now:        time.Now,
 	}
 	if err := p.loadFromFile(); err != nil {
 		return nil, err
 	}
+	if err := p.bindings.load(time.Now()); err != nil {
+		return nil, fmt.Errorf("load conversation bindings: %w", err)
+	}
+	bindingMetrics.Set("active", expvar.Func(func() any { return p.bindings.len() }))
+	// 持久化的绑定可能指向已从配置中删除的 Session。
+	p.mu.Lock()
+	if n := p.pruneBindings(func(id string) bool { return p.find(id) != nil }); n > 0 {
+		slog.Info("dropped stale conversation bindings", "count", n)
+	}
+	p.mu.Unlock()
 	return p, nil
 }
 
-// GetSession 返回指定会话 ID 对应的 Session，若未找到则按类型随机挑选一份。
-func (p *Pool) GetSession(conversationID string, guest bool) (*Session, error) {
+// Criteria 描述挑选 Session 的条件。
+type Criteria struct {
+	// ConversationID 非空且已绑定时直接返回对应 Session，绑定已过期时返回 410。
+	ConversationID string
+	Guest          bool
+	// Provider 限定上游后端，留空表示不限。
+	Provider string
+	// Quota 指定需要检查的额度，额度已用尽的 Session 不会被分配。
+	Quota QuotaCheck
+	// Exclude 为不参与挑选的 Session ID，用于故障转移时跳过已失败的 Session。
+	Exclude []string
+	// Affinity 为客户端标识，非空时新会话按一致性哈希固定落在同一个 Session 上，
+	// 该 Session 不可用时依次退到哈希得分次高的 Session。
+	Affinity string
+	// Tags 要求新会话使用的 Session 带有其中全部标签。
+	Tags []string
+	// Pin 指定新会话使用的 Session ID，忽略 Guest、Provider 与 Tags 条件，不可用时直接报错。
+	Pin string
+}
+
+// GetSession 返回指定会话 ID 对应的 Session，若未找到则按选择策略挑选一份。
+func (p *Pool) GetSession(c Criteria) (*Session, error) {
+	now := p.now()
+	// 绑定查找可能读取 Store，在获取池锁之前完成。
+	var boundID string
+	if c.ConversationID != "" {
+		id, err := p.bindings.lookup(c.ConversationID, now)
+		if err != nil {
+			return nil, err
+		}
+		boundID = id
+	}
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	if conversationID != "" {
-		if session, ok := p.conversationMap[conversationID]; ok {
-			return session, nil
+	if boundID != "" {
+		session, err := p.boundSession(c.ConversationID, boundID)
+		if err != nil {
+			return nil, err
+		}
+		if err := boundUnavailable(session, now); err != nil {
+			return nil, err
 		}
+		if err := p.quotaExhausted(session, c.Quota, now); err != nil {
+			return nil, err
+		}
+		return session, nil
+	}
+
+	if c.Pin != "" {
+		return p.pinnedSession(c, now)
 	}
 
 	sessions := p.authSessions
-	if guest {
+	if c.Guest {
 		sessions = p.guestSessions
 	}
+	if c.Provider != "" {
+		sessions = filterProvider(sessions, c.Provider)
+	}
+	kind := "authenticated"
+	if c.Guest {
+		kind = "guest"
+	}
+	if len(c.Tags) > 0 {
+		if sessions = filterTags(sessions, c.Tags); len(sessions) == 0 {
+			return nil, model.NewHTTPError(http.StatusNotFound, "no %s sessions tagged %s", kind, strings.Join(c.Tags, ", "))
+		}
+	}
+	if len(sessions) > 0 && len(c.Exclude) > 0 {
+		if sessions = filterExclude(sessions, c.Exclude); len(sessions) == 0 {
+			return nil, model.NewHTTPError(http.StatusServiceUnavailable, "no untried %s sessions left", kind)
+		}
+	}
 	if len(sessions) == 0 {
-		if guest {
-			return nil, model.NewHTTPError(404, "no guest sessions configured")
+		if c.Provider != "" {
+			return nil, model.NewHTTPError(404, "no %s sessions configured for provider %s", kind, c.Provider)
 		}
-		return nil, model.NewHTTPError(404, "no authenticated sessions configured")
+		return nil, model.NewHTTPError(404, "no %s sessions configured", kind)
+	}
+
+	sessions, resetAt := p.filterQuota(sessions, c.Quota, now)
+	if len(sessions) == 0 {
+		return nil, model.NewHTTPError(http.StatusTooManyRequests, "all %s sessions have reached their %s quota until %s", kind, c.Quota, resetAt.Format(time.RFC3339)).
+			WithRetryAfter(resetAt.Sub(now))
+	}
+
+	candidates := filterAvailable(sessions, now)
+	if len(candidates) == 0 {
+		return nil, model.NewHTTPError(http.StatusServiceUnavailable, "all %s sessions are disabled, draining, cooling down or dead", kind)
+	}
+	// 处于静默时段的 Session 只在别无选择时才分配，请求会等到时段结束或截止时间。
+	if awake := p.filterAwake(candidates, now); len(awake) > 0 {
+		candidates = awake
+	}
+	// 亲和性优先于空闲名额：首选 Session 仅是繁忙时排队等待，而不是换到其他账号。
+	if c.Affinity != "" {
+		return rendezvous(c.Affinity, candidates), nil
+	}
+	// 优先分配有空闲并发名额的 Session；全部占满时仍从中挑选，由 Acquire 排队。
+	if free := p.filterCapacity(candidates); len(free) > 0 {
+		candidates = free
+	}
+	return p.selector.Select(candidates), nil
+}
+
+func (p *Pool) filterAwake(sessions []*Session, now time.Time) []*Session {
+	out := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		if !p.quiet(s, now) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (p *Pool) filterCapacity(sessions []*Session) []*Session {
+	out := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		if p.hasCapacity(s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// filterAvailable 返回可接收新会话的 Session：存在 healthy 时只用 healthy，否则退而使用 suspect。
+func filterAvailable(sessions []*Session, now time.Time) []*Session {
+	healthy := make([]*Session, 0, len(sessions))
+	var suspect []*Session
+	for _, s := range sessions {
+		if !s.acceptsNew() {
+			continue
+		}
+		switch s.state(now) {
+		case StateHealthy:
+			healthy = append(healthy, s)
+		case StateSuspect:
+			suspect = append(suspect, s)
+		}
+	}
+	if len(healthy) > 0 {
+		return healthy
+	}
+	return suspect
+}
+
+// boundUnavailable 检查已绑定会话的 Session 能否继续服务；冷却或失效时返回 503。
+func boundUnavailable(s *Session, now time.Time) error {
+	if s.rt == nil {
+		return nil
+	}
+	if s.Snapshot().Disabled {
+		return model.NewHTTPError(http.StatusServiceUnavailable, "session %s for this conversation is disabled", s.ID)
+	}
+	state, reason, _, until, _ := s.rt.health.snapshot(now)
+	switch state {
+	case StateCooling:
+		return model.NewHTTPError(http.StatusServiceUnavailable, "session %s for this conversation is cooling down until %s (%s)", s.ID, until.Format(time.RFC3339), reason).
+			WithRetryAfter(until.Sub(now))
+	case StateDead:
+		return model.NewHTTPError(http.StatusServiceUnavailable, "session %s for this conversation is unavailable (%s)", s.ID, reason)
+	default:
+		return nil
 	}
-	session := sessions[p.rng.Intn(len(sessions))]
-	return session, nil
 }
 
-// BindConversation 将会话 ID 与具体 Session 绑定。
-func (p *Pool) BindConversation(conversationID string, s *Session) {
-	if conversationID == "" || s == nil {
+// ReportSuccess 记录一次成功的上游调用，使 Session 恢复为 healthy。
+func (p *Pool) ReportSuccess(s *Session) {
+	if s == nil || s.rt == nil {
 		return
 	}
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.conversationMap[conversationID] = s
+	if s.rt.health.success(time.Now()) {
+		slog.Info("session recovered", "session", s.ID)
+	}
 }
 
-// ForgetConversation 移除会话 ID 与 Session 的映射。
-func (p *Pool) ForgetConversation(conversationID string) {
-	if conversationID == "" {
+// ReportFailure 记录一次上游失败，并按失败类别推进 Session 的健康状态。
+func (p *Pool) ReportFailure(s *Session, kind FailureKind, reason string) {
+	if s == nil || s.rt == nil {
 		return
 	}
-	p.mu.Lock()
-	delete(p.conversationMap, conversationID)
-	p.mu.Unlock()
+	state, cooldown := s.rt.health.failure(kind, reason, p.health, time.Now())
+	switch state {
+	case StateCooling:
+		slog.Warn("session cooling down", "session", s.ID, "kind", kind.String(), "reason", reason, "cooldown", cooldown.String())
+	case StateDead:
+		slog.Warn("session marked dead", "session", s.ID, "kind", kind.String(), "reason", reason)
+	default:
+		slog.Info("session marked suspect", "session", s.ID, "kind", kind.String(), "reason", reason)
+	}
+}
+
+// Statuses 返回全部 Session 的健康状态快照。
+func (p *Pool) Statuses() []Status {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := time.Now()
+	out := make([]Status, 0, len(p.authSessions)+len(p.guestSessions))
+	for _, group := range [][]*Session{p.authSessions, p.guestSessions} {
+		for _, s := range group {
+			out = append(out, s.status(now))
+		}
+	}
+	return out
+}
+
+// ObserveTTFB 记录一次上游首字节耗时，供延迟感知策略使用。
+func (p *Pool) ObserveTTFB(s *Session, d time.Duration) {
+	if s == nil || s.rt == nil || d <= 0 {
+		return
+	}
+	s.observeTTFB(d)
+}
+
+func filterExclude(sessions []*Session, ids []string) []*Session {
+	out := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		if !slices.Contains(ids, s.ID) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// filterTags 返回带有 tags 中全部标签的 Session。
+func filterTags(sessions []*Session, tags []string) []*Session {
+	out := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		if s.hasTags(tags) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// pinnedSession 返回 c.Pin 指定的 Session。排空中的 Session 仍可被指定，禁用、冷却或失效时返回 503。调用方须持有 mu。
+func (p *Pool) pinnedSession(c Criteria, now time.Time) (*Session, error) {
+	s := p.find(c.Pin)
+	if s == nil {
+		return nil, model.NewHTTPError(http.StatusNotFound, "pinned session %s not found", c.Pin)
+	}
+	if slices.Contains(c.Exclude, s.ID) {
+		return nil, model.NewHTTPError(http.StatusServiceUnavailable, "pinned session %s already failed", s.ID)
+	}
+	if err := boundUnavailable(s, now); err != nil {
+		return nil, err
+	}
+	if err := p.quotaExhausted(s, c.Quota, now); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-// RemoveSession 将失效的 Session 从池中剔除（例如豆包封禁该凭证时）。
+func filterProvider(sessions []*Session, name string) []*Session {
+	out := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		if s.ProviderName() == name {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// RemoveSession 将 Session 从池中彻底剔除。临时性的失败应通过 ReportFailure 进入冷却。
 func (p *Pool) RemoveSession(target *Session) {
 	if target == nil {
 		return
 	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.removeLocked(target)
+}
+
+// removeLocked 将 Session 从池中剔除。调用方须持有 mu。
+func (p *Pool) removeLocked(target *Session) {
 	slice := &p.authSessions
 	if target.Guest {
 		slice = &p.guestSessions
 	}
-	filtered := (*slice)[:0]
+	filtered := make([]*Session, 0, len(*slice))
 	for _, s := range *slice {
 		if s != target {
 			filtered = append(filtered, s)
@@ -134,34 +387,41 @@ func (p *Pool) RemoveSession(target *Session) {
 }
 
 func (p *Pool) loadFromFile() error {
-	file, err := os.Open(p.configPath)
+	entries, err := readSources(p.configPath, p.configDir, p.key)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			slog.Warn("session config file not found", "path", p.configPath)
-			return nil
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
 		}
-		return fmt.Errorf("open session config: %w", err)
-	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
-	decoder.DisallowUnknownFields()
-
-	var entries []Session
-	if err := decoder.Decode(&entries); err != nil {
-		return fmt.Errorf("decode session config: %w", err)
+		// 管理接口新增的 Session 保存在 Store 中，配置文件不存在时仍然加载。
+		slog.Warn("session config file not found", "path", p.configPath)
 	}
 
-	for _, entry := range entries {
-		entry := entry
+	seen := make(map[string]string, len(entries))
+	valid := make([]*Session, 0, len(entries))
+	for i := range entries {
+		entry := &entries[i]
 		if err := entry.validate(); err != nil {
-			slog.Warn("skip invalid session", "error", err)
+			slog.Warn("skip invalid session", "source", entry.Label(), "error", err)
 			continue
 		}
+		if entry.ID == "" {
+			entry.ID = entry.deriveID()
+		}
+		if first, dup := seen[entry.ID]; dup {
+			slog.Warn("skip duplicate session", "session", entry.ID, "source", entry.Label(), "first", first)
+			continue
+		}
+		seen[entry.ID] = entry.Label()
+		valid = append(valid, entry)
+	}
+
+	now := time.Now()
+	for _, entry := range p.overlay(valid) {
+		entry.init(now)
 		if entry.Guest {
-			p.guestSessions = append(p.guestSessions, &entry)
+			p.guestSessions = append(p.guestSessions, entry)
 		} else {
-			p.authSessions = append(p.authSessions, &entry)
+			p.authSessions = append(p.authSessions, entry)
 		}
 	}
 
@@ -170,3 +430,22 @@ func (p *Pool) loadFromFile() error {
 	}
 	return nil
 }
+
+// ReadConfigFile 读取 Session 配置文件并返回明文，加密的文件用 key 解密。
+func ReadConfigFile(path string, key *sealed.Key) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("open session config: %w", err)
+	}
+	if !sealed.IsSealed(data) {
+		return data, nil
+	}
+	plaintext, err := sealed.Open(key, data)
+	if err != nil {
+		return nil, fmt.Errorf("session config %s: %w", path, err)
+	}
+	return plaintext, nil
+}
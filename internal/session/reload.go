@@ -0,0 +1,184 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// ReloadResult 汇总一次热加载的差异。
+type ReloadResult struct {
+	Added     []string `json:"added"`
+	Updated   []string `json:"updated"`
+	Removed   []string `json:"removed"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// Reload 重新读取配置文件并与当前池做差异合并：
+// 未变化的 Session 保持原有 *Session 身份，会话绑定得以保留；
+// 同一 ID 的凭证变更会原地替换；新条目加入，缺失条目连同其会话绑定一起移除。
+// 管理接口保存在 Store 中的修改叠加在新配置之上；只存在于内存中的 Session（如自动申请的游客）
+// 不在配置中，原样保留，除非配置中出现了同一 ID。
+// 只要新文件中有任何一条无效，本次加载整体作废，当前池保持不变。
+func (p *Pool) Reload() (ReloadResult, error) {
+	entries, err := readSources(p.configPath, p.configDir, p.key)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+
+	now := time.Now()
+	next := make([]*Session, 0, len(entries))
+	seen := make(map[string]string, len(entries))
+	for i := range entries {
+		entry := &entries[i]
+		if err := entry.validate(); err != nil {
+			return ReloadResult{}, fmt.Errorf("%s: %w", entry.Label(), err)
+		}
+		if entry.ID == "" {
+			entry.ID = entry.deriveID()
+		}
+		if first, dup := seen[entry.ID]; dup {
+			return ReloadResult{}, fmt.Errorf("%s: duplicate id %s (also %s)", entry.Label(), entry.ID, first)
+		}
+		seen[entry.ID] = entry.Label()
+		next = append(next, entry)
+	}
+	next = p.overlay(next)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current := make(map[string]*Session, len(p.authSessions)+len(p.guestSessions))
+	for _, group := range [][]*Session{p.authSessions, p.guestSessions} {
+		for _, s := range group {
+			current[s.ID] = s
+		}
+	}
+
+	var (
+		result ReloadResult
+		auth   []*Session
+		guest  []*Session
+		keep   = make(map[*Session]struct{}, len(next))
+		listed = make(map[string]struct{}, len(next))
+	)
+	for _, entry := range next {
+		listed[entry.ID] = struct{}{}
+		live, ok := current[entry.ID]
+		switch {
+		case ok && live.Guest == entry.Guest && live.ProviderName() == entry.ProviderName():
+			snapshot := live.Snapshot()
+			if sameConfig(&snapshot, entry) {
+				live.setOrigin(entry.origin)
+				result.Unchanged++
+			} else {
+				live.update(entry)
+				result.Updated = append(result.Updated, live.ID)
+			}
+			entry = live
+		case ok:
+			// guest 或 provider 变化意味着账号类别改变，按新 Session 处理。
+			entry.init(now)
+			result.Updated = append(result.Updated, entry.ID)
+		default:
+			entry.init(now)
+			result.Added = append(result.Added, entry.ID)
+		}
+		keep[entry] = struct{}{}
+		if entry.Guest {
+			guest = append(guest, entry)
+		} else {
+			auth = append(auth, entry)
+		}
+	}
+
+	for _, group := range [][]*Session{p.authSessions, p.guestSessions} {
+		for _, s := range group {
+			if _, ok := listed[s.ID]; ok || !s.Snapshot().origin.runtime() {
+				continue
+			}
+			keep[s] = struct{}{}
+			if s.Guest {
+				guest = append(guest, s)
+			} else {
+				auth = append(auth, s)
+			}
+		}
+	}
+
+	for id, s := range current {
+		if _, ok := keep[s]; !ok {
+			if _, replaced := seen[id]; !replaced {
+				result.Removed = append(result.Removed, id)
+			}
+		}
+	}
+	p.authSessions = auth
+	p.guestSessions = guest
+
+	// 被移除或改变了账号类别的 Session 不能继续承载原有会话。
+	live := make(map[string]struct{}, len(keep))
+	for s := range keep {
+		if current[s.ID] == s {
+			live[s.ID] = struct{}{}
+		}
+	}
+	p.pruneBindings(func(id string) bool {
+		_, ok := live[id]
+		return ok
+	})
+	return result, nil
+}
+
+// Watch 以 interval 轮询配置文件、配置目录及被引用文件的修改时间与大小，变化时触发 Reload，直到 ctx 结束。
+func (p *Pool) Watch(ctx context.Context, interval time.Duration) {
+	last := p.sourcesStamp()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stamp := p.sourcesStamp()
+			if stamp == last {
+				continue
+			}
+			p.ReloadAndLog("file changed")
+			// 重载可能改变被引用文件的集合，以重载后的结果为准。
+			last = p.sourcesStamp()
+		}
+	}
+}
+
+// ReloadAndLog 执行 Reload 并记录结果，trigger 用于说明触发原因。
+func (p *Pool) ReloadAndLog(trigger string) {
+	result, err := p.Reload()
+	if err != nil {
+		slog.Error("session reload rejected", "trigger", trigger, "path", p.configPath, "error", err)
+		return
+	}
+	slog.Info("session config reloaded",
+		"trigger", trigger,
+		"added", result.Added,
+		"updated", result.Updated,
+		"removed", result.Removed,
+		"unchanged", result.Unchanged,
+	)
+}
+
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func fileStamp(path string) (stamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return stamp{}, err
+	}
+	return stamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
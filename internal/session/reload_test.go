@@ -0,0 +1,142 @@
+package session
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestReloadKeepsUnchangedSessions(t *testing.T) {
+	p, path := newTestPool(t, Options{}, testEntry("a"), testEntry("b"))
+	a, _ := p.Session("a")
+	p.BindConversation("c1", a)
+
+	writeTestConfig(t, path, testEntry("a"), testEntry("b"), testEntry("d"))
+	result, err := p.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Unchanged != 2 || !slices.Equal(result.Added, []string{"d"}) || len(result.Updated)+len(result.Removed) != 0 {
+		t.Errorf("result = %+v", result)
+	}
+	if s, _ := p.Session("a"); s != a {
+		t.Error("unchanged session replaced")
+	}
+	if s, err := p.GetSession(Criteria{ConversationID: "c1"}); err != nil || s != a {
+		t.Errorf("binding lost: %v, %v", s, err)
+	}
+}
+
+func TestReloadUpdatesCredentialsInPlace(t *testing.T) {
+	p, path := newTestPool(t, Options{}, testEntry("a"))
+	a, _ := p.Session("a")
+	p.BindConversation("c1", a)
+
+	updated := testEntry("a")
+	updated.Cookie = "sessionid=rotated"
+	writeTestConfig(t, path, updated)
+	result, err := p.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Updated, []string{"a"}) || result.Unchanged != 0 {
+		t.Errorf("result = %+v", result)
+	}
+	s, _ := p.Session("a")
+	if s != a {
+		t.Fatal("credential update replaced the session")
+	}
+	if cookie := s.Snapshot().Cookie; cookie != "sessionid=rotated" {
+		t.Errorf("cookie = %q, want the new one", cookie)
+	}
+	if s, err := p.GetSession(Criteria{ConversationID: "c1"}); err != nil || s != a {
+		t.Errorf("binding lost: %v, %v", s, err)
+	}
+}
+
+func TestReloadReplacesOnClassChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Session)
+	}{
+		{"guest", func(s *Session) { s.Guest = true }},
+		{"provider", func(s *Session) { s.Provider = "cici" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, path := newTestPool(t, Options{}, testEntry("a"))
+			a, _ := p.Session("a")
+			p.BindConversation("c1", a)
+
+			changed := testEntry("a")
+			tt.change(&changed)
+			writeTestConfig(t, path, changed)
+			result, err := p.Reload()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(result.Updated, []string{"a"}) || len(result.Removed) != 0 {
+				t.Errorf("result = %+v", result)
+			}
+			s, err := p.Session("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s == a {
+				t.Error("class change kept the old session")
+			}
+			// 账号类别改变后原有会话不能由新 Session 继续。
+			_, err = p.GetSession(Criteria{ConversationID: "c1"})
+			wantStatus(t, err, http.StatusGone)
+		})
+	}
+}
+
+func TestReloadRejectsInvalidEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries func() []Session
+	}{
+		{"missing cookie", func() []Session {
+			bad := testEntry("c")
+			bad.Cookie = ""
+			return []Session{testEntry("a"), bad}
+		}},
+		{"unknown provider", func() []Session {
+			bad := testEntry("c")
+			bad.Provider = "nowhere"
+			return []Session{testEntry("a"), bad}
+		}},
+		{"duplicate id", func() []Session {
+			return []Session{testEntry("a"), testEntry("a")}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, path := newTestPool(t, Options{}, testEntry("a"), testEntry("b"))
+			a, _ := p.Session("a")
+			p.BindConversation("c1", a)
+
+			// 其余条目的改动同样不生效。
+			entries := tt.entries()
+			entries[0].Cookie = "sessionid=rotated"
+			writeTestConfig(t, path, entries...)
+			if _, err := p.Reload(); err == nil {
+				t.Fatal("invalid config accepted")
+			}
+
+			if s, _ := p.Session("a"); s != a || s.Snapshot().Cookie != "sessionid=a" {
+				t.Error("session a changed by a rejected reload")
+			}
+			if _, err := p.Session("b"); err != nil {
+				t.Errorf("session b removed by a rejected reload: %v", err)
+			}
+			if _, err := p.Session("c"); err == nil {
+				t.Error("invalid session added")
+			}
+			if s, err := p.GetSession(Criteria{ConversationID: "c1"}); err != nil || s != a {
+				t.Errorf("binding lost: %v, %v", s, err)
+			}
+		})
+	}
+}
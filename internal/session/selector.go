@@ -0,0 +1,173 @@
+package session
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Selector 决定新会话使用哪个 Session。candidates 保证非空，实现需并发安全。
+type Selector interface {
+	Select(candidates []*Session) *Session
+}
+
+// 可通过配置选择的策略名称。
+const (
+	StrategyRandom        = "random"
+	StrategyRoundRobin    = "round-robin"
+	StrategyLeastInFlight = "least-in-flight"
+	StrategyWeighted      = "weighted"
+	StrategyLatency       = "latency"
+)
+
+// NewSelector 根据策略名称创建 Selector，空名称使用随机策略。
+func NewSelector(strategy string) (Selector, error) {
+	switch strings.ToLower(strings.TrimSpace(strategy)) {
+	case "", StrategyRandom:
+		return newRandomSelector(), nil
+	case StrategyRoundRobin:
+		return &roundRobinSelector{}, nil
+	case StrategyLeastInFlight:
+		return &leastInFlightSelector{rng: newLockedRand()}, nil
+	case StrategyWeighted:
+		return &weightedSelector{rng: newLockedRand()}, nil
+	case StrategyLatency:
+		return &latencySelector{rng: newLockedRand()}, nil
+	default:
+		return nil, fmt.Errorf("unknown session strategy %q", strategy)
+	}
+}
+
+// lockedRand 是可并发使用的随机数源。
+type lockedRand struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newLockedRand() *lockedRand {
+	return &lockedRand{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (r *lockedRand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Intn(n)
+}
+
+type randomSelector struct {
+	rng *lockedRand
+}
+
+func newRandomSelector() *randomSelector {
+	return &randomSelector{rng: newLockedRand()}
+}
+
+func (s *randomSelector) Select(candidates []*Session) *Session {
+	return candidates[s.rng.Intn(len(candidates))]
+}
+
+type roundRobinSelector struct {
+	next atomic.Uint64
+}
+
+func (s *roundRobinSelector) Select(candidates []*Session) *Session {
+	n := s.next.Add(1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// leastInFlightSelector 选择当前在途请求最少的 Session，并列时随机打散。
+type leastInFlightSelector struct {
+	rng *lockedRand
+}
+
+func (s *leastInFlightSelector) Select(candidates []*Session) *Session {
+	offset := s.rng.Intn(len(candidates))
+	var best *Session
+	bestLoad := int64(math.MaxInt64)
+	for i := range candidates {
+		c := candidates[(i+offset)%len(candidates)]
+		if load := c.InFlight(); load < bestLoad {
+			best, bestLoad = c, load
+		}
+	}
+	return best
+}
+
+// weightedSelector 按 Session 的 weight 做加权随机。
+type weightedSelector struct {
+	rng *lockedRand
+}
+
+func (s *weightedSelector) Select(candidates []*Session) *Session {
+	total := 0
+	for _, c := range candidates {
+		total += c.effectiveWeight()
+	}
+	n := s.rng.Intn(total)
+	for _, c := range candidates {
+		n -= c.effectiveWeight()
+		if n < 0 {
+			return c
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// latencySelector 优先选择首字节耗时 EWMA 较低的 Session，并按在途请求数放大，
+// 避免所有流量涌向同一个最快的账号。尚无样本的 Session 会被优先探测。
+type latencySelector struct {
+	rng *lockedRand
+}
+
+func (s *latencySelector) Select(candidates []*Session) *Session {
+	offset := s.rng.Intn(len(candidates))
+	var best *Session
+	bestScore := math.Inf(1)
+	for i := range candidates {
+		c := candidates[(i+offset)%len(candidates)]
+		ewma := c.LatencyEWMA()
+		if ewma == 0 {
+			return c
+		}
+		score := float64(ewma) * float64(c.InFlight()+1)
+		if score < bestScore {
+			best, bestScore = c, score
+		}
+	}
+	return best
+}
+
+// rendezvous 以加权最高随机权重（rendezvous）哈希为 key 挑选 Session：同一 key 在候选集合不变时
+// 总是落在同一个 Session 上；首选 Session 不在候选中时自然落到得分次高者，其余 key 的归属不受影响。
+func rendezvous(key string, candidates []*Session) *Session {
+	var best *Session
+	bestScore := math.Inf(-1)
+	for _, c := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(c.ID))
+		// 把哈希映射到 (0,1) 上的均匀分布，得分 -w/ln(u) 使各 Session 的命中比例与权重成正比。
+		u := (float64(mix64(h.Sum64())>>11) + 0.5) / (1 << 53)
+		if score := -float64(c.effectiveWeight()) / math.Log(u); score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	return best
+}
+
+// mix64 是 splitmix64 的终结步骤。FNV-1a 对末尾字节的扩散很弱，只差最后一个字符的 Session ID
+// （如 a、b、c）得分高度相关，首选 Session 移除后其客户端会集中落到同一个 Session 上。
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
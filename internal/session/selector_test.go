@@ -0,0 +1,229 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// distribute 以 workers 个 goroutine 并发挑选共 picks 次 Session，每次持有并发名额 hold 后释放，
+// 返回各 Session 被选中的次数。
+func distribute(t *testing.T, p *Pool, workers, picks int, hold time.Duration) map[string]int {
+	t.Helper()
+	var (
+		mu     sync.Mutex
+		counts = make(map[string]int)
+		wg     sync.WaitGroup
+		jobs   = make(chan struct{}, picks)
+	)
+	for i := 0; i < picks; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				s, err := p.GetSession(Criteria{})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				release, err := p.Acquire(context.Background(), s)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				counts[s.ID]++
+				mu.Unlock()
+				if hold > 0 {
+					time.Sleep(hold)
+				}
+				release()
+			}
+		}()
+	}
+	wg.Wait()
+	return counts
+}
+
+// wantShare 检查 id 的命中比例与 want 的差距不超过 tolerance。
+func wantShare(t *testing.T, counts map[string]int, id string, want, tolerance float64) {
+	t.Helper()
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	if got := float64(counts[id]) / float64(total); math.Abs(got-want) > tolerance {
+		t.Errorf("%s share = %.3f, want %.3f ± %.3f (counts %v)", id, got, want, tolerance, counts)
+	}
+}
+
+func selectorPool(t *testing.T, strategy string, entries ...Session) *Pool {
+	t.Helper()
+	sel, err := NewSelector(strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := newTestPool(t, Options{Selector: sel}, entries...)
+	return p
+}
+
+func TestRoundRobinDistribution(t *testing.T) {
+	p := selectorPool(t, StrategyRoundRobin, testEntry("a"), testEntry("b"), testEntry("c"))
+	counts := distribute(t, p, 32, 3000, 0)
+	for _, id := range []string{"a", "b", "c"} {
+		if counts[id] != 1000 {
+			t.Errorf("counts = %v, want 1000 each", counts)
+			break
+		}
+	}
+}
+
+func TestWeightedDistribution(t *testing.T) {
+	a, b, c := testEntry("a"), testEntry("b"), testEntry("c")
+	a.Weight, b.Weight, c.Weight = 1, 3, 6
+	p := selectorPool(t, StrategyWeighted, a, b, c)
+	counts := distribute(t, p, 32, 20000, 0)
+	wantShare(t, counts, "a", 0.1, 0.02)
+	wantShare(t, counts, "b", 0.3, 0.02)
+	wantShare(t, counts, "c", 0.6, 0.02)
+}
+
+func TestLeastInFlightDistribution(t *testing.T) {
+	p := selectorPool(t, StrategyLeastInFlight, testEntry("a"), testEntry("b"), testEntry("c"))
+	// a 长期占用 8 个名额，并发 12 个请求时新请求应几乎全部落在 b 与 c 上。
+	a, _ := p.Session("a")
+	for i := 0; i < 8; i++ {
+		release, err := p.Acquire(context.Background(), a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+	}
+	counts := distribute(t, p, 12, 1200, time.Millisecond)
+	wantShare(t, counts, "a", 0, 0.05)
+	wantShare(t, counts, "b", 0.5, 0.1)
+	wantShare(t, counts, "c", 0.5, 0.1)
+}
+
+func TestLatencyDistribution(t *testing.T) {
+	p := selectorPool(t, StrategyLatency, testEntry("a"), testEntry("b"), testEntry("c"))
+	for id, ttfb := range map[string]time.Duration{"a": 100 * time.Millisecond, "b": 200 * time.Millisecond, "c": 400 * time.Millisecond} {
+		s, _ := p.Session(id)
+		p.ObserveTTFB(s, ttfb)
+	}
+	// 并发请求下在途数按首字节耗时的倒数分摊，命中比例约为 4:2:1。
+	counts := distribute(t, p, 14, 1400, time.Millisecond)
+	wantShare(t, counts, "a", 4.0/7, 0.12)
+	wantShare(t, counts, "b", 2.0/7, 0.12)
+	wantShare(t, counts, "c", 1.0/7, 0.1)
+	if !(counts["a"] > counts["b"] && counts["b"] > counts["c"]) {
+		t.Errorf("counts = %v, want a > b > c", counts)
+	}
+}
+
+// affinityMap 返回每个客户端标识在新会话中分配到的 Session ID。
+func affinityMap(t *testing.T, p *Pool, keys int) map[string]string {
+	t.Helper()
+	out := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("client-%d", i)
+		s, err := p.GetSession(Criteria{Affinity: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[key] = s.ID
+	}
+	return out
+}
+
+func TestAffinityIsStable(t *testing.T) {
+	entries := []Session{testEntry("a"), testEntry("b"), testEntry("c"), testEntry("d")}
+	p, path := newTestPool(t, Options{}, entries...)
+	first := affinityMap(t, p, 200)
+	if again := affinityMap(t, p, 200); !maps.Equal(first, again) {
+		t.Error("mapping changed between calls")
+	}
+	// 映射只取决于标识与 Session ID，重启后不变。
+	restarted, err := NewPool(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again := affinityMap(t, restarted, 200); !maps.Equal(first, again) {
+		t.Error("mapping changed after restart")
+	}
+	counts := make(map[string]int)
+	for _, id := range first {
+		counts[id]++
+	}
+	for _, entry := range entries {
+		if counts[entry.ID] < 20 {
+			t.Errorf("session %s got %d of 200 clients: %v", entry.ID, counts[entry.ID], counts)
+		}
+	}
+}
+
+func TestAffinityRemapsOnlyRemovedSession(t *testing.T) {
+	p, _ := newTestPool(t, Options{}, testEntry("a"), testEntry("b"), testEntry("c"), testEntry("d"), testEntry("e"))
+	before := affinityMap(t, p, 500)
+	if err := p.DeleteSession("c"); err != nil {
+		t.Fatal(err)
+	}
+	after := affinityMap(t, p, 500)
+
+	moved := make(map[string]int)
+	for key, id := range before {
+		switch {
+		case id != "c" && after[key] != id:
+			t.Fatalf("%s moved from %s to %s although %s is still present", key, id, after[key], id)
+		case id == "c":
+			moved[after[key]]++
+		}
+	}
+	// c 的客户端分散到其余 Session，而不是集中到同一个。
+	if len(moved) < 3 {
+		t.Errorf("clients of the removed session went to %v", moved)
+	}
+}
+
+func TestAffinityIgnoresCapacity(t *testing.T) {
+	p, _ := newTestPool(t, Options{Lease: LeasePolicy{MaxConcurrent: 1}}, testEntry("a"), testEntry("b"))
+	preferred, err := p.GetSession(Criteria{Affinity: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := p.Acquire(context.Background(), preferred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	// 首选 Session 繁忙时仍然分配给它（由 Acquire 排队），不换到有空闲名额的 Session。
+	for i := 0; i < 10; i++ {
+		s, err := p.GetSession(Criteria{Affinity: "client"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != preferred {
+			t.Fatalf("busy preferred session %s replaced by %s", preferred.ID, s.ID)
+		}
+		if s, _ := p.GetSession(Criteria{}); s == preferred {
+			t.Fatal("busy session picked without affinity")
+		}
+	}
+
+	// 首选 Session 不可用时退到得分次高的 Session。
+	if _, err := p.DrainSession(preferred.ID); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := p.GetSession(Criteria{Affinity: "client"}); err != nil || s == preferred {
+		t.Errorf("draining preferred session still picked: %v, %v", s, err)
+	}
+}
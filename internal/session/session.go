@@ -0,0 +1,314 @@
+package session
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"DoubaoProxy/internal/fingerprint"
+	"DoubaoProxy/internal/provider"
+)
+
+// Session 保存调用豆包接口所需的一组凭证。
+type Session struct {
+	// ID 是 Session 的稳定标识，留空时根据 provider、device_id 与 web_id 派生。
+	ID         string `json:"id,omitempty"`
+	Cookie     string `json:"cookie"`
+	DeviceID   string `json:"device_id"`
+	TeaUUID    string `json:"tea_uuid"`
+	WebID      string `json:"web_id"`
+	RoomID     string `json:"room_id"`
+	XFlowTrace string `json:"x_flow_trace"`
+	Guest      bool   `json:"guest,omitempty"`
+	// Provider 为该 Session 所属的上游后端（doubao 或 cici），留空表示 doubao。
+	Provider string `json:"provider,omitempty"`
+	// Fingerprint 为该 Session 单独指定的客户端指纹，未设置的字段沿用全局配置。
+	Fingerprint *fingerprint.Profile `json:"fingerprint,omitempty"`
+	// Weight 为加权选择策略下的权重，未设置或非正数时按 1 处理。
+	Weight int `json:"weight,omitempty"`
+	// Tags 是运维自定义的标签。
+	Tags []string `json:"tags,omitempty"`
+	// Disabled 为 true 时该 Session 不再接收任何流量（包括已绑定的会话）。
+	Disabled bool `json:"disabled,omitempty"`
+	// MaxConcurrent 为同时进行的上游请求上限，0 表示使用全局默认值。
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// Quota 为滚动窗口内的用量上限，达到上限后在窗口滚动前不再分配。
+	Quota *Quota `json:"quota,omitempty"`
+	// Pacing 控制该 Session 的请求节奏，未设置的字段沿用全局默认值。
+	Pacing *Pacing `json:"pacing,omitempty"`
+	// Proxy 为该 Session 的出站代理（http、https 或 socks5 URL），direct 表示直连，留空沿用全局默认代理。
+	Proxy string `json:"proxy,omitempty"`
+
+	origin origin
+	rt     *runtimeState
+}
+
+// runtimeState 保存 Session 的运行时统计，由 Pool 在加入时初始化。
+type runtimeState struct {
+	// mu 保护 Session 的凭证与配置字段，热加载会在持有写锁时原地替换它们。
+	mu       sync.RWMutex
+	health   *health
+	inFlight atomic.Int64
+	// ttfbEWMA 以纳秒保存首字节耗时的指数加权移动平均，0 表示尚无样本。
+	ttfbEWMA atomic.Int64
+	// draining 为 true 时不再接收新会话，已绑定的会话继续服务。
+	draining atomic.Bool
+	// leaseMu 保护 waiters；inFlight 的增减也在其保护下进行，以保证排队公平。
+	leaseMu sync.Mutex
+	// waiters 是等待空闲并发名额的 FIFO 队列，元素为 chan struct{}。
+	waiters list.List
+	// pace 保存节奏控制的预约状态。
+	pace paceState
+	// quiet 为解析后的静默时段，加载与热加载时根据 Pacing 计算，由 mu 保护。
+	quiet *quietHours
+	// jar 保存上游轮换后的 Cookie，由 mu 保护，首次请求时建立。
+	jar *cookieJar
+}
+
+// ttfbAlpha 是首字节耗时 EWMA 的平滑系数。
+const ttfbAlpha = 0.3
+
+// InFlight 返回该 Session 当前正在进行的上游请求数。
+func (s *Session) InFlight() int64 {
+	if s.rt == nil {
+		return 0
+	}
+	return s.rt.inFlight.Load()
+}
+
+// LatencyEWMA 返回首字节耗时的移动平均，尚无样本时返回 0。
+func (s *Session) LatencyEWMA() time.Duration {
+	if s.rt == nil {
+		return 0
+	}
+	return time.Duration(s.rt.ttfbEWMA.Load())
+}
+
+// Snapshot 返回凭证与配置字段的一致副本。热加载可能原地更新凭证，
+// 发起上游请求时应基于快照读取字段。
+func (s *Session) Snapshot() Session {
+	if s.rt == nil {
+		return *s
+	}
+	s.rt.mu.RLock()
+	defer s.rt.mu.RUnlock()
+	return *s
+}
+
+// update 以 next 中的凭证与配置原地替换当前值，保持 *Session 身份与运行时状态不变。
+// ID、Guest 与 Provider 决定 Session 的身份，不在此处修改。
+func (s *Session) update(next *Session) {
+	// 配置已在加载时校验过。
+	quiet, _ := next.Pacing.quietHours()
+	s.rt.mu.Lock()
+	defer s.rt.mu.Unlock()
+	s.Cookie = next.Cookie
+	s.DeviceID = next.DeviceID
+	s.TeaUUID = next.TeaUUID
+	s.WebID = next.WebID
+	s.RoomID = next.RoomID
+	s.XFlowTrace = next.XFlowTrace
+	s.Fingerprint = next.Fingerprint
+	s.Weight = next.Weight
+	s.Tags = next.Tags
+	s.Disabled = next.Disabled
+	s.MaxConcurrent = next.MaxConcurrent
+	s.Quota = next.Quota
+	s.Proxy = next.Proxy
+	s.Pacing = next.Pacing
+	s.rt.quiet = quiet
+	s.origin = next.origin
+}
+
+// setOrigin 更新条目来源，配置未变但来源文件或引用变化时由热加载调用。
+func (s *Session) setOrigin(o origin) {
+	s.rt.mu.Lock()
+	defer s.rt.mu.Unlock()
+	s.origin = o
+}
+
+// sameConfig 报告两个 Session 的凭证与配置是否完全一致。
+func sameConfig(a, b *Session) bool {
+	return a.Cookie == b.Cookie &&
+		a.DeviceID == b.DeviceID &&
+		a.TeaUUID == b.TeaUUID &&
+		a.WebID == b.WebID &&
+		a.RoomID == b.RoomID &&
+		a.XFlowTrace == b.XFlowTrace &&
+		a.Weight == b.Weight &&
+		a.Disabled == b.Disabled &&
+		a.MaxConcurrent == b.MaxConcurrent &&
+		a.Proxy == b.Proxy &&
+		reflect.DeepEqual(a.Tags, b.Tags) &&
+		reflect.DeepEqual(a.Quota, b.Quota) &&
+		reflect.DeepEqual(a.Pacing, b.Pacing) &&
+		reflect.DeepEqual(a.Fingerprint, b.Fingerprint)
+}
+
+// deriveID 根据设备标识生成稳定的 Session ID，同一账号在重启后保持不变。
+func (s *Session) deriveID() string {
+	sum := sha1.Sum([]byte(s.ProviderName() + "|" + s.DeviceID + "|" + s.WebID))
+	return "s-" + hex.EncodeToString(sum[:])[:10]
+}
+
+// init 补全 ID 并初始化运行时状态，由 Pool 在加入 Session 时调用。
+func (s *Session) init(now time.Time) {
+	if s.ID == "" {
+		s.ID = s.deriveID()
+	}
+	s.rt = &runtimeState{health: newHealth(now)}
+	// 配置已在加载时校验过。
+	s.rt.quiet, _ = s.Pacing.quietHours()
+}
+
+// state 返回当前健康状态，未加入池的 Session 视为 healthy。
+func (s *Session) state(now time.Time) State {
+	if s.rt == nil {
+		return StateHealthy
+	}
+	state, _, _, _, _ := s.rt.health.snapshot(now)
+	return state
+}
+
+func (s *Session) status(now time.Time) Status {
+	st := Status{
+		ID:       s.ID,
+		Provider: s.ProviderName(),
+		Guest:    s.Guest,
+		State:    StateHealthy,
+		InFlight: s.InFlight(),
+	}
+	if s.rt != nil {
+		s.rt.leaseMu.Lock()
+		st.Queued = s.rt.waiters.Len()
+		s.rt.leaseMu.Unlock()
+		state, reason, since, until, failures := s.rt.health.snapshot(now)
+		st.State, st.Reason, st.Since, st.Failures = state, reason, since, failures
+		if !until.IsZero() {
+			st.Until = &until
+		}
+	}
+	return st
+}
+
+// Draining 报告该 Session 是否处于排空状态。
+func (s *Session) Draining() bool {
+	return s.rt != nil && s.rt.draining.Load()
+}
+
+// acceptsNew 报告该 Session 是否可以承接新会话（未禁用且未排空）。
+func (s *Session) acceptsNew() bool {
+	return !s.Snapshot().Disabled && !s.Draining()
+}
+
+// hasTags 报告 Session 是否带有 tags 中的全部标签。
+func (s *Session) hasTags(tags []string) bool {
+	own := s.Snapshot().Tags
+	for _, tag := range tags {
+		if !slices.Contains(own, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Session) effectiveWeight() int {
+	weight := s.Snapshot().Weight
+	if weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+func (s *Session) observeTTFB(d time.Duration) {
+	for {
+		old := s.rt.ttfbEWMA.Load()
+		next := int64(d)
+		if old != 0 {
+			next = int64(ttfbAlpha*float64(d) + (1-ttfbAlpha)*float64(old))
+		}
+		if s.rt.ttfbEWMA.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// ProviderName 返回该 Session 所属后端的名称，未声明时为默认后端。
+func (s *Session) ProviderName() string {
+	if s.Provider == "" {
+		return provider.Default
+	}
+	return s.Provider
+}
+
+// Profile 返回该 Session 在 base 基础上生效的客户端指纹。
+func (s *Session) Profile(base fingerprint.Profile) fingerprint.Profile {
+	if s == nil || s.Fingerprint == nil {
+		return base
+	}
+	return base.Merge(*s.Fingerprint)
+}
+
+// ProxyDirect 作为 Session 的 proxy 时表示不使用全局默认代理、直接连接上游。
+const ProxyDirect = "direct"
+
+// ParseProxy 解析出站代理地址，空串与 direct 返回 nil。
+func ParseProxy(raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.EqualFold(raw, ProxyDirect) {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		// url.Error 会带上原始地址，其中可能有代理密码。
+		return nil, errors.New("invalid proxy URL")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: scheme must be http, https, socks5 or socks5h", u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: host is required", u.Redacted())
+	}
+	return u, nil
+}
+
+func (s *Session) validate() error {
+	switch {
+	case s == nil:
+		return errors.New("nil session")
+	case s.Cookie == "":
+		return errors.New("cookie is required")
+	case s.DeviceID == "":
+		return errors.New("device_id is required")
+	case s.TeaUUID == "":
+		return errors.New("tea_uuid is required")
+	case s.WebID == "":
+		return errors.New("web_id is required")
+	case s.RoomID == "":
+		return errors.New("room_id is required")
+	case s.XFlowTrace == "":
+		return errors.New("x_flow_trace is required")
+	case s.MaxConcurrent < 0:
+		return errors.New("max_concurrent must not be negative")
+	case !provider.Known(s.Provider):
+		return fmt.Errorf("unknown provider %q", s.Provider)
+	}
+	if _, err := ParseProxy(s.Proxy); err != nil {
+		return err
+	}
+	if err := s.Pacing.validate(); err != nil {
+		return err
+	}
+	return s.Quota.validate()
+}
@@ -0,0 +1,306 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"DoubaoProxy/internal/sealed"
+)
+
+// origin 记录 Session 条目的来源，用于错误信息、写回与变更检测。
+type origin struct {
+	// file 为条目所在的配置文件，由管理接口新增的条目为空。
+	file string
+	// label 形如 sessions.d/a.json#0，出现在校验错误与日志中。
+	label string
+	// refs 记录含 ${env:...}/${file:...} 引用的字段（以 . 分隔的 JSON 路径）及其原始写法，
+	// 写回时恢复引用，避免把展开后的凭证写入文件。
+	refs map[string]string
+	// refFiles 为 ${file:...} 引用到的文件，监听变化时一并检查。
+	refFiles []string
+	// seed 为条目在配置中的摘要（叠加管理接口的修改之前），用于判断 Store 中的修改是否仍然适用。
+	seed string
+	// admin 表示条目由管理接口新增并保存在 Store 中。
+	admin bool
+}
+
+// runtime 报告条目是否只存在于内存中（例如游客补充器申请的 Session），既不来自配置文件也不保存在 Store 中。
+func (o origin) runtime() bool {
+	return o.file == "" && !o.admin
+}
+
+// Label 返回条目的来源描述，由管理接口新增的条目返回 "admin"。
+func (s *Session) Label() string {
+	if s.origin.label == "" {
+		return "admin"
+	}
+	return s.origin.label
+}
+
+// refPattern 匹配 ${env:NAME} 与 ${file:/path} 引用。
+var refPattern = regexp.MustCompile(`\$\{(env|file):([^}]*)\}`)
+
+// readSources 依次读取主配置文件与配置目录中的 *.json 文件（按文件名排序），
+// 展开引用并记录每个条目的来源，不做校验。主配置文件不存在且未配置目录时返回 os.ErrNotExist。
+func readSources(path, dir string, key *sealed.Key) ([]Session, error) {
+	entries, err := readEntries(path, key)
+	if err != nil && !(errors.Is(err, os.ErrNotExist) && dir != "") {
+		return nil, err
+	}
+	if dir == "" {
+		return entries, nil
+	}
+
+	files, err := sourceFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		more, err := readEntries(file, key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, more...)
+	}
+	return entries, nil
+}
+
+// sourceFiles 返回配置目录中按文件名排序的 *.json 文件。
+func sourceFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("list session config dir: %w", err)
+	}
+	if files == nil {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("open session config dir: %w", err)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readEntries 读取并解码一个 Session 配置文件，文件内容可以是条目数组或单个条目。
+func readEntries(path string, key *sealed.Key) ([]Session, error) {
+	data, err := ReadConfigFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("decode session config %s: %w", path, err)
+	}
+	items, isList := raw.([]any)
+	switch {
+	case isList:
+	case raw == nil:
+		return nil, nil
+	default:
+		if _, ok := raw.(map[string]any); !ok {
+			return nil, fmt.Errorf("decode session config %s: want an array or an object", path)
+		}
+		items = []any{raw}
+	}
+
+	entries := make([]Session, 0, len(items))
+	for i, item := range items {
+		o := origin{file: path, label: path}
+		if isList {
+			o.label = fmt.Sprintf("%s#%d", path, i)
+		}
+		entry, err := decodeEntry(item, &o)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", o.label, err)
+		}
+		o.seed = configDigest(&entry)
+		entry.origin = o
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// decodeEntry 展开条目中的引用并解码为 Session，未知字段视为错误。
+func decodeEntry(item any, o *origin) (Session, error) {
+	expanded, err := expandRefs(item, "", o)
+	if err != nil {
+		return Session{}, err
+	}
+	data, err := json.Marshal(expanded)
+	if err != nil {
+		return Session{}, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var s Session
+	if err := decoder.Decode(&s); err != nil {
+		return Session{}, err
+	}
+	return s, nil
+}
+
+// expandRefs 递归展开字符串中的 ${env:NAME} 与 ${file:/path} 引用，并把含引用的字段记入 o.refs。
+func expandRefs(v any, path string, o *origin) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			expanded, err := expandRefs(child, joinPath(path, k), o)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = expanded
+		}
+		return v, nil
+	case []any:
+		for i, child := range v {
+			expanded, err := expandRefs(child, joinPath(path, strconv.Itoa(i)), o)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+		return v, nil
+	case string:
+		if !refPattern.MatchString(v) {
+			return v, nil
+		}
+		var refErr error
+		out := refPattern.ReplaceAllStringFunc(v, func(ref string) string {
+			m := refPattern.FindStringSubmatch(ref)
+			value, err := resolveRef(m[1], m[2], o)
+			if err != nil && refErr == nil {
+				refErr = fmt.Errorf("%s: %w", path, err)
+			}
+			return value
+		})
+		if refErr != nil {
+			return nil, refErr
+		}
+		if o.refs == nil {
+			o.refs = make(map[string]string)
+		}
+		o.refs[path] = v
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+func resolveRef(kind, name string, o *origin) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty ${%s:} reference", kind)
+	}
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("env %s is not set", name)
+		}
+		return value, nil
+	default:
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("read referenced file: %w", err)
+		}
+		o.refFiles = append(o.refFiles, name)
+		// 密钥管理工具挂载的文件通常以换行结尾。
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// encodeEntry 编码写回用的条目，含引用的字段恢复为原始写法。
+func encodeEntry(s Session) (any, error) {
+	if len(s.origin.refs) == 0 {
+		return s, nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	for path, ref := range s.origin.refs {
+		restoreRef(m, strings.Split(path, "."), ref)
+	}
+	return m, nil
+}
+
+// restoreRef 把 keys 指向的字段替换为 ref，字段已不存在时忽略。
+func restoreRef(v any, keys []string, ref string) {
+	if len(keys) == 0 {
+		return
+	}
+	last := len(keys) == 1
+	switch v := v.(type) {
+	case map[string]any:
+		child, ok := v[keys[0]]
+		if !ok {
+			return
+		}
+		if last {
+			if _, isString := child.(string); isString {
+				v[keys[0]] = ref
+			}
+			return
+		}
+		restoreRef(child, keys[1:], ref)
+	case []any:
+		i, err := strconv.Atoi(keys[0])
+		if err != nil || i < 0 || i >= len(v) {
+			return
+		}
+		if last {
+			if _, isString := v[i].(string); isString {
+				v[i] = ref
+			}
+			return
+		}
+		restoreRef(v[i], keys[1:], ref)
+	}
+}
+
+// sourcesStamp 汇总主配置文件、配置目录及被引用文件的修改时间与大小，任一变化都会改变结果。
+func (p *Pool) sourcesStamp() string {
+	files := []string{p.configPath}
+	if p.configDir != "" {
+		more, _ := sourceFiles(p.configDir)
+		files = append(files, more...)
+	}
+	p.mu.RLock()
+	for _, s := range p.all() {
+		s.rt.mu.RLock()
+		files = append(files, s.origin.refFiles...)
+		s.rt.mu.RUnlock()
+	}
+	p.mu.RUnlock()
+
+	var b strings.Builder
+	for _, file := range files {
+		st, err := fileStamp(file)
+		if err != nil {
+			fmt.Fprintf(&b, "%s:missing;", file)
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", file, st.modTime.UnixNano(), st.size)
+	}
+	return b.String()
+}
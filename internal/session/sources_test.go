@@ -0,0 +1,217 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rawEntry 返回 testEntry(id) 的 JSON，fields 中的字段覆盖原值（值为原始 JSON）。
+func rawEntry(id string, fields map[string]string) string {
+	values := map[string]string{
+		"id":           `"` + id + `"`,
+		"cookie":       `"sessionid=` + id + `"`,
+		"device_id":    `"device-` + id + `"`,
+		"tea_uuid":     `"tea-` + id + `"`,
+		"web_id":       `"web-` + id + `"`,
+		"room_id":      `"room-` + id + `"`,
+		"x_flow_trace": `"trace-` + id + `"`,
+	}
+	for k, v := range fields {
+		if v == "" {
+			delete(values, k)
+			continue
+		}
+		values[k] = v
+	}
+	parts := make([]string, 0, len(values))
+	for k, v := range values {
+		parts = append(parts, `"`+k+`":`+v)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func TestExpandRefs(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "cookie")
+	writeFile(t, secret, "sessionid=from-file\n")
+	t.Setenv("TEST_DEVICE_ID", "device-from-env")
+	t.Setenv("TEST_UA", "agent-from-env")
+
+	tests := []struct {
+		name    string
+		fields  map[string]string
+		check   func(t *testing.T, s Session)
+		refs    []string
+		wantErr string
+	}{
+		{
+			name:   "env",
+			fields: map[string]string{"device_id": `"${env:TEST_DEVICE_ID}"`},
+			check: func(t *testing.T, s Session) {
+				if s.DeviceID != "device-from-env" {
+					t.Errorf("device_id = %q", s.DeviceID)
+				}
+			},
+			refs: []string{"device_id"},
+		},
+		{
+			name:   "file with trailing newline",
+			fields: map[string]string{"cookie": `"${file:` + secret + `}"`},
+			check: func(t *testing.T, s Session) {
+				if s.Cookie != "sessionid=from-file" {
+					t.Errorf("cookie = %q", s.Cookie)
+				}
+				if len(s.origin.refFiles) != 1 || s.origin.refFiles[0] != secret {
+					t.Errorf("refFiles = %v", s.origin.refFiles)
+				}
+			},
+			refs: []string{"cookie"},
+		},
+		{
+			name:   "embedded and nested",
+			fields: map[string]string{"x_flow_trace": `"04-${env:TEST_DEVICE_ID}-01"`, "fingerprint": `{"user_agent":"${env:TEST_UA}"}`},
+			check: func(t *testing.T, s Session) {
+				if s.XFlowTrace != "04-device-from-env-01" {
+					t.Errorf("x_flow_trace = %q", s.XFlowTrace)
+				}
+				if s.Fingerprint == nil || s.Fingerprint.UserAgent != "agent-from-env" {
+					t.Errorf("fingerprint = %+v", s.Fingerprint)
+				}
+			},
+			refs: []string{"x_flow_trace", "fingerprint.user_agent"},
+		},
+		{
+			name:    "unset env",
+			fields:  map[string]string{"cookie": `"${env:TEST_UNSET_COOKIE}"`},
+			wantErr: "#0: cookie: env TEST_UNSET_COOKIE is not set",
+		},
+		{
+			name:    "missing file",
+			fields:  map[string]string{"cookie": `"${file:` + secret + `.missing}"`},
+			wantErr: "#0: cookie: read referenced file",
+		},
+		{
+			name:    "empty reference",
+			fields:  map[string]string{"web_id": `"${env:}"`},
+			wantErr: "#0: web_id: empty ${env:} reference",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "session.json")
+			writeFile(t, path, "["+rawEntry("a", tt.fields)+"]")
+			entries, err := readEntries(path, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), path+tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, path+tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, entries[0])
+			for _, ref := range tt.refs {
+				if _, ok := entries[0].origin.refs[ref]; !ok {
+					t.Errorf("ref %s not recorded: %v", ref, entries[0].origin.refs)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteBackKeepsRefs(t *testing.T) {
+	t.Setenv("TEST_COOKIE", "sessionid=from-env")
+	path := filepath.Join(t.TempDir(), "session.json")
+	writeFile(t, path, "["+rawEntry("a", map[string]string{"cookie": `"${env:TEST_COOKIE}"`})+"]")
+	p, err := NewPool(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := p.Session("a"); s == nil || s.Snapshot().Cookie != "sessionid=from-env" {
+		t.Fatalf("session a = %+v", s)
+	}
+	if err := p.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "${env:TEST_COOKIE}") || strings.Contains(string(data), "from-env") {
+		t.Errorf("written config does not keep the reference:\n%s", data)
+	}
+}
+
+func TestReadSourcesMergeOrder(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "session.json")
+	dir := filepath.Join(root, "sessions.d")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, "["+rawEntry("main", nil)+"]")
+	writeFile(t, filepath.Join(dir, "b.json"), "["+rawEntry("b0", nil)+","+rawEntry("b1", nil)+"]")
+	writeFile(t, filepath.Join(dir, "a.json"), rawEntry("a", nil))
+	writeFile(t, filepath.Join(dir, "c.json.bak"), "["+rawEntry("ignored", nil)+"]")
+
+	entries, err := readSources(path, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ id, label string }{
+		{"main", path + "#0"},
+		{"a", filepath.Join(dir, "a.json")},
+		{"b0", filepath.Join(dir, "b.json") + "#0"},
+		{"b1", filepath.Join(dir, "b.json") + "#1"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("entries = %d, want %d", len(entries), len(want))
+	}
+	for i, w := range want {
+		if entries[i].ID != w.id || entries[i].Label() != w.label {
+			t.Errorf("entry %d = %s (%s), want %s (%s)", i, entries[i].ID, entries[i].Label(), w.id, w.label)
+		}
+	}
+
+	// 主配置文件不存在时仍然读取配置目录。
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := readSources(path, dir, nil); err != nil || len(entries) != 3 {
+		t.Errorf("without main config: %d entries, %v", len(entries), err)
+	}
+}
+
+func TestSourceErrorLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"invalid entry", "[" + rawEntry("a", nil) + "," + rawEntry("b", map[string]string{"room_id": ""}) + "]", "b.json#1: room_id is required"},
+		{"single object", rawEntry("a", map[string]string{"device_id": ""}), "b.json: device_id is required"},
+		{"unknown field", "[" + rawEntry("a", map[string]string{"cookies": `"x"`}) + "]", `b.json#0: json: unknown field "cookies"`},
+		{"duplicate id", "[" + rawEntry("a", nil) + "," + rawEntry("main", map[string]string{"cookie": `"sessionid=other"`}) + "]", "b.json#1: duplicate id main (also "},
+		{"not a list", `"session"`, "want an array or an object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			p, _ := newTestPool(t, Options{ConfigDir: dir}, testEntry("main"))
+			writeFile(t, filepath.Join(dir, "b.json"), tt.content)
+			_, err := p.Reload()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package session
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"DoubaoProxy/internal/model"
+	"DoubaoProxy/internal/store"
+)
+
+// Quota 是 Session 在滚动窗口内的用量上限，0 表示不限。
+type Quota struct {
+	Messages int `json:"messages,omitempty"`
+	Uploads  int `json:"uploads,omitempty"`
+	Images   int `json:"images,omitempty"`
+	// WindowSeconds 为滚动窗口长度，单位秒，默认 86400（24 小时）。
+	WindowSeconds int `json:"window_s,omitempty"`
+}
+
+func (q *Quota) validate() error {
+	if q == nil {
+		return nil
+	}
+	if q.Messages < 0 || q.Uploads < 0 || q.Images < 0 || q.WindowSeconds < 0 {
+		return errors.New("quota values must not be negative")
+	}
+	return nil
+}
+
+// window 返回滚动窗口长度。
+func (q *Quota) window() time.Duration {
+	if q == nil || q.WindowSeconds <= 0 {
+		return defaultUsageWindow
+	}
+	return time.Duration(q.WindowSeconds) * time.Second
+}
+
+// Usage 是一段时间内的用量。
+type Usage struct {
+	Messages int `json:"messages"`
+	Uploads  int `json:"uploads"`
+	Images   int `json:"images"`
+}
+
+// QuotaCheck 指定挑选 Session 时需要检查哪些额度。
+type QuotaCheck int
+
+const (
+	// QuotaNone 不检查额度，例如删除会话。
+	QuotaNone QuotaCheck = iota
+	// QuotaChat 检查消息与图片生成额度。
+	QuotaChat
+	// QuotaUpload 检查上传额度。
+	QuotaUpload
+)
+
+func (k QuotaCheck) String() string {
+	switch k {
+	case QuotaChat:
+		return "chat"
+	case QuotaUpload:
+		return "upload"
+	default:
+		return "none"
+	}
+}
+
+const (
+	defaultUsageWindow = 24 * time.Hour
+	// usageSlot 为用量计数的时间片长度，窗口按时间片滚动。
+	usageSlot = 5 * time.Minute
+)
+
+// usageTable 按 Session ID 保存用量，首次访问时从 Store 加载，每次变更写回 Store。
+type usageTable struct {
+	mu      sync.Mutex
+	store   store.Store
+	records map[string]*store.Usage
+}
+
+func newUsageTable(st store.Store) *usageTable {
+	return &usageTable{store: st, records: make(map[string]*store.Usage)}
+}
+
+// getLocked 返回 Session 的用量记录。调用方须持有 mu。
+func (u *usageTable) getLocked(sessionID string) *store.Usage {
+	if rec, ok := u.records[sessionID]; ok {
+		return rec
+	}
+	rec := &store.Usage{}
+	if err := store.GetJSON(u.store, store.BucketUsage, sessionID, rec); err != nil && !errors.Is(err, store.ErrNotFound) {
+		slog.Error("load session usage failed", "session", sessionID, "error", err)
+	}
+	u.records[sessionID] = rec
+	return rec
+}
+
+// record 累加一次用量，丢弃超出保留期的时间片后写回 Store。
+func (u *usageTable) record(sessionID string, delta Usage, retention time.Duration, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	rec := u.getLocked(sessionID)
+	cutoff := now.Add(-max(retention, defaultUsageWindow) - usageSlot).Unix()
+	kept := rec.Slots[:0]
+	for _, slot := range rec.Slots {
+		if slot.Start >= cutoff {
+			kept = append(kept, slot)
+		}
+	}
+	rec.Slots = kept
+
+	start := now.Truncate(usageSlot).Unix()
+	if n := len(rec.Slots); n == 0 || rec.Slots[n-1].Start != start {
+		rec.Slots = append(rec.Slots, store.UsageSlot{Start: start})
+	}
+	last := &rec.Slots[len(rec.Slots)-1]
+	last.Messages += delta.Messages
+	last.Uploads += delta.Uploads
+	last.Images += delta.Images
+
+	if err := store.PutJSON(u.store, store.BucketUsage, sessionID, rec); err != nil {
+		slog.Error("save session usage failed", "session", sessionID, "error", err)
+	}
+}
+
+// sum 返回窗口内的用量。
+func (u *usageTable) sum(sessionID string, window time.Duration, now time.Time) Usage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var out Usage
+	for _, slot := range u.inWindowLocked(sessionID, window, now) {
+		out.Messages += slot.Messages
+		out.Uploads += slot.Uploads
+		out.Images += slot.Images
+	}
+	return out
+}
+
+// inWindowLocked 返回仍在窗口内的时间片。调用方须持有 mu。
+func (u *usageTable) inWindowLocked(sessionID string, window time.Duration, now time.Time) []store.UsageSlot {
+	slots := u.getLocked(sessionID).Slots
+	// 时间片结束时间落在窗口内即计入，窗口边界按时间片粒度滚动。
+	cutoff := now.Add(-window - usageSlot).Unix()
+	for i, slot := range slots {
+		if slot.Start > cutoff {
+			return slots[i:]
+		}
+	}
+	return nil
+}
+
+// exhausted 检查 Session 的额度，已用尽时返回耗尽的项目与额度恢复时间。
+func (u *usageTable) exhausted(sessionID string, q *Quota, check QuotaCheck, now time.Time) (string, time.Time) {
+	if q == nil || check == QuotaNone {
+		return "", time.Time{}
+	}
+	type metric struct {
+		name  string
+		limit int
+		value func(store.UsageSlot) int
+	}
+	var metrics []metric
+	switch check {
+	case QuotaChat:
+		metrics = []metric{
+			{"messages", q.Messages, func(s store.UsageSlot) int { return s.Messages }},
+			{"images", q.Images, func(s store.UsageSlot) int { return s.Images }},
+		}
+	case QuotaUpload:
+		metrics = []metric{
+			{"uploads", q.Uploads, func(s store.UsageSlot) int { return s.Uploads }},
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	window := q.window()
+	slots := u.inWindowLocked(sessionID, window, now)
+
+	var (
+		name    string
+		resetAt time.Time
+	)
+	for _, m := range metrics {
+		if m.limit <= 0 {
+			continue
+		}
+		used := 0
+		for _, slot := range slots {
+			used += m.value(slot)
+		}
+		if used < m.limit {
+			continue
+		}
+		// 从最旧的时间片开始滚出窗口，直到用量低于上限。
+		for _, slot := range slots {
+			used -= m.value(slot)
+			if used < m.limit {
+				reset := time.Unix(slot.Start, 0).Add(usageSlot + window)
+				if reset.After(resetAt) {
+					name, resetAt = m.name, reset
+				}
+				break
+			}
+		}
+	}
+	return name, resetAt
+}
+
+// RecordChat 在一次成功的聊天后累加一条消息及生成的图片数。
+func (p *Pool) RecordChat(s *Session, images int) {
+	p.recordUsage(s, Usage{Messages: 1, Images: images})
+}
+
+// RecordUpload 在一次成功的上传后累加上传次数。
+func (p *Pool) RecordUpload(s *Session) {
+	p.recordUsage(s, Usage{Uploads: 1})
+}
+
+func (p *Pool) recordUsage(s *Session, delta Usage) {
+	if s == nil || delta == (Usage{}) {
+		return
+	}
+	p.usage.record(s.ID, delta, s.Snapshot().Quota.window(), p.now())
+}
+
+// quotaExhausted 检查 Session 的额度，已用尽时返回携带 Retry-After 的 429。
+func (p *Pool) quotaExhausted(s *Session, check QuotaCheck, now time.Time) error {
+	name, resetAt := p.usage.exhausted(s.ID, s.Snapshot().Quota, check, now)
+	if name == "" {
+		return nil
+	}
+	return model.NewHTTPError(http.StatusTooManyRequests, "session %s has reached its %s quota until %s", s.ID, name, resetAt.Format(time.RFC3339)).
+		WithRetryAfter(resetAt.Sub(now))
+}
+
+// filterQuota 返回额度未用尽的 Session；全部用尽时返回最早恢复时间。
+func (p *Pool) filterQuota(sessions []*Session, check QuotaCheck, now time.Time) ([]*Session, time.Time) {
+	if check == QuotaNone {
+		return sessions, time.Time{}
+	}
+	out := make([]*Session, 0, len(sessions))
+	var earliest time.Time
+	for _, s := range sessions {
+		name, resetAt := p.usage.exhausted(s.ID, s.Snapshot().Quota, check, now)
+		if name == "" {
+			out = append(out, s)
+			continue
+		}
+		if earliest.IsZero() || resetAt.Before(earliest) {
+			earliest = resetAt
+		}
+	}
+	return out, earliest
+}
+
+// QuotaStatus 是 Session 当前窗口内的用量与上限。
+type QuotaStatus struct {
+	Window string `json:"window"`
+	Used   Usage  `json:"used"`
+	Limit  Quota  `json:"limit"`
+	// ResetAt 为额度耗尽时的恢复时间，未耗尽时为空。
+	ResetAt *time.Time `json:"reset_at,omitempty"`
+}
+
+func (p *Pool) quotaStatus(s *Session, now time.Time) QuotaStatus {
+	q := s.Snapshot().Quota
+	st := QuotaStatus{
+		Window: q.window().String(),
+		Used:   p.usage.sum(s.ID, q.window(), now),
+	}
+	if q != nil {
+		st.Limit = *q
+	}
+	for _, check := range []QuotaCheck{QuotaChat, QuotaUpload} {
+		if name, resetAt := p.usage.exhausted(s.ID, q, check, now); name != "" {
+			if st.ResetAt == nil || resetAt.After(*st.ResetAt) {
+				st.ResetAt = &resetAt
+			}
+		}
+	}
+	return st
+}
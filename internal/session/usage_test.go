@@ -0,0 +1,116 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"DoubaoProxy/internal/model"
+)
+
+// quotaPool 返回时钟由测试控制的池，entries 均带有 quota。
+func quotaPool(t *testing.T, quota Quota, ids ...string) (*Pool, *time.Time) {
+	t.Helper()
+	entries := make([]Session, 0, len(ids))
+	for _, id := range ids {
+		entry := testEntry(id)
+		q := quota
+		entry.Quota = &q
+		entries = append(entries, entry)
+	}
+	p, _ := newTestPool(t, Options{}, entries...)
+	// 时间片从 12:00 开始，当前时间落在片内 1 分钟处。
+	now := time.Date(2026, 1, 1, 12, 1, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	return p, &now
+}
+
+func chat(t *testing.T, p *Pool, id string, n int) {
+	t.Helper()
+	s, err := p.Session(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		p.RecordChat(s, 0)
+	}
+}
+
+func usageOf(t *testing.T, p *Pool, id string) QuotaStatus {
+	t.Helper()
+	for _, info := range p.Sessions() {
+		if info.ID == id {
+			return info.Quota
+		}
+	}
+	t.Fatalf("session %s not listed", id)
+	return QuotaStatus{}
+}
+
+// wantRetryAfter 断言 err 为携带指定 Retry-After 的 429。
+func wantRetryAfter(t *testing.T, err error, want time.Duration) {
+	t.Helper()
+	wantStatus(t, err, http.StatusTooManyRequests)
+	var httpErr *model.HTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter != want {
+		t.Errorf("Retry-After = %v, want %v", httpErr.RetryAfter, want)
+	}
+}
+
+func TestQuotaRollsOverBySlot(t *testing.T) {
+	p, now := quotaPool(t, Quota{Messages: 2, WindowSeconds: 3600}, "a")
+	slotStart := now.Truncate(usageSlot)
+
+	chat(t, p, "a", 1)
+	*now = now.Add(10 * time.Minute)
+	chat(t, p, "a", 1)
+
+	// 第一条消息所在时间片（12:00）结束后满一小时，即 13:05 才滚出窗口。
+	reset := slotStart.Add(usageSlot + time.Hour)
+	_, err := p.GetSession(Criteria{Quota: QuotaChat})
+	wantRetryAfter(t, err, reset.Sub(*now))
+	if st := usageOf(t, p, "a"); st.Used.Messages != 2 || st.ResetAt == nil || !st.ResetAt.Equal(reset) {
+		t.Errorf("quota status = %+v, want 2 used until %s", st, reset)
+	}
+
+	*now = reset.Add(-time.Second)
+	if _, err := p.GetSession(Criteria{Quota: QuotaChat}); err == nil {
+		t.Fatal("quota restored before the slot left the window")
+	}
+	*now = reset
+	if _, err := p.GetSession(Criteria{Quota: QuotaChat}); err != nil {
+		t.Fatalf("quota not restored at %s: %v", reset, err)
+	}
+	if st := usageOf(t, p, "a"); st.Used.Messages != 1 || st.ResetAt != nil {
+		t.Errorf("quota status = %+v, want 1 used and no reset time", st)
+	}
+}
+
+func TestGetSessionSkipsExhaustedSessions(t *testing.T) {
+	p, now := quotaPool(t, Quota{Messages: 1, Uploads: 1}, "a", "b")
+	chat(t, p, "a", 1)
+
+	for i := 0; i < 20; i++ {
+		s, err := p.GetSession(Criteria{Quota: QuotaChat})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.ID != "b" {
+			t.Fatalf("picked exhausted session %s", s.ID)
+		}
+	}
+	_, err := p.GetSession(Criteria{Quota: QuotaChat, Pin: "a"})
+	wantStatus(t, err, http.StatusTooManyRequests)
+	// 消息额度用尽不影响上传。
+	if _, err := p.GetSession(Criteria{Quota: QuotaUpload, Pin: "a"}); err != nil {
+		t.Errorf("upload blocked by message quota: %v", err)
+	}
+
+	// 全部用尽时以最早恢复的 Session 计算 Retry-After。
+	*now = now.Add(time.Hour)
+	chat(t, p, "b", 1)
+	_, err = p.GetSession(Criteria{Quota: QuotaChat})
+	reset := now.Add(-time.Hour).Truncate(usageSlot).Add(usageSlot + defaultUsageWindow)
+	wantRetryAfter(t, err, reset.Sub(*now))
+}
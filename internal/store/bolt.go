@@ -0,0 +1,154 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt 是基于 bbolt 的嵌入式磁盘存储，每个 bucket 对应一个 bbolt bucket。
+type Bolt struct {
+	db *bolt.DB
+}
+
+// OpenBolt 打开（必要时创建）path 处的数据库文件。
+// 同一文件被其他进程占用时在 5 秒后报错，而不是无限等待。
+func OpenBolt(path string) (*Bolt, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create state dir: %w", err)
+		}
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open state store %s: %w", path, err)
+	}
+	return &Bolt{db: db}, nil
+}
+
+// ErrLocked 表示数据库文件正被其他进程（通常是正在运行的服务）以读写方式占用。
+var ErrLocked = errors.New("state store is locked by another process (is the server running?)")
+
+// snapshotLockTimeout 为读取快照时等待文件锁的时间。
+const snapshotLockTimeout = time.Second
+
+// SnapshotBolt 以只读方式打开 path 处的数据库，把全部 bucket 复制到内存存储后立即关闭，不修改数据库文件。
+// 文件不存在时返回空的内存存储；文件被其他进程占用时返回 ErrLocked。
+func SnapshotBolt(path string) (*Memory, error) {
+	m := NewMemory()
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{ReadOnly: true, Timeout: snapshotLockTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("open state store %s: %w", path, ErrLocked)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open state store %s: %w", path, err)
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bk *bolt.Bucket) error {
+			return bk.ForEach(func(k, v []byte) error {
+				return m.Put(string(name), string(k), v)
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read state store %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Get 实现 Store。
+func (b *Bolt) Get(bucket, key string) ([]byte, error) {
+	var out []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte(bucket))
+		if bk == nil {
+			return ErrNotFound
+		}
+		v := bk.Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		// bbolt 返回的切片只在事务内有效。
+		out = append([]byte(nil), v...)
+		return nil
+	})
+	return out, err
+}
+
+// Put 实现 Store。
+func (b *Bolt) Put(bucket, key string, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return bk.Put([]byte(key), value)
+	})
+}
+
+// Delete 实现 Store。
+func (b *Bolt) Delete(bucket, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte(bucket))
+		if bk == nil {
+			return nil
+		}
+		return bk.Delete([]byte(key))
+	})
+}
+
+// Batch 实现 Store，全部操作在同一个读写事务中完成。
+func (b *Bolt) Batch(ops ...Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, op := range ops {
+			if op.Value == nil {
+				bk := tx.Bucket([]byte(op.Bucket))
+				if bk == nil {
+					continue
+				}
+				if err := bk.Delete([]byte(op.Key)); err != nil {
+					return err
+				}
+				continue
+			}
+			bk, err := tx.CreateBucketIfNotExists([]byte(op.Bucket))
+			if err != nil {
+				return err
+			}
+			if err := bk.Put([]byte(op.Key), op.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ForEach 实现 Store，在只读事务中按 key 升序遍历。
+func (b *Bolt) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte(bucket))
+		if bk == nil {
+			return nil
+		}
+		return bk.ForEach(func(k, v []byte) error {
+			return fn(string(k), append([]byte(nil), v...))
+		})
+	})
+}
+
+// Close 实现 Store。
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
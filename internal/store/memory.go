@@ -0,0 +1,96 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+// Memory 是进程内存储，重启后数据丢失。
+type Memory struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemory 创建空的内存存储。
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]map[string][]byte)}
+}
+
+// Get 实现 Store。
+func (m *Memory) Get(bucket, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+// Put 实现 Store。
+func (m *Memory) Put(bucket, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucket]
+	if !ok {
+		b = make(map[string][]byte)
+		m.buckets[bucket] = b
+	}
+	b[key] = append([]byte(nil), value...)
+	return nil
+}
+
+// Delete 实现 Store。
+func (m *Memory) Delete(bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.buckets[bucket], key)
+	return nil
+}
+
+// Batch 实现 Store。
+func (m *Memory) Batch(ops ...Op) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, op := range ops {
+		if op.Value == nil {
+			delete(m.buckets[op.Bucket], op.Key)
+			continue
+		}
+		b, ok := m.buckets[op.Bucket]
+		if !ok {
+			b = make(map[string][]byte)
+			m.buckets[op.Bucket] = b
+		}
+		b[op.Key] = append([]byte(nil), op.Value...)
+	}
+	return nil
+}
+
+// ForEach 实现 Store，按 key 升序遍历，与磁盘实现保持一致。
+func (m *Memory) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	m.mu.RLock()
+	b := m.buckets[bucket]
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	values := make(map[string][]byte, len(b))
+	for _, k := range keys {
+		values[k] = b[k]
+	}
+	m.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(k, values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 实现 Store。
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Binding 记录某个会话由哪个 Session 承载。Session 以稳定 ID 引用，重启与热加载后仍然有效。
+type Binding struct {
+	SessionID string    `json:"session_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// LastUsed 为最近一次成功对话的时间，用于空闲过期与 LRU 淘汰。
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Tombstone 记录一个因过期或容量限制被淘汰的会话绑定，使后续请求得到明确的过期错误。
+type Tombstone struct {
+	ExpiredAt time.Time `json:"expired_at"`
+	Reason    string    `json:"reason"`
+}
+
+// FileMeta 记录一次成功上传的文件及其上传所用的 Session。
+type FileMeta struct {
+	Key        string    `json:"key"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Size       int       `json:"size"`
+	MD5        string    `json:"md5,omitempty"`
+	SessionID  string    `json:"session_id"`
+	Provider   string    `json:"provider"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// Cookie 记录上游通过 Set-Cookie 轮换后的 Session Cookie。
+type Cookie struct {
+	// Seed 为轮换起点（配置中的 Cookie）的摘要，配置改动后记录作废。
+	Seed      string    `json:"seed"`
+	Cookie    string    `json:"cookie"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Override 记录管理接口对 Session 的修改，热加载与重启时叠加在配置之上。
+type Override struct {
+	// Seed 为修改时配置条目的摘要，配置中的条目之后被改动时记录作废；由管理接口新增的 Session 为空。
+	Seed string `json:"seed,omitempty"`
+	// Entry 为管理接口新增的完整条目。
+	Entry json.RawMessage `json:"entry,omitempty"`
+	// Deleted 表示来自配置的 Session 已被管理接口删除。
+	Deleted   bool      `json:"deleted,omitempty"`
+	Weight    int       `json:"weight,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Disabled  bool      `json:"disabled,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Usage 是某个 Session 按时间片累计的用量，时间片按开始时间升序排列。
+type Usage struct {
+	Slots []UsageSlot `json:"slots"`
+}
+
+// UsageSlot 是一个时间片内的用量。
+type UsageSlot struct {
+	// Start 为时间片开始时间的 Unix 秒。
+	Start    int64 `json:"t"`
+	Messages int   `json:"m,omitempty"`
+	Uploads  int   `json:"u,omitempty"`
+	Images   int   `json:"i,omitempty"`
+}
@@ -0,0 +1,124 @@
+// Package store 保存代理需要跨重启保留的状态，例如会话绑定与上传文件元数据。
+// 数据按 bucket/key 组织，值为 JSON；具体实现可以是内存或嵌入式磁盘数据库。
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotFound 表示 bucket 中不存在指定 key。
+var ErrNotFound = errors.New("store: not found")
+
+// 内置的 bucket 名称。
+const (
+	// BucketBindings 保存 conversation_id → Binding。
+	BucketBindings = "bindings"
+	// BucketFiles 保存上传文件 key → FileMeta。
+	BucketFiles = "files"
+	// BucketExpired 保存已淘汰的 conversation_id → Tombstone。
+	BucketExpired = "expired_bindings"
+	// BucketUsage 保存 Session ID → Usage。
+	BucketUsage = "usage"
+	// BucketCookies 保存 Session ID → Cookie。
+	BucketCookies = "cookies"
+	// BucketOverrides 保存 Session ID → Override。
+	BucketOverrides = "session_overrides"
+)
+
+// Store 是持久化状态的最小接口，实现需保证并发安全。
+type Store interface {
+	// Get 返回 key 对应的值，不存在时返回 ErrNotFound。
+	Get(bucket, key string) ([]byte, error)
+	// Put 写入或覆盖 key 对应的值。
+	Put(bucket, key string, value []byte) error
+	// Delete 删除 key，key 不存在时不报错。
+	Delete(bucket, key string) error
+	// Batch 在同一个事务中依次执行 ops，要么全部生效，要么全部不生效。
+	Batch(ops ...Op) error
+	// ForEach 遍历 bucket 中的全部键值，fn 返回错误时停止遍历。
+	// 回调中不得再调用同一 Store 的写方法。
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	// Close 释放底层资源。
+	Close() error
+}
+
+// Op 是 Batch 中的一次写操作，Value 为 nil 时表示删除 Key。
+type Op struct {
+	Bucket string
+	Key    string
+	Value  []byte
+}
+
+// PutOp 返回写入 key 的操作。
+func PutOp(bucket, key string, value []byte) Op {
+	return Op{Bucket: bucket, Key: key, Value: value}
+}
+
+// DeleteOp 返回删除 key 的操作。
+func DeleteOp(bucket, key string) Op {
+	return Op{Bucket: bucket, Key: key}
+}
+
+// 支持的存储类型。
+const (
+	KindMemory = "memory"
+	KindBolt   = "bolt"
+)
+
+// Open 按类型打开存储，path 仅对磁盘存储生效。
+func Open(kind, path string) (Store, error) {
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case "", KindMemory:
+		return NewMemory(), nil
+	case KindBolt:
+		return OpenBolt(path)
+	default:
+		return nil, fmt.Errorf("unknown state store %q (want %s or %s)", kind, KindMemory, KindBolt)
+	}
+}
+
+// OpenSnapshot 读取存储的当前内容，返回内存中的副本，供 doctor、import 等命令行工具使用：
+// 它们能看到服务保存的修改与轮换后的 Cookie，写入只影响副本。内存存储不跨进程共享，此时返回空副本。
+func OpenSnapshot(kind, path string) (Store, error) {
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case "", KindMemory:
+		return NewMemory(), nil
+	case KindBolt:
+		return SnapshotBolt(path)
+	default:
+		return nil, fmt.Errorf("unknown state store %q (want %s or %s)", kind, KindMemory, KindBolt)
+	}
+}
+
+// GetJSON 读取 key 并解码到 v。
+func GetJSON(s Store, bucket, key string, v any) error {
+	data, err := s.Get(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode %s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// PutJSONOp 将 v 编码为 JSON，返回写入 key 的操作。
+func PutJSONOp(bucket, key string, v any) (Op, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return Op{}, fmt.Errorf("encode %s/%s: %w", bucket, key, err)
+	}
+	return PutOp(bucket, key, data), nil
+}
+
+// PutJSON 将 v 编码为 JSON 后写入 key。
+func PutJSON(s Store, bucket, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s/%s: %w", bucket, key, err)
+	}
+	return s.Put(bucket, key, data)
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	bolt, err := OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+
+	for name, st := range map[string]Store{"memory": NewMemory(), "bolt": bolt} {
+		t.Run(name, func(t *testing.T) {
+			if err := st.Put(BucketExpired, "c1", []byte("tomb")); err != nil {
+				t.Fatal(err)
+			}
+			err := st.Batch(
+				PutOp(BucketBindings, "c1", []byte("binding")),
+				DeleteOp(BucketExpired, "c1"),
+				DeleteOp("missing-bucket", "k"),
+			)
+			if err != nil {
+				t.Fatalf("Batch: %v", err)
+			}
+			if v, err := st.Get(BucketBindings, "c1"); err != nil || string(v) != "binding" {
+				t.Errorf("binding = %q, %v", v, err)
+			}
+			if _, err := st.Get(BucketExpired, "c1"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("tombstone: err = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestSnapshotBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	if m, err := SnapshotBolt(path); err != nil || m == nil {
+		t.Fatalf("missing file: %v, %v", m, err)
+	}
+
+	db, err := OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Batch(PutOp(BucketOverrides, "a", []byte("override")), PutOp(BucketCookies, "a", []byte("cookie"))); err != nil {
+		t.Fatal(err)
+	}
+	// 数据库仍被以读写方式打开时，快照报告文件被占用而不是无限等待。
+	if _, err := SnapshotBolt(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("locked file: err = %v, want ErrLocked", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := SnapshotBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for bucket, want := range map[string]string{BucketOverrides: "override", BucketCookies: "cookie"} {
+		if v, err := m.Get(bucket, "a"); err != nil || string(v) != want {
+			t.Errorf("%s = %q, %v; want %q", bucket, v, err, want)
+		}
+	}
+	// 快照上的写入不影响数据库文件。
+	if err := m.Delete(BucketOverrides, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := SnapshotBolt(path); err != nil {
+		t.Fatal(err)
+	} else if _, err := again.Get(BucketOverrides, "a"); err != nil {
+		t.Errorf("snapshot write reached the file: %v", err)
+	}
+}
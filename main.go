@@ -14,31 +14,153 @@ import (
 	"DoubaoProxy/internal/server"
 	"DoubaoProxy/internal/service/doubao"
 	"DoubaoProxy/internal/session"
+	"DoubaoProxy/internal/store"
 )
 
 func main() {
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "doctor":
+			os.Exit(runDoctor(cfg, os.Args[2:]))
+		case "import":
+			os.Exit(runImport(cfg, os.Args[2:]))
+		case "encrypt":
+			os.Exit(runEncrypt(cfg, os.Args[2:]))
+		case "decrypt":
+			os.Exit(runDecrypt(cfg, os.Args[2:]))
+		case "rotate-key":
+			os.Exit(runRotateKey(cfg, os.Args[2:]))
+		case "keygen":
+			os.Exit(runKeygen())
+		}
+	}
 
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 	slog.SetDefault(logger)
 
-	pool, err := session.NewPool(cfg.SessionConfigPath)
+	selector, err := session.NewSelector(cfg.SessionStrategy)
+	if err != nil {
+		logger.Error("invalid session strategy", "error", err)
+		os.Exit(1)
+	}
+
+	affinity, err := handler.ParseAffinity(cfg.SessionAffinity)
+	if err != nil {
+		logger.Error("invalid session affinity", "error", err)
+		os.Exit(1)
+	}
+
+	st, err := store.Open(cfg.StateStore, cfg.StatePath)
+	if err != nil {
+		logger.Error("failed to open state store", "error", err)
+		os.Exit(1)
+	}
+	defer st.Close()
+
+	key, err := sessionKey(cfg)
+	if err != nil {
+		logger.Error("failed to load session key", "error", err)
+		os.Exit(1)
+	}
+
+	pool, err := session.NewPool(cfg.SessionConfigPath, session.Options{
+		Selector: selector,
+		Health: session.HealthPolicy{
+			CooldownBase:     cfg.CooldownBase,
+			CooldownMax:      cfg.CooldownMax,
+			SuspectThreshold: cfg.SuspectThreshold,
+		},
+		Store: st,
+		Bindings: session.BindingPolicy{
+			TTL:        cfg.BindingTTL,
+			MaxEntries: cfg.BindingMaxEntries,
+		},
+		Lease: session.LeasePolicy{
+			MaxConcurrent: cfg.MaxConcurrent,
+			QueueTimeout:  cfg.QueueTimeout,
+		},
+		Pacing: session.PacingPolicy{
+			MinInterval:   cfg.PaceMinInterval,
+			Jitter:        cfg.PaceJitter,
+			RatePerMinute: cfg.PaceRatePerMinute,
+			Burst:         cfg.PaceBurst,
+			GlobalRPS:     cfg.UpstreamMaxRPS,
+		},
+		ConfigDir: cfg.SessionConfigDir,
+		Key:       key,
+	})
 	if err != nil {
 		logger.Error("failed to load session pool", "error", err)
 		os.Exit(1)
 	}
 
-	service := doubao.NewService(pool, cfg, logger)
+	service, err := doubao.NewService(pool, st, cfg, logger)
+	if err != nil {
+		logger.Error("failed to create doubao service", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := service.Close(); err != nil {
+			logger.Error("failed to close doubao service", "error", err)
+		}
+	}()
+
+	if cfg.Probe {
+		probeOnStartup(service, cfg.ProbeTimeout, logger)
+	}
 
 	srv := server.New(cfg, logger, func(r *gin.Engine) {
-		handler.Register(r, service, cfg.AuthToken)
+		handler.Register(r, service, handler.Options{
+			AuthToken:  cfg.AuthToken,
+			APIKeys:    cfg.APIKeys,
+			AdminToken: cfg.AdminToken,
+			Affinity:   affinity,
+		})
+		if cfg.AdminToken != "" {
+			handler.RegisterAdmin(r, pool, cfg.AdminToken, cfg.AdminWriteBack)
+		}
 	})
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	go pool.SweepBindings(ctx, cfg.BindingSweep)
+	if cfg.SessionWatch {
+		go pool.Watch(ctx, cfg.WatchInterval)
+	}
+	go reloadOnSignal(ctx, pool)
+	if cfg.GuestMin > 0 {
+		go service.RunGuestProvisioner(ctx, doubao.GuestPolicy{
+			Min:      cfg.GuestMin,
+			Provider: cfg.GuestProvider,
+			Interval: cfg.GuestInterval,
+		})
+	}
+
 	if err := srv.Run(ctx); err != nil {
 		logger.Error("server exited with error", "error", err)
 		os.Exit(1)
 	}
 }
+
+// reloadOnSignal 在收到 SIGHUP 时重新加载 Session 配置。
+func reloadOnSignal(ctx context.Context, pool *session.Pool) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			pool.ReloadAndLog("SIGHUP")
+		}
+	}
+}
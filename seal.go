@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"DoubaoProxy/internal/config"
+	"DoubaoProxy/internal/sealed"
+	"DoubaoProxy/internal/session"
+)
+
+// configuredKey 按 SESSION_KEY、SESSION_KEY_FILE、SESSION_PASSPHRASE 的顺序返回配置的密钥，均未配置时返回 nil。
+func configuredKey(cfg config.Config) (*sealed.Key, error) {
+	switch {
+	case cfg.SessionKey != "":
+		return sealed.ParseKey(cfg.SessionKey)
+	case cfg.SessionKeyFile != "":
+		return sealed.ReadKeyFile(cfg.SessionKeyFile)
+	case cfg.SessionPassphrase != "":
+		return sealed.Passphrase(cfg.SessionPassphrase)
+	default:
+		return nil, nil
+	}
+}
+
+// sessionKey 返回读取 Session 配置文件所需的密钥。未配置密钥而文件已加密时，
+// 若标准输入是终端则提示输入口令。
+func sessionKey(cfg config.Config) (*sealed.Key, error) {
+	key, err := configuredKey(cfg)
+	if err != nil || key != nil {
+		return key, err
+	}
+	data, err := os.ReadFile(cfg.SessionConfigPath)
+	if err != nil || !sealed.IsSealed(data) {
+		return nil, nil
+	}
+	if !sealed.CanPrompt() {
+		return nil, sealed.ErrNoKey
+	}
+	return sealed.PromptPassphrase("Session config passphrase", false)
+}
+
+// runEncrypt 实现 encrypt 子命令：用配置的密钥加密 Session 配置文件，未配置时提示设置口令。
+func runEncrypt(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	path := fs.String("file", cfg.SessionConfigPath, "要加密的 Session 配置文件")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	data, err := os.ReadFile(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "read session config:", err)
+		return 1
+	}
+	if sealed.IsSealed(data) {
+		fmt.Fprintln(os.Stderr, *path, "is already encrypted; use rotate-key to change the key")
+		return 1
+	}
+
+	key, err := configuredKey(cfg)
+	if err == nil && key == nil {
+		key, err = sealed.PromptPassphrase("New passphrase", true)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "session key:", err)
+		return 1
+	}
+	if err := session.WriteConfigFile(*path, data, key); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "encrypted", *path)
+	return 0
+}
+
+// runDecrypt 实现 decrypt 子命令：把解密后的 Session 配置输出到 stdout，或用 -o 写入文件。
+func runDecrypt(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	path := fs.String("file", cfg.SessionConfigPath, "要解密的 Session 配置文件")
+	out := fs.String("o", "", "写入明文的文件，可与 -file 相同以就地解密；留空输出到 stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg.SessionConfigPath = *path
+	key, err := sessionKey(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "session key:", err)
+		return 1
+	}
+	plaintext, err := session.ReadConfigFile(*path, key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *out == "" {
+		_, _ = os.Stdout.Write(plaintext)
+		return 0
+	}
+	if err := session.WriteConfigFile(*out, plaintext, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "decrypted", *path, "to", *out)
+	return 0
+}
+
+// runRotateKey 实现 rotate-key 子命令：用当前密钥解密后以新密钥重新加密。
+// 新密钥取自 -new-key-file，未指定时提示输入新口令。
+func runRotateKey(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	path := fs.String("file", cfg.SessionConfigPath, "要换密钥的 Session 配置文件")
+	newKeyFile := fs.String("new-key-file", "", "保存新的 base64 原始密钥的文件；留空时提示输入新口令")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg.SessionConfigPath = *path
+	oldKey, err := sessionKey(cfg)
+	if err == nil && oldKey == nil {
+		err = errors.New("no current key configured")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "current session key:", err)
+		return 1
+	}
+	plaintext, err := session.ReadConfigFile(*path, oldKey)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var newKey *sealed.Key
+	if *newKeyFile != "" {
+		newKey, err = sealed.ReadKeyFile(*newKeyFile)
+	} else {
+		newKey, err = sealed.PromptPassphrase("New passphrase", true)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "new session key:", err)
+		return 1
+	}
+	if err := session.WriteConfigFile(*path, plaintext, newKey); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "re-encrypted", *path, "with the new key; update SESSION_KEY/SESSION_KEY_FILE/SESSION_PASSPHRASE and restart the service")
+	return 0
+}
+
+// runKeygen 实现 keygen 子命令：输出一个随机的 base64 原始密钥。
+func runKeygen() int {
+	key, err := sealed.GenerateKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "generate key:", err)
+		return 1
+	}
+	fmt.Println(key)
+	return 0
+}